@@ -1,16 +1,31 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/netip"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/caarlos0/env/v11"
 	"github.com/tinkerborg/open-pulumi-service/internal/handler/api"
 	"github.com/tinkerborg/open-pulumi-service/internal/handler/app"
+	"github.com/tinkerborg/open-pulumi-service/internal/health"
+	"github.com/tinkerborg/open-pulumi-service/internal/logging"
+	"github.com/tinkerborg/open-pulumi-service/internal/metrics"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/audit"
 	"github.com/tinkerborg/open-pulumi-service/internal/service/auth"
 	"github.com/tinkerborg/open-pulumi-service/internal/service/crypto"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/ratelimit"
 	"github.com/tinkerborg/open-pulumi-service/internal/service/state"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/webhook"
 	"github.com/tinkerborg/open-pulumi-service/internal/store"
+	"github.com/tinkerborg/open-pulumi-service/internal/tracing"
 	"github.com/tinkerborg/open-pulumi-service/pkg/router"
 	"github.com/tinkerborg/open-pulumi-service/pkg/router/middleware"
 )
@@ -19,50 +34,188 @@ import (
 // support multiple flavors of services e.g. google KMS, aws KMS etc
 // currently postgres and GCP KMS are the only options, so they're required
 type Config struct {
-	GoogleKeyID   string          `env:"GCP_KMS_KEY_ID,required"`
-	DatabaseURL   string          `env:"DATABASE_URL,required"`
-	ListenAddress string          `env:"LISTEN_ADDRESS" envDefault:"0.0.0.0"`
-	ListenPort    string          `env:"LISTEN_PORT" envDefault:"8080"`
-	AppBaseURL    string          `env:"APP_BASE_URL" envDefault:"http://localhost:8080"`
-	OAuthConfig   app.OAuthConfig `envPrefix:"OAUTH_"`
+	GoogleKeyID       string          `env:"GCP_KMS_KEY_ID,required"`
+	DatabaseURL       string          `env:"DATABASE_URL,required"`
+	DatabaseTimeout   time.Duration   `env:"DATABASE_TIMEOUT" envDefault:"30s"`
+	ListenAddress     string          `env:"LISTEN_ADDRESS" envDefault:"0.0.0.0"`
+	ListenPort        string          `env:"LISTEN_PORT" envDefault:"8080"`
+	MetricsAddress    string          `env:"METRICS_LISTEN_ADDRESS" envDefault:"0.0.0.0"`
+	MetricsPort       string          `env:"METRICS_LISTEN_PORT" envDefault:"9090"`
+	ShutdownTimeout   time.Duration   `env:"SHUTDOWN_TIMEOUT" envDefault:"25s"`
+	DrainDelay        time.Duration   `env:"DRAIN_DELAY" envDefault:"5s"`
+	ReadHeaderTimeout time.Duration   `env:"READ_HEADER_TIMEOUT" envDefault:"10s"`
+	AppBaseURL        string          `env:"APP_BASE_URL" envDefault:"http://localhost:8080"`
+	SessionSecret     string          `env:"SESSION_SECRET"`
+	KeyRotation       time.Duration   `env:"KEY_ROTATION" envDefault:"2160h"`
+	KeyRetention      time.Duration   `env:"KEY_RETENTION" envDefault:"8760h"`
+	TrustedProxies    []netip.Prefix  `env:"TRUSTED_PROXIES" envSeparator:","`
+	OAuthConfig       app.OAuthConfig `envPrefix:"OAUTH_"`
+	APIConfig         api.Config
+	LogConfig         logging.Config
+	RateLimitConfig   ratelimit.Config
 }
 
 func main() {
 	config := Config{}
 	if err := env.Parse(&config); err != nil {
-		log.Fatalf("error parsing configuration: %s", err)
+		fatal("error parsing configuration", err)
+	}
+
+	if err := logging.Setup(config.LogConfig); err != nil {
+		fatal("error setting up logging", err)
 	}
 
 	config.OAuthConfig.AppBaseURL = config.AppBaseURL
+	config.APIConfig.AppBaseURL = config.AppBaseURL
+
+	if tracing.Enabled() {
+		slog.Info("starting tracing")
+		shutdownTracing, err := tracing.Setup(context.Background())
+		if err != nil {
+			fatal("error setting up tracing", err)
+		}
+		defer shutdownTracing(context.Background())
+	}
+
+	slog.Info("starting database service")
+	s, err := store.NewPostgres(config.DatabaseURL, store.Options{Timeout: config.DatabaseTimeout})
+	if err != nil {
+		fatal("error creating store", err)
+	}
+
+	db, err := s.SQL()
+	if err != nil {
+		fatal("error creating store", err)
+	}
+
+	if err := metrics.RegisterDB(db, "postgres"); err != nil {
+		fatal("error registering database metrics", err)
+	}
 
-	log.Print("starting database service")
-	s, err := store.NewPostgres(config.DatabaseURL)
+	slog.Info("starting crypto service")
+	kmsService, err := crypto.NewGoogleKmsCryptoService(config.GoogleKeyID)
 	if err != nil {
-		log.Fatalf("error creating store: %s", err)
+		fatal("error creating crypto service", err)
 	}
 
-	log.Print("starting auth service")
-	authService, err := auth.New(s)
+	cryptoService := crypto.Instrument(kmsService)
+
+	auditService := audit.New(s)
+
+	slog.Info("starting auth service")
+	if config.SessionSecret == "" {
+		slog.Warn("SESSION_SECRET is not set, logins in progress will not survive a restart")
+	}
+
+	authService, err := auth.New(s, cryptoService, auth.Options{
+		SessionSecret: []byte(config.SessionSecret),
+		KeyRotation:   config.KeyRotation,
+		KeyRetention:  config.KeyRetention,
+		Audit:         auditService,
+	})
+	if err != nil {
+		fatal("error creating auth service", err)
+	}
+
+	slog.Info("starting webhook service")
+	webhookService, err := webhook.New(s)
+	if err != nil {
+		fatal("error creating webhook service", err)
+	}
+
+	slog.Info("starting state service")
+	stateService, err := state.New(s, state.Options{Audit: auditService, Webhooks: webhookService})
 	if err != nil {
-		log.Fatalf("error creating auth service: %s", err)
+		fatal("error creating state service", err)
 	}
 
-	log.Print("starting crypto service")
-	cryptoService, err := crypto.NewGoogleKmsCryptoService(config.GoogleKeyID)
+	rateLimitService, err := ratelimit.New(s, authService, config.RateLimitConfig)
 	if err != nil {
-		log.Fatalf("error creating crypto service: %s", err)
+		fatal("error creating rate limiter", err)
 	}
 
-	log.Print("starting state service")
-	stateService := state.New(s)
+	webhookContext, stopWebhooks := context.WithCancel(context.Background())
+	go webhookService.Run(webhookContext)
+
+	healthService := health.New()
+	healthService.Add("database", s.Ping)
+	healthService.Add("migrations", s.Migrated)
+	// KMS calls are billed and rate limited, so probes reuse a recent result
+	healthService.Add("crypto", health.Cached(time.Minute, func(ctx context.Context) error {
+		return crypto.Check(ctx, cryptoService)
+	}))
 
 	r := router.NewRouter()
 
-	r.Use(middleware.Logging, middleware.GzipDecode)
+	r.Use(middleware.TrustedProxies(config.TrustedProxies), middleware.Tracing, metrics.Middleware, middleware.Logging, middleware.Compress, middleware.Recover, middleware.GzipDecode)
+
+	r.Do(healthService.Setup())
 
-	r.Mount("/", app.Setup(authService, stateService, cryptoService, config.OAuthConfig))
-	r.Mount("/api", api.Setup(authService, stateService, cryptoService))
+	r.Mount("/", app.Setup(authService, stateService, cryptoService, auditService, rateLimitService, config.OAuthConfig))
+	r.Mount("/api", api.Setup(authService, stateService, cryptoService, auditService, rateLimitService, config.APIConfig))
+
+	listener, err := net.Listen("tcp", config.ListenAddress+":"+config.ListenPort)
+	if err != nil {
+		fatal("error listening", err)
+	}
+
+	server := &http.Server{Handler: middleware.WithLogAttrs(r), ReadHeaderTimeout: config.ReadHeaderTimeout}
+	server.RegisterOnShutdown(stateService.CloseSubscriptions)
+
+	go func() {
+		if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			fatal("error serving", err)
+		}
+	}()
+
+	// metrics are unauthenticated, so they are served on their own listener rather than the public one
+	metricsRouter := router.NewRouter()
+	metricsRouter.Mount("/metrics", metrics.Setup())
+
+	metricsListener, err := net.Listen("tcp", config.MetricsAddress+":"+config.MetricsPort)
+	if err != nil {
+		fatal("error listening for metrics", err)
+	}
+
+	metricsServer := &http.Server{Handler: metricsRouter, ReadHeaderTimeout: config.ReadHeaderTimeout}
+
+	go func() {
+		if err := metricsServer.Serve(metricsListener); !errors.Is(err, http.ErrServerClosed) {
+			fatal("error serving metrics", err)
+		}
+	}()
+
+	slog.Info("open-pulumi-service is ready", "address", listener.Addr().String(), "metrics", metricsListener.Addr().String())
+
+	signals, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	<-signals.Done()
+
+	slog.Info("shutting down, draining requests")
+	healthService.Drain()
+
+	// endpoints are removed from load balancers some time after readiness fails, and requests
+	// routed here meanwhile would be refused once the listener closes
+	time.Sleep(config.DrainDelay)
+
+	// in-flight requests such as checkpoints and completions are left to finish
+	shutdownContext, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownContext); err != nil {
+		slog.Error("error draining requests", "error", err)
+	}
+
+	stopWebhooks()
+
+	if err := metricsServer.Close(); err != nil {
+		slog.Error("error stopping metrics", "error", err)
+	}
+
+	slog.Info("open-pulumi-service stopped")
+}
 
-	log.Fatal(http.ListenAndServe(config.ListenAddress+":"+config.ListenPort, r))
-	log.Print("open-pulumi-service is ready")
+func fatal(message string, err error) {
+	slog.Error(message, "error", err)
+	os.Exit(1)
 }
@@ -0,0 +1,63 @@
+// Package errs classifies errors by what went wrong rather than where, so that the API can report
+// them with the right status code without knowing which service they came from.
+//
+// Errors are classified by wrapping one of the kinds below, and tested with errors.Is:
+//
+//	fmt.Errorf("stack owner '%s' %w", name, errs.ErrNotFound)
+//	errs.New(errs.ErrInvalidArgument, "invalid team name")
+//	errors.Is(err, errs.ErrNotFound)
+package errs
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The kinds of errors. Errors of no kind are internal errors.
+var (
+	// ErrNotFound is for records that don't exist, or that the caller may not know exist
+	ErrNotFound = errors.New("does not exist")
+	// ErrConflict is for records that already exist
+	ErrConflict = errors.New("already exists")
+	// ErrInvalidArgument is for malformed or out of range input
+	ErrInvalidArgument = errors.New("invalid argument")
+	// ErrForbidden is for requests the caller isn't allowed to make
+	ErrForbidden = errors.New("forbidden")
+	// ErrPreconditionFailed is for requests that aren't possible in the current state, such as
+	// removing an organization's last admin
+	ErrPreconditionFailed = errors.New("precondition failed")
+	// ErrUnavailable is for dependencies that failed or timed out, where retrying may succeed
+	ErrUnavailable = errors.New("unavailable")
+)
+
+// New returns an error with the given text, classified as kind.
+func New(kind error, text string) error {
+	return With(errors.New(text), kind)
+}
+
+// Errorf formats an error like fmt.Errorf, classified as kind.
+func Errorf(kind error, format string, a ...any) error {
+	return With(fmt.Errorf(format, a...), kind)
+}
+
+// With classifies err as kind, keeping its message. It returns nil if err is nil.
+func With(err error, kind error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &kindError{err, kind}
+}
+
+type kindError struct {
+	err  error
+	kind error
+}
+
+func (e *kindError) Error() string {
+	return e.err.Error()
+}
+
+func (e *kindError) Unwrap() []error {
+	return []error{e.err, e.kind}
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/tinkerborg/open-pulumi-service/internal/handler/api/auditlogs"
+	"github.com/tinkerborg/open-pulumi-service/internal/model"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/audit"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/auth"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/state"
+	"github.com/tinkerborg/open-pulumi-service/pkg/router"
+)
+
+// SiteAdmin is a provider identity given as provider:subject, such as github:12345. The subject is
+// the provider's ID for the user, which unlike a login can't be renamed or taken by someone else.
+type SiteAdmin struct {
+	Provider string
+	Subject  string
+}
+
+func (s *SiteAdmin) UnmarshalText(text []byte) error {
+	provider, subject, ok := strings.Cut(string(text), ":")
+	if !ok || provider == "" || subject == "" {
+		return fmt.Errorf("site admin '%s' is not a provider:subject identity", text)
+	}
+
+	*s = SiteAdmin{Provider: provider, Subject: subject}
+
+	return nil
+}
+
+// setupAdmin serves what concerns the whole site rather than one organization, to the users named
+// as site admins.
+func setupAdmin(a *auth.Service, s *state.Service, l *audit.Service, siteAdmins []SiteAdmin) router.Setup {
+	return func(r *router.Router) {
+		r.Do(auditlogs.Setup(l, "/auditlogs", func(r *http.Request) string {
+			return r.URL.Query().Get("organization")
+		}, func(handler router.RouterHandler) router.RouterHandler {
+			return requireSiteAdmin(a, s, siteAdmins, handler)
+		}))
+	}
+}
+
+// requireSiteAdmin only runs the handler for the user token of a user with a site admin's
+// identity. Others get a 404, as with organizations they aren't members of.
+func requireSiteAdmin(a *auth.Service, s *state.Service, siteAdmins []SiteAdmin, handler router.RouterHandler) router.RouterHandler {
+	return func(w *router.ResponseWriter, r *http.Request) error {
+		claims, err := a.GetRequestClaims(r)
+		if err != nil {
+			return w.Error(err)
+		}
+
+		if claims.Type != auth.UserToken {
+			return w.WithStatus(http.StatusNotFound).Errorf("not found")
+		}
+
+		user, err := s.GetUser(r.Context(), claims.ID)
+		if err != nil {
+			return w.Error(err)
+		}
+
+		identities, err := s.ListUserIdentities(r.Context(), user.ID)
+		if err != nil {
+			return w.Error(err)
+		}
+
+		if user.IsServiceAccount() || !isSiteAdmin(identities, siteAdmins) {
+			return w.WithStatus(http.StatusNotFound).Errorf("not found")
+		}
+
+		return handler(w, r)
+	}
+}
+
+// isSiteAdmin reports whether any of a user's identities is a site admin's.
+func isSiteAdmin(identities []model.IdentityRecord, siteAdmins []SiteAdmin) bool {
+	return slices.ContainsFunc(identities, func(identity model.IdentityRecord) bool {
+		return slices.Contains(siteAdmins, SiteAdmin{Provider: identity.Provider, Subject: identity.Subject})
+	})
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/caarlos0/env/v11"
+	"github.com/tinkerborg/open-pulumi-service/internal/model"
+)
+
+func TestSiteAdminsConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []SiteAdmin
+		wantErr bool
+	}{
+		{name: "unset"},
+		{name: "provider identities", value: "github:12345,oidc:https://idp.example.com#a:b", want: []SiteAdmin{
+			{Provider: "github", Subject: "12345"},
+			{Provider: "oidc", Subject: "https://idp.example.com#a:b"},
+		}},
+		{name: "login", value: "jdoe", wantErr: true},
+		{name: "no subject", value: "github:", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := Config{}
+
+			err := env.ParseWithOptions(&config, env.Options{Environment: map[string]string{"SITE_ADMINS": test.value}})
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %t", err, test.wantErr)
+			}
+
+			if !test.wantErr && !slices.Equal(config.SiteAdmins, test.want) {
+				t.Errorf("got %v, want %v", config.SiteAdmins, test.want)
+			}
+		})
+	}
+}
+
+func TestIsSiteAdmin(t *testing.T) {
+	siteAdmins := []SiteAdmin{{Provider: "github", Subject: "12345"}}
+
+	tests := []struct {
+		name       string
+		identities []model.IdentityRecord
+		want       bool
+	}{
+		{name: "admin identity", identities: []model.IdentityRecord{{Provider: "gitlab", Subject: "7"}, {Provider: "github", Subject: "12345"}}, want: true},
+		{name: "same login, other subject", identities: []model.IdentityRecord{{Provider: "github", Subject: "999", Login: "12345"}}},
+		{name: "same subject at another provider", identities: []model.IdentityRecord{{Provider: "gitlab", Subject: "12345"}}},
+		{name: "no identities"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := isSiteAdmin(test.identities, siteAdmins); got != test.want {
+				t.Errorf("got %t, want %t", got, test.want)
+			}
+		})
+	}
+}
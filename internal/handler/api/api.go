@@ -1,18 +1,98 @@
 package api
 
 import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/tinkerborg/open-pulumi-service/internal/handler/api/oauth"
+	"github.com/tinkerborg/open-pulumi-service/internal/handler/api/orgs"
 	"github.com/tinkerborg/open-pulumi-service/internal/handler/api/stacks"
 	"github.com/tinkerborg/open-pulumi-service/internal/handler/api/user"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/audit"
 	"github.com/tinkerborg/open-pulumi-service/internal/service/auth"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/authz"
 	"github.com/tinkerborg/open-pulumi-service/internal/service/crypto"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/ratelimit"
 	"github.com/tinkerborg/open-pulumi-service/internal/service/state"
 	"github.com/tinkerborg/open-pulumi-service/pkg/router"
 )
 
-func Setup(a *auth.Service, s *state.Service, c crypto.Service) router.Setup {
+type Config struct {
+	// CheckpointTimeout is how long queries may take on routes that read or write whole
+	// checkpoints, which can be far larger than other records
+	CheckpointTimeout time.Duration `env:"DATABASE_CHECKPOINT_TIMEOUT" envDefault:"5m"`
+	// SiteAdmins are the identities of the users who may read the audit log of the whole site
+	SiteAdmins []SiteAdmin `env:"SITE_ADMINS" envSeparator:","`
+	// AppBaseURL is where browsers reach the login routes, for links to them
+	AppBaseURL string
+}
+
+func Setup(a *auth.Service, s *state.Service, c crypto.Service, l *audit.Service, limits *ratelimit.Service, config Config) router.Setup {
 	return func(r *router.Router) {
-		r.Use(a.Middleware)
-		r.Mount("/user/", user.Setup(a, s))
-		r.Mount("/stacks/", stacks.Setup(a, s, c))
+		z := authz.New(a, s)
+
+		// every request is limited by address first, so that requests with bad tokens and the
+		// token exchange are limited too
+		r.Use(limits.Address)
+
+		// token exchange authenticates with the exchanged token itself
+		r.Mount("/oauth/", oauth.Setup(a, s, l))
+
+		// audit recording wraps authentication so rejected requests are recorded too, and rate
+		// limiting follows it to tell callers apart by their tokens
+		r.Use(l.Middleware, a.Middleware, auditActor(a, s), limits.Middleware)
+		r.Mount("/user/", user.Setup(a, z, s, config.AppBaseURL))
+		r.Mount("/stacks/", stacks.Setup(a, z, s, c, limits, config.CheckpointTimeout))
+		r.Mount("/orgs/", orgs.Setup(a, s, l))
+		r.Mount("/admin/", setupAdmin(a, s, l, config.SiteAdmins))
 	}
 }
+
+// auditActor attributes audited requests to the authenticated token's user, team or organization.
+// Naming the actor takes a query, so it is only done for the requests that are audited.
+func auditActor(a *auth.Service, s *state.Service) router.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !audit.Audited(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if claims, err := a.GetRequestClaims(r); err == nil {
+				audit.SetActor(r, actor(r.Context(), s, claims))
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func actor(ctx context.Context, s *state.Service, claims *auth.UserClaims) audit.Actor {
+	actor := audit.Actor{
+		Type:    claims.Type,
+		ID:      claims.ID,
+		Name:    claims.ID,
+		TokenID: claims.RegisteredClaims.ID,
+	}
+
+	switch claims.Type {
+	case auth.TeamToken:
+		if team, err := s.GetTeamByID(ctx, claims.ID); err == nil {
+			actor.Name = team.Organization.Name + "/" + team.Name
+		}
+	case auth.OrganizationToken:
+		if organization, err := s.GetOrganizationByID(ctx, claims.ID); err == nil {
+			actor.Name = organization.Name
+		}
+	default:
+		if user, err := s.GetUser(ctx, claims.ID); err == nil {
+			actor.Name = user.GitHubLogin
+			if user.IsServiceAccount() {
+				actor.Type = "service-account"
+			}
+		}
+	}
+
+	return actor
+}
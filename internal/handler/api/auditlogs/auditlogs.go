@@ -0,0 +1,134 @@
+package auditlogs
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/tinkerborg/open-pulumi-service/internal/model"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/audit"
+	"github.com/tinkerborg/open-pulumi-service/pkg/router"
+)
+
+const (
+	defaultAuditPageSize = 100
+	maxAuditPageSize     = 1000
+)
+
+// Setup serves the audit log of a scope under prefix. Organization audit logs are scoped by the
+// organization, and the site audit log by the organization filter if any, so that site admins also
+// see the events that belong to no organization, such as logins.
+func Setup(l *audit.Service, prefix string, scope func(r *http.Request) string, authorize func(router.RouterHandler) router.RouterHandler) router.Setup {
+	return func(r *router.Router) {
+		r.GET(prefix+"/{$}", authorize(func(w *router.ResponseWriter, r *http.Request) error {
+			query := r.URL.Query()
+
+			opts, err := listOptions(scope(r), query)
+			if err != nil {
+				return w.WithStatus(http.StatusBadRequest).Error(err)
+			}
+
+			opts.Limit = defaultAuditPageSize
+			if value := query.Get("pageSize"); value != "" {
+				pageSize, err := strconv.Atoi(value)
+				if err != nil || pageSize < 1 {
+					return w.WithStatus(http.StatusBadRequest).Errorf("invalid page size '%s'", value)
+				}
+				opts.Limit = min(pageSize, maxAuditPageSize)
+			}
+
+			events, err := l.List(r.Context(), opts)
+			if err != nil {
+				return w.Error(err)
+			}
+
+			response := &ListAuditLogsResponse{AuditLogEvents: events}
+			if len(events) == opts.Limit {
+				response.ContinuationToken = strconv.FormatUint(uint64(events[len(events)-1].ID), 10)
+			}
+
+			return w.JSON(response)
+		}))
+
+		r.GET(prefix+"/export/{$}", authorize(func(w *router.ResponseWriter, r *http.Request) error {
+			query := r.URL.Query()
+
+			format, err := audit.ParseFormat(query.Get("format"))
+			if err != nil {
+				return w.WithStatus(http.StatusBadRequest).Error(err)
+			}
+
+			opts, err := listOptions(scope(r), query)
+			if err != nil {
+				return w.WithStatus(http.StatusBadRequest).Error(err)
+			}
+
+			opts.Limit = maxAuditPageSize
+
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			if format == audit.FormatCEF {
+				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			}
+			w.Header().Set("Content-Disposition", "attachment; filename=auditlogs."+format)
+
+			for {
+				events, err := l.List(r.Context(), opts)
+				if err != nil {
+					return w.Error(err)
+				}
+
+				if err := audit.Write(w, format, events); err != nil {
+					return err
+				}
+
+				if len(events) < opts.Limit {
+					return nil
+				}
+
+				opts.BeforeID = events[len(events)-1].ID
+			}
+		}), audit.Action("auditlog.export", func(r *http.Request) audit.Target {
+			return audit.Target{Organization: scope(r)}
+		}))
+	}
+}
+
+// listOptions reads the filters shared by listing and exporting. Times are unix seconds and the
+// stack is project/stack.
+func listOptions(organization string, query url.Values) (audit.ListOptions, error) {
+	opts := audit.ListOptions{
+		Organization: organization,
+		Actor:        query.Get("actor"),
+		Stack:        query.Get("stack"),
+	}
+
+	for _, param := range []struct {
+		name  string
+		value *time.Time
+	}{{"startTime", &opts.Start}, {"endTime", &opts.End}} {
+		if value := query.Get(param.name); value != "" {
+			seconds, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return opts, fmt.Errorf("invalid %s '%s'", param.name, value)
+			}
+			*param.value = time.Unix(seconds, 0)
+		}
+	}
+
+	if value := query.Get("continuationToken"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return opts, fmt.Errorf("invalid continuation token '%s'", value)
+		}
+		opts.BeforeID = uint(id)
+	}
+
+	return opts, nil
+}
+
+type ListAuditLogsResponse struct {
+	AuditLogEvents    []model.AuditEventRecord `json:"auditLogEvents"`
+	ContinuationToken string                   `json:"continuationToken,omitempty"`
+}
@@ -0,0 +1,55 @@
+package auditlogs
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/tinkerborg/open-pulumi-service/internal/service/audit"
+)
+
+func TestListOptions(t *testing.T) {
+	tests := []struct {
+		name         string
+		organization string
+		query        string
+		want         audit.ListOptions
+		wantErr      bool
+	}{
+		{name: "no filters", organization: "acme", want: audit.ListOptions{Organization: "acme"}},
+		{name: "whole site", query: "actor=jdoe", want: audit.ListOptions{Actor: "jdoe"}},
+		{
+			name:         "all filters",
+			organization: "acme",
+			query:        "actor=jdoe&stack=app/prod&startTime=100&endTime=200&continuationToken=42",
+			want: audit.ListOptions{
+				Organization: "acme",
+				Actor:        "jdoe",
+				Stack:        "app/prod",
+				Start:        time.Unix(100, 0),
+				End:          time.Unix(200, 0),
+				BeforeID:     42,
+			},
+		},
+		{name: "invalid start time", query: "startTime=yesterday", wantErr: true},
+		{name: "invalid continuation token", query: "continuationToken=-1", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query, err := url.ParseQuery(test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := listOptions(test.organization, query)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %t", err, test.wantErr)
+			}
+
+			if !test.wantErr && got != test.want {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package hooks
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/pulumi/pulumi/pkg/v3/backend/httpstate/client"
+	"github.com/tinkerborg/open-pulumi-service/internal/model"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/audit"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/state"
+	"github.com/tinkerborg/open-pulumi-service/internal/store"
+	"github.com/tinkerborg/open-pulumi-service/pkg/router"
+)
+
+const (
+	defaultDeliveriesPageSize = 50
+	maxDeliveriesPageSize     = 500
+)
+
+// Setup serves the webhooks of a scope under prefix. Organization webhooks are scoped by the
+// owner alone, stack webhooks by the full stack identifier.
+func Setup(s *state.Service, prefix string, scope func(r *http.Request) client.StackIdentifier, authorize func(router.RouterHandler) router.RouterHandler) router.Setup {
+	target := func(r *http.Request) audit.Target {
+		identifier := scope(r)
+		target := audit.Target{Organization: identifier.Owner, Name: r.PathValue("hook")}
+		if identifier.Project != "" {
+			target.Stack = identifier.Project + "/" + identifier.Stack.String()
+		}
+		return target
+	}
+
+	return func(r *router.Router) {
+		r.GET(prefix+"/{$}", authorize(func(w *router.ResponseWriter, r *http.Request) error {
+			identifier := scope(r)
+
+			hooks, err := s.ListWebhooks(r.Context(), identifier)
+			if err != nil {
+				return w.Error(err)
+			}
+
+			response := []Webhook{}
+			for _, hook := range hooks {
+				response = append(response, createWebhook(identifier, &hook))
+			}
+
+			return w.JSON(response)
+		}))
+
+		r.POST(prefix+"/{$}", authorize(func(w *router.ResponseWriter, r *http.Request) error {
+			identifier := scope(r)
+
+			var request Webhook
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				return w.WithStatus(http.StatusBadRequest).Errorf("invalid webhook: %s", err)
+			}
+
+			hook := request.record()
+
+			if err := s.CreateWebhook(r.Context(), identifier, hook); err != nil {
+				if errors.Is(err, store.ErrExist) {
+					return w.WithStatus(http.StatusConflict).Errorf("webhook already exists")
+				}
+				return w.Error(err)
+			}
+
+			return w.JSON(createWebhook(identifier, hook))
+		}), audit.Action("webhook.create", target))
+
+		r.GET(prefix+"/{hook}/{$}", authorize(func(w *router.ResponseWriter, r *http.Request) error {
+			identifier := scope(r)
+
+			hook, err := s.GetWebhook(r.Context(), identifier, r.PathValue("hook"))
+			if err != nil {
+				return w.Error(err)
+			}
+
+			return w.JSON(createWebhook(identifier, hook))
+		}))
+
+		r.PATCH(prefix+"/{hook}/{$}", authorize(func(w *router.ResponseWriter, r *http.Request) error {
+			identifier := scope(r)
+
+			var request Webhook
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				return w.WithStatus(http.StatusBadRequest).Errorf("invalid webhook: %s", err)
+			}
+
+			hook := request.record()
+			hook.Name = r.PathValue("hook")
+
+			if err := s.UpdateWebhook(r.Context(), identifier, hook); err != nil {
+				return w.Error(err)
+			}
+
+			return w.JSON(createWebhook(identifier, hook))
+		}), audit.Action("webhook.update", target))
+
+		r.DELETE(prefix+"/{hook}/{$}", authorize(func(w *router.ResponseWriter, r *http.Request) error {
+			if err := s.DeleteWebhook(r.Context(), scope(r), r.PathValue("hook")); err != nil {
+				return w.Error(err)
+			}
+
+			w.Write([]byte{})
+			return nil
+		}), audit.Action("webhook.delete", target))
+
+		r.GET(prefix+"/{hook}/deliveries/{$}", authorize(func(w *router.ResponseWriter, r *http.Request) error {
+			pageSize := defaultDeliveriesPageSize
+			if value := r.URL.Query().Get("pageSize"); value != "" {
+				size, err := strconv.Atoi(value)
+				if err != nil || size < 1 {
+					return w.WithStatus(http.StatusBadRequest).Errorf("invalid page size '%s'", value)
+				}
+				pageSize = min(size, maxDeliveriesPageSize)
+			}
+
+			deliveries, err := s.ListWebhookDeliveries(r.Context(), scope(r), r.PathValue("hook"), pageSize)
+			if err != nil {
+				return w.Error(err)
+			}
+
+			return w.JSON(deliveries)
+		}))
+
+		r.POST(prefix+"/{hook}/ping/{$}", authorize(func(w *router.ResponseWriter, r *http.Request) error {
+			if err := s.PingWebhook(r.Context(), scope(r), r.PathValue("hook")); err != nil {
+				return w.Error(err)
+			}
+
+			w.WriteHeader(http.StatusAccepted)
+			return nil
+		}))
+	}
+}
+
+func createWebhook(identifier client.StackIdentifier, hook *model.WebhookRecord) Webhook {
+	filters := hook.Filters
+	if filters == nil {
+		filters = []string{}
+	}
+
+	return Webhook{
+		OrganizationName: identifier.Owner,
+		ProjectName:      hook.ProjectName,
+		StackName:        hook.StackName,
+		Name:             hook.Name,
+		DisplayName:      hook.DisplayName,
+		PayloadURL:       hook.PayloadURL,
+		HasSecret:        hook.Secret != "",
+		Active:           hook.Active,
+		Format:           hook.Format,
+		Filters:          filters,
+	}
+}
+
+// Webhook is both the request and response shape. The secret is write only.
+type Webhook struct {
+	OrganizationName string   `json:"organizationName"`
+	ProjectName      string   `json:"projectName,omitempty"`
+	StackName        string   `json:"stackName,omitempty"`
+	Name             string   `json:"name"`
+	DisplayName      string   `json:"displayName"`
+	PayloadURL       string   `json:"payloadUrl"`
+	Secret           string   `json:"secret,omitempty"`
+	HasSecret        bool     `json:"hasSecret"`
+	Active           bool     `json:"active"`
+	Format           string   `json:"format"`
+	Filters          []string `json:"filters"`
+}
+
+func (h *Webhook) record() *model.WebhookRecord {
+	return &model.WebhookRecord{
+		Name:        h.Name,
+		DisplayName: h.DisplayName,
+		PayloadURL:  h.PayloadURL,
+		Secret:      h.Secret,
+		Active:      h.Active,
+		Format:      h.Format,
+		Filters:     h.Filters,
+	}
+}
@@ -0,0 +1,195 @@
+package oauth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tinkerborg/open-pulumi-service/internal/errs"
+	"github.com/tinkerborg/open-pulumi-service/internal/model"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/audit"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/auth"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/state"
+	"github.com/tinkerborg/open-pulumi-service/internal/store"
+	"github.com/tinkerborg/open-pulumi-service/pkg/router"
+)
+
+const (
+	grantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+	tokenTypeIDToken       = "urn:ietf:params:oauth:token-type:id_token"
+	tokenTypeJWT           = "urn:ietf:params:oauth:token-type:jwt"
+	tokenTypeOrganization  = "urn:pulumi:token-type:access_token:organization"
+	tokenTypeTeam          = "urn:pulumi:token-type:access_token:team"
+	audiencePrefix         = "urn:pulumi:org:"
+	teamScopePrefix        = "team:"
+
+	defaultExpiration = 2 * time.Hour
+)
+
+// Setup serves the token exchange used by CI jobs to trade their OIDC tokens for short-lived access
+// tokens, without storing long-lived secrets.
+func Setup(a *auth.Service, s *state.Service, l *audit.Service) router.Setup {
+	return func(r *router.Router) {
+		r.POST("/token/{$}", func(w *router.ResponseWriter, r *http.Request) error {
+			if err := r.ParseForm(); err != nil {
+				return w.WithStatus(http.StatusBadRequest).Errorf("invalid request: %s", err)
+			}
+
+			if grantType := r.PostForm.Get("grant_type"); grantType != grantTypeTokenExchange {
+				return w.WithStatus(http.StatusBadRequest).Errorf("unsupported grant type '%s'", grantType)
+			}
+
+			switch subjectTokenType := r.PostForm.Get("subject_token_type"); subjectTokenType {
+			case tokenTypeIDToken, tokenTypeJWT:
+			default:
+				return w.WithStatus(http.StatusBadRequest).Errorf("unsupported subject token type '%s'", subjectTokenType)
+			}
+
+			audience := r.PostForm.Get("audience")
+			organizationName, ok := strings.CutPrefix(audience, audiencePrefix)
+			if !ok || organizationName == "" {
+				return w.WithStatus(http.StatusBadRequest).Errorf("audience must be %s<organization>", audiencePrefix)
+			}
+
+			audit.SetTarget(r, audit.Target{Organization: organizationName})
+
+			requestedTokenType := r.PostForm.Get("requested_token_type")
+			scope := r.PostForm.Get("scope")
+
+			policyType, teamName, err := requestedPolicy(requestedTokenType, scope)
+			if err != nil {
+				return w.WithStatus(http.StatusBadRequest).Error(err)
+			}
+
+			expiration, err := requestedExpiration(r.PostForm.Get("expiration"))
+			if err != nil {
+				return w.WithStatus(http.StatusBadRequest).Error(err)
+			}
+
+			subjectToken := r.PostForm.Get("subject_token")
+
+			issuerURL, err := auth.UnverifiedIssuer(subjectToken)
+			if err != nil {
+				return w.WithStatus(http.StatusUnauthorized).Error(err)
+			}
+
+			issuers, err := s.FindOIDCIssuers(r.Context(), organizationName, issuerURL)
+			if err != nil && !errors.Is(err, store.ErrNotFound) {
+				return w.Error(err)
+			}
+
+			if len(issuers) == 0 {
+				return w.WithStatus(http.StatusUnauthorized).Errorf("issuer '%s' is not trusted by organization '%s'", issuerURL, organizationName)
+			}
+
+			claims, err := a.VerifyExternalToken(r.Context(), issuerURL, subjectToken, audience)
+			if errors.Is(err, errs.ErrUnavailable) {
+				return w.Error(err)
+			} else if err != nil {
+				return w.WithStatus(http.StatusUnauthorized).Error(err)
+			}
+
+			subject, _ := claims.GetSubject()
+			audit.SetActor(r, audit.Actor{Type: "oidc", Name: issuerURL + "#" + subject})
+
+			issuer := matchIssuer(issuers, claims, policyType, teamName)
+			if issuer == nil {
+				return w.WithStatus(http.StatusForbidden).Errorf("no policy allows this token exchange")
+			}
+
+			if issuer.MaxExpiration > 0 {
+				expiration = min(expiration, time.Duration(issuer.MaxExpiration)*time.Second)
+			}
+
+			id, tokenType := "", auth.OrganizationToken
+
+			if policyType == model.OIDCPolicyTeam {
+				team, err := s.GetTeam(r.Context(), organizationName, teamName)
+				if err != nil {
+					return w.Error(err)
+				}
+				id, tokenType = team.ID, auth.TeamToken
+			} else {
+				organization, err := s.GetOrganization(r.Context(), organizationName)
+				if err != nil {
+					return w.Error(err)
+				}
+				id = organization.ID
+			}
+
+			token, err := a.CreateToken(r.Context(), id, tokenType, auth.TokenOptions{
+				Name:       "oidc:" + issuer.Name,
+				Expiration: expiration,
+			})
+			if err != nil {
+				return w.Error(err)
+			}
+
+			return w.JSON(&TokenExchangeResponse{
+				AccessToken:     token,
+				IssuedTokenType: requestedTokenType,
+				TokenType:       "Bearer",
+				ExpiresIn:       int(expiration.Seconds()),
+				Scope:           scope,
+			})
+		}, audit.Action("token.exchange", nil), l.Middleware)
+	}
+}
+
+// requestedPolicy returns the policy token type, and team for team tokens, a request asks for.
+func requestedPolicy(requestedTokenType string, scope string) (string, string, error) {
+	switch requestedTokenType {
+	case tokenTypeOrganization:
+		return model.OIDCPolicyOrganization, "", nil
+	case tokenTypeTeam:
+		teamName, ok := strings.CutPrefix(scope, teamScopePrefix)
+		if !ok || teamName == "" {
+			return "", "", fmt.Errorf("team tokens require scope %s<team>", teamScopePrefix)
+		}
+		return model.OIDCPolicyTeam, teamName, nil
+	}
+
+	return "", "", fmt.Errorf("unsupported requested token type '%s'", requestedTokenType)
+}
+
+func requestedExpiration(value string) (time.Duration, error) {
+	if value == "" {
+		return defaultExpiration, nil
+	}
+
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds <= 0 {
+		return 0, fmt.Errorf("invalid expiration '%s'", value)
+	}
+
+	return min(time.Duration(seconds)*time.Second, defaultExpiration), nil
+}
+
+// matchIssuer returns the first issuer with a policy that allows the requested token for the
+// claims.
+func matchIssuer(issuers []model.OIDCIssuerRecord, claims auth.ExternalClaims, policyType string, teamName string) *model.OIDCIssuerRecord {
+	for _, issuer := range issuers {
+		for _, policy := range issuer.Policies {
+			if policy.TokenType != policyType || policy.TeamName != teamName {
+				continue
+			}
+
+			if policy.Matches(claims) {
+				return &issuer
+			}
+		}
+	}
+
+	return nil
+}
+
+type TokenExchangeResponse struct {
+	AccessToken     string `json:"access_token"`
+	IssuedTokenType string `json:"issued_token_type"`
+	TokenType       string `json:"token_type"`
+	ExpiresIn       int    `json:"expires_in"`
+	Scope           string `json:"scope,omitempty"`
+}
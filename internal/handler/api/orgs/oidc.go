@@ -0,0 +1,80 @@
+package orgs
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/tinkerborg/open-pulumi-service/internal/model"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/audit"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/auth"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/state"
+	"github.com/tinkerborg/open-pulumi-service/internal/store"
+	"github.com/tinkerborg/open-pulumi-service/pkg/router"
+)
+
+func setupOIDCIssuers(a *auth.Service, s *state.Service, adminRole []model.OrganizationRole) router.Setup {
+	return func(r *router.Router) {
+		r.GET("/{org}/oidc/issuers/{$}", requireRole(a, s, adminRole, func(w *router.ResponseWriter, r *http.Request) error {
+			issuers, err := s.ListOIDCIssuers(r.Context(), r.PathValue("org"))
+			if err != nil {
+				return w.Error(err)
+			}
+
+			return w.JSON(&ListOIDCIssuersResponse{OIDCIssuers: issuers})
+		}))
+
+		r.POST("/{org}/oidc/issuers/{$}", requireRole(a, s, adminRole, func(w *router.ResponseWriter, r *http.Request) error {
+			issuer := &model.OIDCIssuerRecord{}
+			if err := json.NewDecoder(r.Body).Decode(issuer); err != nil {
+				return w.WithStatus(http.StatusBadRequest).Errorf("invalid issuer: %s", err)
+			}
+
+			if err := s.CreateOIDCIssuer(r.Context(), r.PathValue("org"), issuer); err != nil {
+				if errors.Is(err, store.ErrExist) {
+					return w.WithStatus(http.StatusConflict).Errorf("issuer already exists")
+				}
+				return w.Error(err)
+			}
+
+			return w.JSON(issuer)
+		}), audit.Action("oidc-issuer.create", audit.OrganizationTarget("")))
+
+		r.GET("/{org}/oidc/issuers/{issuer}/{$}", requireRole(a, s, adminRole, func(w *router.ResponseWriter, r *http.Request) error {
+			issuer, err := s.GetOIDCIssuer(r.Context(), r.PathValue("org"), r.PathValue("issuer"))
+			if err != nil {
+				return w.Error(err)
+			}
+
+			return w.JSON(issuer)
+		}))
+
+		r.PATCH("/{org}/oidc/issuers/{issuer}/{$}", requireRole(a, s, adminRole, func(w *router.ResponseWriter, r *http.Request) error {
+			issuer := &model.OIDCIssuerRecord{}
+			if err := json.NewDecoder(r.Body).Decode(issuer); err != nil {
+				return w.WithStatus(http.StatusBadRequest).Errorf("invalid issuer: %s", err)
+			}
+
+			issuer.Name = r.PathValue("issuer")
+
+			if err := s.UpdateOIDCIssuer(r.Context(), r.PathValue("org"), issuer); err != nil {
+				return w.Error(err)
+			}
+
+			return w.JSON(issuer)
+		}), audit.Action("oidc-issuer.update", audit.OrganizationTarget("issuer")))
+
+		r.DELETE("/{org}/oidc/issuers/{issuer}/{$}", requireRole(a, s, adminRole, func(w *router.ResponseWriter, r *http.Request) error {
+			if err := s.DeleteOIDCIssuer(r.Context(), r.PathValue("org"), r.PathValue("issuer")); err != nil {
+				return w.Error(err)
+			}
+
+			w.Write([]byte{})
+			return nil
+		}), audit.Action("oidc-issuer.delete", audit.OrganizationTarget("issuer")))
+	}
+}
+
+type ListOIDCIssuersResponse struct {
+	OIDCIssuers []model.OIDCIssuerRecord `json:"oidcIssuers"`
+}
@@ -0,0 +1,213 @@
+package orgs
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+
+	"github.com/pulumi/pulumi/pkg/v3/backend/httpstate/client"
+	"github.com/tinkerborg/open-pulumi-service/internal/handler/api/auditlogs"
+	"github.com/tinkerborg/open-pulumi-service/internal/handler/api/hooks"
+	"github.com/tinkerborg/open-pulumi-service/internal/model"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/audit"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/auth"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/state"
+	"github.com/tinkerborg/open-pulumi-service/internal/store"
+	"github.com/tinkerborg/open-pulumi-service/pkg/router"
+)
+
+func Setup(a *auth.Service, s *state.Service, l *audit.Service) router.Setup {
+	anyRole := []model.OrganizationRole{model.OrganizationAdmin, model.OrganizationMember}
+	adminRole := []model.OrganizationRole{model.OrganizationAdmin}
+
+	return func(r *router.Router) {
+		r.Do(setupTeams(a, s, anyRole, adminRole))
+		r.Do(setupOIDCIssuers(a, s, adminRole))
+		r.Do(setupServiceAccounts(a, s, adminRole))
+		r.Do(auditlogs.Setup(l, "/{org}/auditlogs", organizationName, func(handler router.RouterHandler) router.RouterHandler {
+			return requireRole(a, s, adminRole, handler)
+		}))
+		r.Do(hooks.Setup(s, "/{org}/hooks", organizationScope, func(handler router.RouterHandler) router.RouterHandler {
+			return requireRole(a, s, adminRole, handler)
+		}))
+
+		r.POST("/", func(w *router.ResponseWriter, r *http.Request) error {
+			var request CreateOrganizationRequest
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				return w.WithStatus(http.StatusBadRequest).Errorf("invalid organization: %s", err)
+			}
+
+			claims, err := a.GetRequestClaims(r)
+			if err != nil {
+				return w.Error(err)
+			}
+
+			user, err := s.GetUser(r.Context(), claims.ID)
+			if err != nil {
+				return w.Error(err)
+			}
+
+			organization := &model.OrganizationRecord{
+				Name:        request.Name,
+				DisplayName: request.DisplayName,
+				AvatarURL:   request.AvatarURL,
+			}
+
+			if request.DefaultStackPermission != nil {
+				permission, err := model.ParseStackPermission(*request.DefaultStackPermission)
+				if err != nil {
+					return w.Error(err)
+				}
+				organization.DefaultStackPermission = permission
+			}
+
+			if err := s.CreateOrganization(r.Context(), organization, user); err != nil {
+				if errors.Is(err, store.ErrExist) {
+					return w.WithStatus(http.StatusConflict).Errorf("organization already exists")
+				}
+				return w.Error(err)
+			}
+
+			return w.JSON(organization)
+		}, audit.Action("organization.create", nil))
+
+		r.GET("/{org}/{$}", requireRole(a, s, anyRole, func(w *router.ResponseWriter, r *http.Request) error {
+			organization, err := s.GetOrganization(r.Context(), r.PathValue("org"))
+			if err != nil {
+				return w.Error(err)
+			}
+
+			return w.JSON(organization)
+		}))
+
+		r.DELETE("/{org}/{$}", requireRole(a, s, adminRole, func(w *router.ResponseWriter, r *http.Request) error {
+			accounts, err := s.ListServiceAccounts(r.Context(), r.PathValue("org"))
+			if err != nil {
+				return w.Error(err)
+			}
+
+			if err := s.DeleteOrganization(r.Context(), r.PathValue("org")); err != nil {
+				return w.Error(err)
+			}
+
+			for _, account := range accounts {
+				if err := a.RevokeTokens(r.Context(), account.ID); err != nil {
+					return w.Error(err)
+				}
+			}
+
+			w.Write([]byte{})
+			return nil
+		}), audit.Action("organization.delete", audit.OrganizationTarget("")))
+
+		r.GET("/{org}/members/{$}", requireRole(a, s, anyRole, func(w *router.ResponseWriter, r *http.Request) error {
+			members, err := s.ListOrganizationMembers(r.Context(), r.PathValue("org"))
+			if err != nil {
+				return w.Error(err)
+			}
+
+			return w.JSON(&ListMembersResponse{Members: members})
+		}))
+
+		r.POST("/{org}/members/{userLogin}/{$}", requireRole(a, s, adminRole, func(w *router.ResponseWriter, r *http.Request) error {
+			role, err := decodeRole(r, model.OrganizationMember)
+			if err != nil {
+				return w.WithStatus(http.StatusBadRequest).Error(err)
+			}
+
+			if err := s.AddOrganizationMember(r.Context(), r.PathValue("org"), r.PathValue("userLogin"), role); err != nil {
+				if errors.Is(err, store.ErrExist) {
+					return w.WithStatus(http.StatusConflict).Errorf("user is already a member")
+				}
+				return w.Error(err)
+			}
+
+			w.Write([]byte{})
+			return nil
+		}), audit.Action("organization.member.add", audit.OrganizationTarget("userLogin")))
+
+		r.PATCH("/{org}/members/{userLogin}/{$}", requireRole(a, s, adminRole, func(w *router.ResponseWriter, r *http.Request) error {
+			role, err := decodeRole(r, "")
+			if err != nil {
+				return w.WithStatus(http.StatusBadRequest).Error(err)
+			}
+
+			if err := s.UpdateOrganizationMember(r.Context(), r.PathValue("org"), r.PathValue("userLogin"), role); err != nil {
+				return w.Error(err)
+			}
+
+			w.Write([]byte{})
+			return nil
+		}), audit.Action("organization.member.update", audit.OrganizationTarget("userLogin")))
+
+		r.DELETE("/{org}/members/{userLogin}/{$}", requireRole(a, s, adminRole, func(w *router.ResponseWriter, r *http.Request) error {
+			if err := s.RemoveOrganizationMember(r.Context(), r.PathValue("org"), r.PathValue("userLogin")); err != nil {
+				return w.Error(err)
+			}
+
+			w.Write([]byte{})
+			return nil
+		}), audit.Action("organization.member.remove", audit.OrganizationTarget("userLogin")))
+	}
+}
+
+func organizationScope(r *http.Request) client.StackIdentifier {
+	return client.StackIdentifier{Owner: r.PathValue("org")}
+}
+
+func organizationName(r *http.Request) string {
+	return r.PathValue("org")
+}
+
+// requireRole only runs the handler when the caller has one of the roles in the organization named
+// by the {org} path parameter. Non-members get a 404 so organization names aren't leaked.
+func requireRole(a *auth.Service, s *state.Service, roles []model.OrganizationRole, handler router.RouterHandler) router.RouterHandler {
+	return func(w *router.ResponseWriter, r *http.Request) error {
+		claims, err := a.GetRequestClaims(r)
+		if err != nil {
+			return w.Error(err)
+		}
+
+		role, err := s.GetOrganizationRole(r.Context(), r.PathValue("org"), claims.ID)
+		if err != nil {
+			return w.Error(err)
+		}
+
+		if !slices.Contains(roles, role) {
+			return w.WithStatus(http.StatusForbidden).Errorf("forbidden")
+		}
+
+		return handler(w, r)
+	}
+}
+
+func decodeRole(r *http.Request, defaultRole model.OrganizationRole) (model.OrganizationRole, error) {
+	var request UpdateMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && !(errors.Is(err, io.EOF) && defaultRole != "") {
+		return "", err
+	}
+
+	if request.Role == "" && defaultRole != "" {
+		return defaultRole, nil
+	}
+
+	return model.ParseOrganizationRole(request.Role)
+}
+
+type CreateOrganizationRequest struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+	AvatarURL   string `json:"avatarUrl"`
+	// DefaultStackPermission is read, write or admin, the column's default of write when unset
+	DefaultStackPermission *int `json:"defaultStackPermission"`
+}
+
+type UpdateMemberRequest struct {
+	Role string `json:"role"`
+}
+
+type ListMembersResponse struct {
+	Members []model.OrganizationMemberRecord `json:"members"`
+}
@@ -0,0 +1,198 @@
+package orgs
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tinkerborg/open-pulumi-service/internal/model"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/audit"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/auth"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/state"
+	"github.com/tinkerborg/open-pulumi-service/internal/store"
+	"github.com/tinkerborg/open-pulumi-service/pkg/router"
+)
+
+func setupServiceAccounts(a *auth.Service, s *state.Service, adminRole []model.OrganizationRole) router.Setup {
+	return func(r *router.Router) {
+		r.GET("/{org}/service-accounts/{$}", requireRole(a, s, adminRole, func(w *router.ResponseWriter, r *http.Request) error {
+			accounts, err := s.ListServiceAccounts(r.Context(), r.PathValue("org"))
+			if err != nil {
+				return w.Error(err)
+			}
+
+			response := &ListServiceAccountsResponse{ServiceAccounts: []ServiceAccount{}}
+
+			for _, account := range accounts {
+				response.ServiceAccounts = append(response.ServiceAccounts, createServiceAccount(&account))
+			}
+
+			return w.JSON(response)
+		}))
+
+		r.POST("/{org}/service-accounts/{$}", requireRole(a, s, adminRole, func(w *router.ResponseWriter, r *http.Request) error {
+			var request CreateServiceAccountRequest
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				return w.WithStatus(http.StatusBadRequest).Errorf("invalid service account: %s", err)
+			}
+
+			role := model.OrganizationMember
+			if request.Role != "" {
+				parsed, err := model.ParseOrganizationRole(request.Role)
+				if err != nil {
+					return w.WithStatus(http.StatusBadRequest).Error(err)
+				}
+				role = parsed
+			}
+
+			account := &model.ServiceUser{
+				GitHubLogin: request.Name,
+				Name:        request.DisplayName,
+			}
+
+			if err := s.CreateServiceAccount(r.Context(), r.PathValue("org"), account, role); err != nil {
+				if errors.Is(err, store.ErrExist) {
+					return w.WithStatus(http.StatusConflict).Errorf("name '%s' is already taken", request.Name)
+				}
+				return w.Error(err)
+			}
+
+			return w.JSON(createServiceAccount(account))
+		}), audit.Action("service-account.create", audit.OrganizationTarget("")))
+
+		r.GET("/{org}/service-accounts/{account}/{$}", requireRole(a, s, adminRole, func(w *router.ResponseWriter, r *http.Request) error {
+			account, err := s.GetServiceAccount(r.Context(), r.PathValue("org"), r.PathValue("account"))
+			if err != nil {
+				return w.Error(err)
+			}
+
+			return w.JSON(createServiceAccount(account))
+		}))
+
+		r.DELETE("/{org}/service-accounts/{account}/{$}", requireRole(a, s, adminRole, func(w *router.ResponseWriter, r *http.Request) error {
+			account, err := s.GetServiceAccount(r.Context(), r.PathValue("org"), r.PathValue("account"))
+			if err != nil {
+				return w.Error(err)
+			}
+
+			if err := s.DeleteServiceAccount(r.Context(), r.PathValue("org"), r.PathValue("account")); err != nil {
+				return w.Error(err)
+			}
+
+			if err := a.RevokeTokens(r.Context(), account.ID); err != nil {
+				return w.Error(err)
+			}
+
+			w.Write([]byte{})
+			return nil
+		}), audit.Action("service-account.delete", audit.OrganizationTarget("account")))
+
+		r.POST("/{org}/service-accounts/{account}/tokens/{$}", requireRole(a, s, adminRole, func(w *router.ResponseWriter, r *http.Request) error {
+			var request CreateTokenRequest
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				return w.WithStatus(http.StatusBadRequest).Errorf("invalid request: %s", err)
+			}
+
+			account, err := s.GetServiceAccount(r.Context(), r.PathValue("org"), r.PathValue("account"))
+			if err != nil {
+				return w.Error(err)
+			}
+
+			token, err := a.CreateToken(r.Context(), account.ID, auth.UserToken, auth.TokenOptions{Name: request.Name})
+			if err != nil {
+				return w.Error(err)
+			}
+
+			return w.JSON(&CreateTokenResponse{TokenValue: token})
+		}), audit.Action("service-account.token.create", audit.OrganizationTarget("account")))
+
+		r.GET("/{org}/service-accounts/{account}/tokens/{$}", requireRole(a, s, adminRole, func(w *router.ResponseWriter, r *http.Request) error {
+			account, err := s.GetServiceAccount(r.Context(), r.PathValue("org"), r.PathValue("account"))
+			if err != nil {
+				return w.Error(err)
+			}
+
+			tokens, err := a.ListTokens(r.Context(), account.ID)
+			if err != nil {
+				return w.Error(err)
+			}
+
+			response := &ListTokensResponse{Tokens: []AccessToken{}}
+
+			for _, token := range tokens {
+				response.Tokens = append(response.Tokens, createAccessToken(&token))
+			}
+
+			return w.JSON(response)
+		}))
+
+		r.DELETE("/{org}/service-accounts/{account}/tokens/{token}/{$}", requireRole(a, s, adminRole, func(w *router.ResponseWriter, r *http.Request) error {
+			tokenID, err := strconv.Atoi(r.PathValue("token"))
+			if err != nil {
+				return w.WithStatus(http.StatusNotFound).Errorf("token '%s' not found", r.PathValue("token"))
+			}
+
+			account, err := s.GetServiceAccount(r.Context(), r.PathValue("org"), r.PathValue("account"))
+			if err != nil {
+				return w.Error(err)
+			}
+
+			if err := a.RevokeToken(r.Context(), account.ID, tokenID); err != nil {
+				return w.Error(err)
+			}
+
+			w.Write([]byte{})
+			return nil
+		}), audit.Action("service-account.token.revoke", audit.OrganizationTarget("account")))
+	}
+}
+
+func createAccessToken(token *model.AuthToken) AccessToken {
+	accessToken := AccessToken{
+		ID:   strconv.Itoa(token.ID),
+		Name: token.Name,
+	}
+
+	if !token.CreatedAt.IsZero() {
+		accessToken.Created = token.CreatedAt.UTC().Format(time.RFC3339)
+	}
+
+	return accessToken
+}
+
+func createServiceAccount(account *model.ServiceUser) ServiceAccount {
+	return ServiceAccount{
+		Name:        account.GitHubLogin,
+		DisplayName: account.Name,
+		AvatarURL:   account.AvatarURL,
+	}
+}
+
+type ServiceAccount struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+	AvatarURL   string `json:"avatarUrl"`
+}
+
+type ListServiceAccountsResponse struct {
+	ServiceAccounts []ServiceAccount `json:"serviceAccounts"`
+}
+
+// AccessToken describes an issued token, without its value.
+type AccessToken struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Created string `json:"created,omitempty"`
+}
+
+type ListTokensResponse struct {
+	Tokens []AccessToken `json:"tokens"`
+}
+
+type CreateServiceAccountRequest struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+	Role        string `json:"role,omitempty"`
+}
@@ -0,0 +1,35 @@
+package orgs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tinkerborg/open-pulumi-service/internal/model"
+)
+
+func TestCreateAccessToken(t *testing.T) {
+	tests := []struct {
+		name  string
+		token model.AuthToken
+		want  AccessToken
+	}{
+		{
+			name:  "named",
+			token: model.AuthToken{ID: 7, Name: "ci", CreatedAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.FixedZone("", 3600))},
+			want:  AccessToken{ID: "7", Name: "ci", Created: "2026-01-02T02:04:05Z"},
+		},
+		{
+			name:  "created before creation times were recorded",
+			token: model.AuthToken{ID: 3},
+			want:  AccessToken{ID: "3"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := createAccessToken(&test.token); got != test.want {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
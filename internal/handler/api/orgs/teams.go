@@ -0,0 +1,243 @@
+package orgs
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/tinkerborg/open-pulumi-service/internal/model"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/audit"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/auth"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/state"
+	"github.com/tinkerborg/open-pulumi-service/internal/store"
+	"github.com/tinkerborg/open-pulumi-service/pkg/router"
+)
+
+const pulumiTeamKind = "pulumi"
+
+func setupTeams(a *auth.Service, s *state.Service, anyRole, adminRole []model.OrganizationRole) router.Setup {
+	return func(r *router.Router) {
+		r.GET("/{org}/teams/{$}", requireRole(a, s, anyRole, func(w *router.ResponseWriter, r *http.Request) error {
+			teams, err := s.ListTeams(r.Context(), r.PathValue("org"))
+			if err != nil {
+				return w.Error(err)
+			}
+
+			response := &ListTeamsResponse{Teams: []Team{}}
+
+			for _, team := range teams {
+				response.Teams = append(response.Teams, createTeam(&team))
+			}
+
+			return w.JSON(response)
+		}))
+
+		r.POST("/{org}/teams/pulumi/{$}", requireRole(a, s, adminRole, func(w *router.ResponseWriter, r *http.Request) error {
+			var request CreateTeamRequest
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				return w.WithStatus(http.StatusBadRequest).Errorf("invalid team: %s", err)
+			}
+
+			team := &model.TeamRecord{
+				Name:        request.Name,
+				DisplayName: request.DisplayName,
+				Description: request.Description,
+			}
+
+			if err := s.CreateTeam(r.Context(), r.PathValue("org"), team); err != nil {
+				if errors.Is(err, store.ErrExist) {
+					return w.WithStatus(http.StatusConflict).Errorf("team already exists")
+				}
+				return w.Error(err)
+			}
+
+			return w.JSON(createTeam(team))
+		}), audit.Action("team.create", audit.OrganizationTarget("")))
+
+		r.GET("/{org}/teams/{team}/{$}", requireRole(a, s, anyRole, func(w *router.ResponseWriter, r *http.Request) error {
+			org, teamName := r.PathValue("org"), r.PathValue("team")
+
+			team, err := s.GetTeam(r.Context(), org, teamName)
+			if err != nil {
+				return w.Error(err)
+			}
+
+			members, err := s.ListTeamMembers(r.Context(), org, teamName)
+			if err != nil {
+				return w.Error(err)
+			}
+
+			grants, err := s.ListTeamStackGrants(r.Context(), org, teamName)
+			if err != nil {
+				return w.Error(err)
+			}
+
+			response := createTeam(team)
+
+			for _, member := range members {
+				response.Members = append(response.Members, TeamMember{
+					Name:        member.User.Name,
+					GitHubLogin: member.User.GitHubLogin,
+					AvatarURL:   member.User.AvatarURL,
+					Role:        "member",
+				})
+			}
+
+			for _, grant := range grants {
+				stackName := grant.Stack
+				if stackName == model.AllStacks {
+					stackName = ""
+				}
+
+				response.Stacks = append(response.Stacks, TeamStackPermission{
+					ProjectName: grant.Project,
+					StackName:   stackName,
+					Permission:  int(grant.Permission),
+				})
+			}
+
+			return w.JSON(response)
+		}))
+
+		r.PATCH("/{org}/teams/{team}/{$}", requireRole(a, s, adminRole, func(w *router.ResponseWriter, r *http.Request) error {
+			org, teamName := r.PathValue("org"), r.PathValue("team")
+
+			var request UpdateTeamRequest
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				return w.WithStatus(http.StatusBadRequest).Errorf("invalid request: %s", err)
+			}
+
+			if request.NewDisplayName != nil || request.NewDescription != nil {
+				if err := s.UpdateTeam(r.Context(), org, teamName, request.NewDisplayName, request.NewDescription); err != nil {
+					return w.Error(err)
+				}
+			}
+
+			switch request.MemberAction {
+			case "":
+			case "add":
+				if err := s.AddTeamMember(r.Context(), org, teamName, request.Member); err != nil {
+					if errors.Is(err, store.ErrExist) {
+						return w.WithStatus(http.StatusConflict).Errorf("user is already a member")
+					}
+					return w.Error(err)
+				}
+			case "remove":
+				if err := s.RemoveTeamMember(r.Context(), org, teamName, request.Member); err != nil {
+					return w.Error(err)
+				}
+			default:
+				return w.WithStatus(http.StatusBadRequest).Errorf("invalid member action '%s'", request.MemberAction)
+			}
+
+			if grant := request.AddStackPermission; grant != nil {
+				permission, err := model.ParseStackPermission(grant.Permission)
+				if err != nil {
+					return w.WithStatus(http.StatusBadRequest).Error(err)
+				}
+
+				if err := s.GrantTeamStackPermission(r.Context(), org, teamName, grant.ProjectName, grant.StackName, permission); err != nil {
+					return w.Error(err)
+				}
+			}
+
+			if revoke := request.RemoveStack; revoke != nil {
+				if err := s.RevokeTeamStackPermission(r.Context(), org, teamName, revoke.ProjectName, revoke.StackName); err != nil {
+					return w.Error(err)
+				}
+			}
+
+			w.Write([]byte{})
+			return nil
+		}), audit.Action("team.update", audit.OrganizationTarget("team")))
+
+		r.DELETE("/{org}/teams/{team}/{$}", requireRole(a, s, adminRole, func(w *router.ResponseWriter, r *http.Request) error {
+			if err := s.DeleteTeam(r.Context(), r.PathValue("org"), r.PathValue("team")); err != nil {
+				return w.Error(err)
+			}
+
+			w.Write([]byte{})
+			return nil
+		}), audit.Action("team.delete", audit.OrganizationTarget("team")))
+
+		r.POST("/{org}/teams/{team}/tokens/{$}", requireRole(a, s, adminRole, func(w *router.ResponseWriter, r *http.Request) error {
+			var request CreateTokenRequest
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				return w.WithStatus(http.StatusBadRequest).Errorf("invalid request: %s", err)
+			}
+
+			team, err := s.GetTeam(r.Context(), r.PathValue("org"), r.PathValue("team"))
+			if err != nil {
+				return w.Error(err)
+			}
+
+			token, err := a.CreateToken(r.Context(), team.ID, auth.TeamToken, auth.TokenOptions{Name: request.Name})
+			if err != nil {
+				return w.Error(err)
+			}
+
+			return w.JSON(&CreateTokenResponse{TokenValue: token})
+		}), audit.Action("team.token.create", audit.OrganizationTarget("team")))
+	}
+}
+
+func createTeam(team *model.TeamRecord) Team {
+	return Team{
+		Kind:        pulumiTeamKind,
+		Name:        team.Name,
+		DisplayName: team.DisplayName,
+		Description: team.Description,
+		Members:     []TeamMember{},
+		Stacks:      []TeamStackPermission{},
+	}
+}
+
+type Team struct {
+	Kind        string                `json:"kind"`
+	Name        string                `json:"name"`
+	DisplayName string                `json:"displayName"`
+	Description string                `json:"description"`
+	Members     []TeamMember          `json:"members"`
+	Stacks      []TeamStackPermission `json:"stacks"`
+}
+
+type TeamMember struct {
+	Name        string `json:"name"`
+	GitHubLogin string `json:"githubLogin"`
+	AvatarURL   string `json:"avatarUrl"`
+	Role        string `json:"role"`
+}
+
+// TeamStackPermission with an empty stack name applies to the whole project.
+type TeamStackPermission struct {
+	ProjectName string `json:"projectName"`
+	StackName   string `json:"stackName"`
+	Permission  int    `json:"permission"`
+}
+
+type ListTeamsResponse struct {
+	Teams []Team `json:"teams"`
+}
+
+type CreateTeamRequest struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+	Description string `json:"description"`
+}
+
+type UpdateTeamRequest struct {
+	NewDisplayName     *string              `json:"newDisplayName,omitempty"`
+	NewDescription     *string              `json:"newDescription,omitempty"`
+	MemberAction       string               `json:"memberAction,omitempty"`
+	Member             string               `json:"member,omitempty"`
+	AddStackPermission *TeamStackPermission `json:"addStackPermission,omitempty"`
+	RemoveStack        *TeamStackPermission `json:"removeStack,omitempty"`
+}
+
+type CreateTokenRequest struct {
+	Name string `json:"name"`
+}
+
+type CreateTokenResponse struct {
+	TokenValue string `json:"tokenValue"`
+}
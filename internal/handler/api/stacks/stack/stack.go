@@ -3,54 +3,65 @@ package stack
 import (
 	"encoding/base64"
 	"encoding/json"
-	"io"
+	"log/slog"
 	"net/http"
-	"strings"
+	"time"
 
 	"github.com/pulumi/pulumi/pkg/v3/backend/httpstate/client"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/tokens"
+	"github.com/tinkerborg/open-pulumi-service/internal/handler/api/hooks"
 	"github.com/tinkerborg/open-pulumi-service/internal/handler/api/stacks/stack/update"
 	"github.com/tinkerborg/open-pulumi-service/internal/model"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/audit"
 	"github.com/tinkerborg/open-pulumi-service/internal/service/auth"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/authz"
 	"github.com/tinkerborg/open-pulumi-service/internal/service/crypto"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/ratelimit"
 	"github.com/tinkerborg/open-pulumi-service/internal/service/state"
+	"github.com/tinkerborg/open-pulumi-service/internal/store"
 	"github.com/tinkerborg/open-pulumi-service/internal/util"
 	"github.com/tinkerborg/open-pulumi-service/pkg/router"
 	"github.com/tinkerborg/open-pulumi-service/pkg/router/middleware"
 )
 
-func Setup(a *auth.Service, s *state.Service, c crypto.Service) router.Setup {
+func Setup(a *auth.Service, z *authz.Service, s *state.Service, c crypto.Service, limits *ratelimit.Service, checkpointTimeout time.Duration) router.Setup {
+	authorize := z.Stacks(StackIdentifier.Value)
+	checkpointQueries := store.Timeout(checkpointTimeout)
+
 	return func(r *router.Router) {
-		r.WithPrefix("/{owner}/{project}/{stack}", StackIdentifier.Middleware).Do(func(r *router.Router) {
-			r.Mount("/", update.Setup(a, s, StackIdentifier))
+		r.WithPrefix("/{owner}/{project}/{stack}", StackIdentifier.Middleware, logStack).Do(func(r *router.Router) {
+			r.Mount("/", update.Setup(a, z, s, StackIdentifier, limits, checkpointTimeout))
+			r.Do(hooks.Setup(s, "/hooks", StackIdentifier.Value, func(handler router.RouterHandler) router.RouterHandler {
+				return authorize.Require(authz.PermissionAdmin, handler)
+			}))
 
-			r.GET("/{$}", func(w *router.ResponseWriter, r *http.Request) error {
+			r.GET("/{$}", authorize.Require(authz.PermissionRead, func(w *router.ResponseWriter, r *http.Request) error {
 				identifier := StackIdentifier.Value(r)
 
-				stack, err := s.GetStack(identifier)
+				stack, err := s.GetStack(r.Context(), identifier)
 				if err != nil {
 					return w.Error(err)
 				}
 
 				return w.JSON(stack)
-			})
+			}))
 
-			r.DELETE("/", func(w *router.ResponseWriter, r *http.Request) error {
+			r.DELETE("/", authorize.Require(authz.PermissionAdmin, func(w *router.ResponseWriter, r *http.Request) error {
 				// TODO - delete resources associated with stack
 				identifier := StackIdentifier.Value(r)
-				if err := s.DeleteStack(identifier); err != nil {
+				if err := s.DeleteStack(r.Context(), identifier); err != nil {
 					return w.Error(err)
 				}
 				w.Write([]byte{})
 				return nil
-			})
+			}), audit.Action("stack.delete", auditTarget))
 
-			r.GET("/resources/{version}/{$}", func(w *router.ResponseWriter, r *http.Request) error {
+			r.GET("/resources/{version}/{$}", authorize.Require(authz.PermissionRead, func(w *router.ResponseWriter, r *http.Request) error {
 				identifier := StackIdentifier.Value(r)
 				version := r.PathValue("version")
 
-				stack, err := s.GetStack(identifier)
+				stack, err := s.GetStack(r.Context(), identifier)
 				if err != nil {
 					return w.Error(err)
 				}
@@ -60,12 +71,21 @@ func Setup(a *auth.Service, s *state.Service, c crypto.Service) router.Setup {
 					return w.Error(err)
 				}
 
-				update, err := s.GetStackUpdate(identifier, version)
+				update, err := s.GetStackUpdate(r.Context(), identifier, version)
 				if err != nil {
 					return w.Error(err)
 				}
 
-				resources, err := s.ListStackResources(client.UpdateIdentifier{UpdateID: update.UpdateID})
+				// checkpoints of completed updates never change, so clients can keep them by update
+				if isComplete(update) && w.NotModified(update.UpdateID) {
+					return nil
+				}
+
+				if !limits.Export(w, r) {
+					return nil
+				}
+
+				resources, err := s.ListStackResources(r.Context(), client.UpdateIdentifier{UpdateID: update.UpdateID})
 				if err != nil {
 					return w.Error(err)
 				}
@@ -74,20 +94,35 @@ func Setup(a *auth.Service, s *state.Service, c crypto.Service) router.Setup {
 					Resources: resources,
 					Version:   versionNumber,
 				})
-			})
+			}), audit.Action("stack.resources.read", auditTarget), checkpointQueries)
 
-			r.GET("/export/{$}", func(w *router.ResponseWriter, r *http.Request) error {
+			r.GET("/export/{$}", authorize.Require(authz.PermissionRead, func(w *router.ResponseWriter, r *http.Request) error {
 				identifier := StackIdentifier.Value(r)
 
-				deployment, err := s.GetStackDeployment(identifier)
+				// the active update is always a completed one, checked first so that clients with
+				// the current deployment don't cause it to be read
+				stack, err := s.GetStack(r.Context(), identifier)
+				if err != nil {
+					return w.Error(err)
+				}
+
+				if stack.ActiveUpdate != "" && w.NotModified(stack.ActiveUpdate) {
+					return nil
+				}
+
+				if !limits.Export(w, r) {
+					return nil
+				}
+
+				deployment, err := s.GetStackDeployment(r.Context(), stack)
 				if err != nil {
 					return w.Error(err)
 				}
 
 				return w.JSON(deployment)
-			})
+			}), audit.Action("stack.export", auditTarget), checkpointQueries)
 
-			r.POST("/encrypt/{$}", func(w *router.ResponseWriter, r *http.Request) error {
+			r.POST("/encrypt/{$}", authorize.Require(authz.PermissionRead, func(w *router.ResponseWriter, r *http.Request) error {
 				ctx := r.Context()
 
 				var request apitype.EncryptValueRequest
@@ -97,21 +132,17 @@ func Setup(a *auth.Service, s *state.Service, c crypto.Service) router.Setup {
 
 				encrypted, err := c.Encrypt(ctx, request.Plaintext)
 				if err != nil {
-					return w.Errorf("encryption failed: %s", err)
+					return w.Errorf("encryption failed: %w", err)
 				}
 
 				return w.JSON(&apitype.EncryptValueResponse{
 					Ciphertext: encrypted,
 				})
-			})
+			}), limits.Decrypt)
 
-			r.POST("/decrypt/{$}", func(w *router.ResponseWriter, r *http.Request) error {
+			r.POST("/decrypt/{$}", authorize.Require(authz.PermissionWrite, func(w *router.ResponseWriter, r *http.Request) error {
 				ctx := r.Context()
 
-				buf := new(strings.Builder)
-				io.Copy(buf, r.Body)
-
-				// TODO check if this works
 				var request apitype.DecryptValueRequest
 				if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
 					return w.WithStatus(http.StatusBadRequest).Errorf("invalid payload: %s", err)
@@ -119,15 +150,15 @@ func Setup(a *auth.Service, s *state.Service, c crypto.Service) router.Setup {
 
 				decrypted, err := c.Decrypt(ctx, request.Ciphertext)
 				if err != nil {
-					return w.Errorf("decryption failed: %s", err)
+					return w.Errorf("decryption failed: %w", err)
 				}
 
 				return w.JSON(apitype.DecryptValueResponse{
 					Plaintext: decrypted,
 				})
-			})
+			}), audit.Action("stack.decrypt", auditTarget), limits.Decrypt)
 
-			r.POST("/batch-decrypt/{$}", func(w *router.ResponseWriter, r *http.Request) error {
+			r.POST("/batch-decrypt/{$}", authorize.Require(authz.PermissionWrite, func(w *router.ResponseWriter, r *http.Request) error {
 				ctx := r.Context()
 
 				var request apitype.BatchDecryptRequest
@@ -143,7 +174,7 @@ func Setup(a *auth.Service, s *state.Service, c crypto.Service) router.Setup {
 
 					decrypted, err := c.Decrypt(ctx, ciphertext)
 					if err != nil {
-						return w.Errorf("decryption failed: %s", err)
+						return w.Errorf("decryption failed: %w", err)
 					}
 
 					plaintexts[string(key)] = decrypted
@@ -152,9 +183,9 @@ func Setup(a *auth.Service, s *state.Service, c crypto.Service) router.Setup {
 				return w.JSON(&apitype.BatchDecryptResponse{
 					Plaintexts: plaintexts,
 				})
-			})
+			}), audit.Action("stack.batch-decrypt", auditTarget), limits.Decrypt)
 
-			r.POST("/import/{$}", func(w *router.ResponseWriter, r *http.Request) error {
+			r.POST("/import/{$}", authorize.Require(authz.PermissionWrite, func(w *router.ResponseWriter, r *http.Request) error {
 				// TODO - support resource import update
 				identifier := client.UpdateIdentifier{
 					StackIdentifier: StackIdentifier.Value(r),
@@ -167,15 +198,15 @@ func Setup(a *auth.Service, s *state.Service, c crypto.Service) router.Setup {
 					return w.WithStatus(http.StatusBadRequest).Errorf("invalid update: %s", err)
 				}
 
-				updateID, err := s.CreateImport(identifier, request)
+				updateID, err := s.CreateImport(r.Context(), identifier, request)
 				if err != nil {
-					return w.Errorf("import failed: %s", err)
+					return w.Errorf("import failed: %w", err)
 				}
 
 				return w.JSON(apitype.ImportStackResponse{UpdateID: updateID})
-			})
+			}), audit.Action("stack.import", auditTarget), checkpointQueries)
 
-			r.POST("/{updateKind}/{$}", func(w *router.ResponseWriter, r *http.Request) error {
+			r.POST("/{updateKind}/{$}", authorize.Require(authz.PermissionWrite, func(w *router.ResponseWriter, r *http.Request) error {
 				identifier, err := updateIdentifier(StackIdentifier, r)
 				if err != nil {
 					return w.WithStatus(http.StatusBadRequest).Error(err)
@@ -194,28 +225,24 @@ func Setup(a *auth.Service, s *state.Service, c crypto.Service) router.Setup {
 					Main:    request.Main,
 				}
 
-				claim, err := a.GetRequestClaims(r)
+				// team and organization tokens request updates as their team or organization
+				requester, err := z.RequestUser(r)
 				if err != nil {
 					return w.Error(err)
 				}
 
-				user, err := s.GetUser(claim.ID)
+				updateID, err := s.CreateUpdate(r.Context(), identifier, updateProgram, &request.Options, request.Config, &request.Metadata, requester)
 				if err != nil {
-					return w.Error(err)
-				}
-
-				updateID, err := s.CreateUpdate(identifier, updateProgram, &request.Options, request.Config, &request.Metadata, user)
-				if err != nil {
-					return w.Errorf("failed to create update: %s", err)
+					return w.Errorf("failed to create update: %w", err)
 				}
 
 				return w.JSON(apitype.UpdateProgramResponse{
 					UpdateID:         *updateID,
 					RequiredPolicies: []apitype.RequiredPolicy{},
 				})
-			})
+			}), audit.Action("update.create", updateAuditTarget))
 
-			r.GET("/activity/{$}", func(w *router.ResponseWriter, r *http.Request) error {
+			r.GET("/activity/{$}", authorize.Require(authz.PermissionRead, func(w *router.ResponseWriter, r *http.Request) error {
 				identifier := StackIdentifier.Value(r)
 
 				pageSize, err := util.IntegerParam(r, "pageSize", 10)
@@ -228,7 +255,7 @@ func Setup(a *auth.Service, s *state.Service, c crypto.Service) router.Setup {
 					return w.Error(err)
 				}
 
-				updates, err := s.ListUpdates(identifier, state.ListUpdateOptions{Descending: true, PageSize: pageSize, Page: page})
+				updates, err := s.ListUpdates(r.Context(), identifier, state.ListUpdateOptions{Descending: true, PageSize: pageSize, Page: page})
 				if err != nil {
 					return w.Error(err)
 				}
@@ -239,7 +266,7 @@ func Setup(a *auth.Service, s *state.Service, c crypto.Service) router.Setup {
 					activity = append(activity, StackActivity{Update: update})
 				}
 
-				count, err := s.GetUpdatesCount(identifier)
+				count, err := s.GetUpdatesCount(r.Context(), identifier)
 				if err != nil {
 					return w.Error(err)
 				}
@@ -249,9 +276,9 @@ func Setup(a *auth.Service, s *state.Service, c crypto.Service) router.Setup {
 					ItemsPerPage: pageSize,
 					Total:        count,
 				})
-			})
+			}))
 
-			r.GET("/updates/{$}", func(w *router.ResponseWriter, r *http.Request) error {
+			r.GET("/updates/{$}", authorize.Require(authz.PermissionRead, func(w *router.ResponseWriter, r *http.Request) error {
 				identifier := StackIdentifier.Value(r)
 
 				outputType := r.URL.Query().Get("output-type")
@@ -269,13 +296,13 @@ func Setup(a *auth.Service, s *state.Service, c crypto.Service) router.Setup {
 					return w.Error(err)
 				}
 
-				updates, err := s.ListUpdates(identifier, state.ListUpdateOptions{PageSize: pageSize, Page: page})
+				updates, err := s.ListUpdates(r.Context(), identifier, state.ListUpdateOptions{PageSize: pageSize, Page: page})
 				if err != nil {
 					return w.Error(err)
 				}
 
 				if outputType == "service" {
-					count, err := s.GetUpdatesCount(identifier)
+					count, err := s.GetUpdatesCount(r.Context(), identifier)
 					if err != nil {
 						return w.Error(err)
 					}
@@ -294,23 +321,23 @@ func Setup(a *auth.Service, s *state.Service, c crypto.Service) router.Setup {
 				}
 
 				return w.JSON(&ListUpdatesResponse{Updates: infos})
-			})
+			}))
 
-			r.GET("/updates/{version}/{$}", func(w *router.ResponseWriter, r *http.Request) error {
+			r.GET("/updates/{version}/{$}", authorize.Require(authz.PermissionRead, func(w *router.ResponseWriter, r *http.Request) error {
 				identifier := StackIdentifier.Value(r)
 
 				version := r.PathValue("version")
 
-				update, err := s.GetStackUpdate(identifier, version)
+				update, err := s.GetStackUpdate(r.Context(), identifier, version)
 				if err != nil {
 					return w.Error(err)
 				}
 
 				return w.JSON(update)
-			})
+			}))
 
 			// TODO - why no resourceChanges field?
-			r.GET("/updates/{version}/previews/{$}", func(w *router.ResponseWriter, r *http.Request) error {
+			r.GET("/updates/{version}/previews/{$}", authorize.Require(authz.PermissionRead, func(w *router.ResponseWriter, r *http.Request) error {
 				identifier := StackIdentifier.Value(r)
 				version := r.PathValue("version")
 
@@ -324,12 +351,12 @@ func Setup(a *auth.Service, s *state.Service, c crypto.Service) router.Setup {
 					return w.Error(err)
 				}
 
-				previews, err := s.ListPreviews(identifier, version, state.ListUpdateOptions{PageSize: pageSize, Page: page})
+				previews, err := s.ListPreviews(r.Context(), identifier, version, state.ListUpdateOptions{PageSize: pageSize, Page: page})
 				if err != nil {
 					return w.Error(err)
 				}
 
-				count, err := s.GetPreviewsCount(identifier, version)
+				count, err := s.GetPreviewsCount(r.Context(), identifier, version)
 				if err != nil {
 					return w.Error(err)
 				}
@@ -340,7 +367,7 @@ func Setup(a *auth.Service, s *state.Service, c crypto.Service) router.Setup {
 					ItemsPerPage: pageSize,
 					Total:        count,
 				})
-			})
+			}))
 		})
 	}
 }
@@ -362,6 +389,29 @@ var StackIdentifier = middleware.NewPathParser(
 		}, nil
 	})
 
+func auditTarget(r *http.Request) audit.Target {
+	identifier := StackIdentifier.Value(r)
+
+	return audit.Target{
+		Organization: identifier.Owner,
+		Stack:        identifier.Project + "/" + identifier.Stack.String(),
+	}
+}
+
+func updateAuditTarget(r *http.Request) audit.Target {
+	target := auditTarget(r)
+	target.Name = r.PathValue("updateKind")
+	return target
+}
+
+// logStack adds the stack to the request's log lines.
+func logStack(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		middleware.AddLogAttrs(r.Context(), slog.String("stack", StackIdentifier.Value(r).String()))
+		next.ServeHTTP(w, r)
+	})
+}
+
 func updateIdentifier(prefix *middleware.PathParser[client.StackIdentifier], r *http.Request) (client.UpdateIdentifier, error) {
 	updateKind, err := model.ParseUpdateKind(r.PathValue("updateKind"))
 	if err != nil {
@@ -401,3 +451,7 @@ type ListActivityRepsonse struct {
 type StackActivity struct {
 	Update model.StackUpdate `json:"update"`
 }
+
+func isComplete(update *model.StackUpdate) bool {
+	return update.Info.Result == apitype.SucceededResult || update.Info.Result == apitype.FailedResult
+}
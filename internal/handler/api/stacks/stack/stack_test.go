@@ -0,0 +1,191 @@
+package stack
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pulumi/pulumi/pkg/v3/backend/httpstate/client"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/tokens"
+	"github.com/tinkerborg/open-pulumi-service/internal/errs"
+	"github.com/tinkerborg/open-pulumi-service/internal/model"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/auth"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/authz"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/crypto"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/ratelimit"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/state"
+	"github.com/tinkerborg/open-pulumi-service/internal/store"
+	"github.com/tinkerborg/open-pulumi-service/pkg/router"
+)
+
+func TestCreateUpdateRequester(t *testing.T) {
+	h := newTestHandler(t, plaintextCrypto{})
+	ctx := context.Background()
+
+	if err := h.state.CreateTeam(ctx, "acme", &model.TeamRecord{Name: "ops", DisplayName: "Operations"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.state.GrantTeamStackPermission(ctx, "acme", "ops", "project", model.AllStacks, model.StackPermissionWrite); err != nil {
+		t.Fatal(err)
+	}
+
+	team, err := h.state.GetTeam(ctx, "acme", "ops")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		id        string
+		tokenType string
+		want      string
+	}{
+		{name: "user", id: h.user.ID, tokenType: auth.UserToken, want: "alice"},
+		{name: "team", id: team.ID, tokenType: auth.TeamToken, want: "ops"},
+		{name: "organization", id: h.organization.ID, tokenType: auth.OrganizationToken, want: "acme"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			token, err := h.auth.CreateToken(ctx, test.id, test.tokenType, auth.TokenOptions{Name: "ci"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			response := h.do(http.MethodPost, "/stacks/acme/project/dev/update", token, `{"name": "project", "runtime": "go"}`)
+			if response.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", response.Code, response.Body)
+			}
+
+			updates, err := h.state.ListUpdates(ctx, h.stack, state.ListUpdateOptions{Descending: true, PageSize: 1, Page: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(updates) != 1 || updates[0].RequestedBy == nil {
+				t.Fatalf("got updates %+v, want one requested by %s", updates, test.want)
+			}
+
+			if got := updates[0].RequestedBy.GitHubLogin; got != test.want {
+				t.Errorf("requested by %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDecryptErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		body   string
+		status int
+	}{
+		{name: "decrypts", body: `{"ciphertext": "c2VjcmV0"}`, status: http.StatusOK},
+		{name: "crypto unavailable", err: errs.New(errs.ErrUnavailable, "kms unavailable"), body: `{"ciphertext": "c2VjcmV0"}`, status: http.StatusServiceUnavailable},
+		{name: "crypto failure", err: errors.New("bad ciphertext"), body: `{"ciphertext": "c2VjcmV0"}`, status: http.StatusInternalServerError},
+		{name: "invalid payload", body: `{`, status: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := newTestHandler(t, plaintextCrypto{err: test.err})
+
+			token, err := h.auth.CreateToken(context.Background(), h.user.ID, auth.UserToken)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			response := h.do(http.MethodPost, "/stacks/acme/project/dev/decrypt", token, test.body)
+			if response.Code != test.status {
+				t.Errorf("got status %d, want %d: %s", response.Code, test.status, response.Body)
+			}
+		})
+	}
+}
+
+type testHandler struct {
+	handler      http.Handler
+	auth         *auth.Service
+	state        *state.Service
+	user         *model.ServiceUser
+	organization *model.OrganizationRecord
+	stack        client.StackIdentifier
+}
+
+// newTestHandler serves the stack routes of an organization's stack, acme/project/dev, with the
+// given crypto service for stack secrets.
+func newTestHandler(t *testing.T, c crypto.Service) *testHandler {
+	p := store.NewTestPostgres(t)
+	ctx := context.Background()
+
+	s, err := state.New(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := auth.New(p, plaintextCrypto{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limits, err := ratelimit.New(p, a, ratelimit.Config{Store: ratelimit.StoreMemory, Window: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := &model.ServiceUser{GitHubLogin: "alice", Email: "alice@example.com"}
+	if err := s.CreateUser(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	organization := &model.OrganizationRecord{Name: "acme", DisplayName: "Acme"}
+	if err := s.CreateOrganization(ctx, organization, user); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.CreateStack(ctx, &apitype.Stack{OrgName: "acme", ProjectName: "project", StackName: "dev"}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := router.NewRouter()
+	r.Use(a.Middleware)
+	r.Mount("/stacks/", Setup(a, authz.New(a, s), s, c, limits, time.Minute))
+
+	return &testHandler{
+		handler:      r,
+		auth:         a,
+		state:        s,
+		user:         user,
+		organization: organization,
+		stack:        client.StackIdentifier{Owner: "acme", Project: "project", Stack: tokens.MustParseStackName("dev")},
+	}
+}
+
+func (h *testHandler) do(method string, target string, token string, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, target, strings.NewReader(body))
+	request.Header.Set("Authorization", "token "+token)
+
+	response := httptest.NewRecorder()
+	h.handler.ServeHTTP(response, request)
+
+	return response
+}
+
+// plaintextCrypto "encrypts" to the plaintext itself, or fails with err when set.
+type plaintextCrypto struct {
+	err error
+}
+
+func (c plaintextCrypto) Encrypt(ctx context.Context, plaintext []byte) ([]byte, error) {
+	return plaintext, c.err
+}
+
+func (c plaintextCrypto) Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	return ciphertext, c.err
+}
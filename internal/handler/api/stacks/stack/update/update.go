@@ -2,46 +2,57 @@ package update
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/pulumi/pulumi/pkg/v3/backend/httpstate/client"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
 	"github.com/tinkerborg/open-pulumi-service/internal/model"
 	"github.com/tinkerborg/open-pulumi-service/internal/service/auth"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/authz"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/ratelimit"
 	"github.com/tinkerborg/open-pulumi-service/internal/service/state"
+	"github.com/tinkerborg/open-pulumi-service/internal/store"
+	"github.com/tinkerborg/open-pulumi-service/internal/util"
 	"github.com/tinkerborg/open-pulumi-service/pkg/router"
 	"github.com/tinkerborg/open-pulumi-service/pkg/router/middleware"
 )
 
-// TODO - currently the stack name doesn't matter as long as updateID is correct,
-//
-//	but the updateID should have to correspond to the stack in the path
-func Setup(a *auth.Service, p *state.Service, prefix *middleware.PathParser[client.StackIdentifier]) router.Setup {
+func Setup(a *auth.Service, z *authz.Service, p *state.Service, prefix *middleware.PathParser[client.StackIdentifier], limits *ratelimit.Service, checkpointTimeout time.Duration) router.Setup {
 	updateIdentifier := updateIdentifier(prefix)
+	authorize := z.Stacks(prefix.Value)
+	checkpointQueries := store.Timeout(checkpointTimeout)
 
-	updateToken := a.WithTokenType("update-token", func(r *http.Request, claims *auth.UserClaims) bool {
+	updateToken := a.WithTokenType(auth.UpdateToken, func(r *http.Request, claims *auth.UserClaims) bool {
 		identifier := updateIdentifier.Value(r)
 		return claims.ID == identifier.UpdateID
 	})
 
 	return func(r *router.Router) {
-		r.WithPrefix("/{updateKind}/{updateID}", updateIdentifier.Middleware).Do(func(r *router.Router) {
+		r.WithPrefix("/{updateKind}/{updateID}", updateIdentifier.Middleware, logUpdate(updateIdentifier)).Do(func(r *router.Router) {
 			// TODO should respond 404 to updates/XX/unknown_type
-			r.GET("/{$}", func(w *router.ResponseWriter, r *http.Request) error {
+			r.GET("/{$}", authorize.Require(authz.PermissionRead, func(w *router.ResponseWriter, r *http.Request) error {
 				identifier := updateIdentifier.Value(r)
 
-				results, err := p.GetUpdateResults(identifier)
+				opts, err := engineEventOptions(r)
+				if err != nil {
+					return w.WithStatus(http.StatusBadRequest).Error(err)
+				}
+
+				results, err := p.GetUpdateResults(r.Context(), identifier, opts)
 				if err != nil {
 					return w.Error(err)
 				}
 
-				return w.JSON(apitype.UpdateResults{
-					Status: results.Status,
-					Events: []apitype.UpdateEvent{},
-				})
-			})
+				return w.JSON(results)
+			}))
 
-			r.POST("/{$}", func(w *router.ResponseWriter, r *http.Request) error {
+			r.POST("/{$}", authorize.Require(authz.PermissionWrite, func(w *router.ResponseWriter, r *http.Request) error {
 				identifier := updateIdentifier.Value(r)
 
 				var request apitype.StartUpdateRequest
@@ -49,12 +60,12 @@ func Setup(a *auth.Service, p *state.Service, prefix *middleware.PathParser[clie
 					return w.WithStatus(http.StatusBadRequest).Errorf("invalid request: %s", err)
 				}
 
-				version, err := p.StartUpdate(identifier)
+				version, err := p.StartUpdate(r.Context(), identifier)
 				if err != nil {
-					return w.Errorf("failed to start update: %s", err)
+					return w.Errorf("failed to start update: %w", err)
 				}
 
-				token, err := a.CreateToken(identifier.UpdateID, "update-token")
+				token, err := a.CreateToken(r.Context(), identifier.UpdateID, auth.UpdateToken)
 				if err != nil {
 					return w.Error(err)
 				}
@@ -70,7 +81,7 @@ func Setup(a *auth.Service, p *state.Service, prefix *middleware.PathParser[clie
 					// }
 					Token: token,
 				})
-			})
+			}))
 
 			r.PATCH("/checkpoint/{$}", func(w *router.ResponseWriter, r *http.Request) error {
 				identifier := updateIdentifier.Value(r)
@@ -86,15 +97,15 @@ func Setup(a *auth.Service, p *state.Service, prefix *middleware.PathParser[clie
 					Checkpoint: request.Deployment,
 				}
 
-				if err := p.CheckpointUpdate(identifier, checkpoint); err != nil {
-					return w.Errorf("checkpoint failed: %s", err)
+				if err := p.CheckpointUpdate(r.Context(), identifier, checkpoint); err != nil {
+					return w.Errorf("checkpoint failed: %w", err)
 				}
 
 				// TODO - figure out what this response should actually be
 				return w.JSON(model.CompleteUpdateResponse{
 					Version: 2,
 				})
-			}, updateToken)
+			}, updateToken, checkpointQueries, limits.Checkpoint)
 
 			// TODO - what happens on official API when you start an update, start and complete a different update, and then
 			//        complete the first udpate? how do version numbers work?
@@ -106,28 +117,157 @@ func Setup(a *auth.Service, p *state.Service, prefix *middleware.PathParser[clie
 					return w.WithStatus(http.StatusBadRequest).Errorf("invalid request: %s", err)
 				}
 
-				version, err := p.CompleteUpdate(identifier, request.Status)
+				version, err := p.CompleteUpdate(r.Context(), identifier, request.Status)
 				if err != nil {
-					return w.Errorf("failed to complete update: %s", err)
+					return w.Errorf("failed to complete update: %w", err)
 				}
 
 				return w.JSON(model.CompleteUpdateResponse{
 					Version: *version,
 				})
-			}, updateToken)
+			}, updateToken, checkpointQueries)
 
-			r.GET("/events/{$}", func(w *router.ResponseWriter, r *http.Request) error {
+			r.GET("/events/{$}", authorize.Require(authz.PermissionRead, func(w *router.ResponseWriter, r *http.Request) error {
 				identifier := updateIdentifier.Value(r)
 
-				events, err := p.ListEngineEvents(identifier)
+				opts, err := engineEventOptions(r)
+				if err != nil {
+					return w.WithStatus(http.StatusBadRequest).Error(err)
+				}
+
+				query := r.URL.Query()
+
+				for _, eventType := range query["type"] {
+					for _, t := range strings.Split(eventType, ",") {
+						opts.Types = append(opts.Types, strings.TrimSuffix(t, "Event"))
+					}
+				}
+
+				for _, severity := range query["severity"] {
+					opts.Severities = append(opts.Severities, strings.Split(severity, ",")...)
+				}
+
+				opts.URN = query.Get("urn")
+
+				events, err := p.GetEngineEvents(r.Context(), identifier, opts)
 				if err != nil {
 					return w.Error(err)
 				}
 
-				return w.JSON(&apitype.GetUpdateEventsResponse{
-					Events: events,
-				})
-			})
+				return w.JSON(events)
+			}))
+
+			r.GET("/events/stream/{$}", authorize.Require(authz.PermissionRead, func(w *router.ResponseWriter, r *http.Request) error {
+				identifier := updateIdentifier.Value(r)
+
+				opts := state.ListEngineEventOptions{PageSize: maxEventPageSize}
+
+				if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+					sequence, err := strconv.Atoi(lastEventID)
+					if err != nil {
+						return w.WithStatus(http.StatusBadRequest).Errorf("invalid Last-Event-ID '%s'", lastEventID)
+					}
+					opts.AfterSequence = &sequence
+				}
+
+				// subscribe before the first read so that no batch can slip in between
+				notifications, unsubscribe := p.SubscribeEngineEvents(identifier)
+				defer unsubscribe()
+
+				if _, err := p.GetUpdateStatus(r.Context(), identifier); err != nil {
+					return w.Error(err)
+				}
+
+				w.Header().Set("Content-Type", "text/event-stream")
+				w.Header().Set("Cache-Control", "no-cache")
+				w.Header().Set("X-Accel-Buffering", "no")
+				w.WriteHeader(http.StatusOK)
+
+				controller := http.NewResponseController(w)
+
+				// notifications are process-local, so poll as well to pick up events written by other replicas
+				ticker := time.NewTicker(streamPollInterval)
+				defer ticker.Stop()
+
+				for {
+					// read status before events so that events written just before completion are never skipped
+					status, err := p.GetUpdateStatus(r.Context(), identifier)
+					if err != nil {
+						return writeEvent(w, "", "error", router.ErrorResponse(r, http.StatusInternalServerError, err))
+					}
+
+					events, err := p.ListEngineEvents(r.Context(), identifier, opts)
+					if err != nil {
+						return writeEvent(w, "", "error", router.ErrorResponse(r, http.StatusInternalServerError, err))
+					}
+
+					for _, event := range events {
+						if err := writeEvent(w, strconv.Itoa(event.Sequence), "engineEvent", event); err != nil {
+							return err
+						}
+						opts.AfterSequence = &event.Sequence
+					}
+
+					// keep reading without waiting while there is a backlog
+					if len(events) == opts.PageSize {
+						continue
+					}
+
+					if model.IsUpdateComplete(status) {
+						if err := writeEvent(w, "", "complete", apitype.UpdateResults{Status: status}); err != nil {
+							return err
+						}
+						return controller.Flush()
+					}
+
+					if err := controller.Flush(); err != nil {
+						return err
+					}
+
+					select {
+					case <-r.Context().Done():
+						return nil
+					case _, ok := <-notifications:
+						// the server is shutting down; the client resumes from Last-Event-ID
+						if !ok {
+							return nil
+						}
+					case <-ticker.C:
+						if _, err := w.Write([]byte(": keepalive\n\n")); err != nil {
+							return err
+						}
+					}
+				}
+			}))
+
+			r.GET("/logs/{$}", authorize.Require(authz.PermissionRead, func(w *router.ResponseWriter, r *http.Request) error {
+				identifier := updateIdentifier.Value(r)
+				query := r.URL.Query()
+
+				opts := state.RenderOptions{}
+
+				switch color := query.Get("color"); color {
+				case "", "never":
+				case "always":
+					opts.Color = true
+				default:
+					return w.WithStatus(http.StatusBadRequest).Errorf("invalid color '%s'", color)
+				}
+
+				switch format := query.Get("format"); format {
+				case "", "progress":
+				case "diff":
+					opts.Diff = true
+				default:
+					return w.WithStatus(http.StatusBadRequest).Errorf("invalid format '%s'", format)
+				}
+
+				// the log is streamed as its events are read, a page at a time. Failures before the
+				// first line are reported as errors, later ones can only be logged.
+				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+				return p.RenderUpdateLog(r.Context(), identifier, w, opts)
+			}))
 
 			r.POST("/events/batch/{$}", func(w *router.ResponseWriter, r *http.Request) error {
 				identifier := updateIdentifier.Value(r)
@@ -137,8 +277,8 @@ func Setup(a *auth.Service, p *state.Service, prefix *middleware.PathParser[clie
 					return w.WithStatus(http.StatusBadRequest).Errorf("invalid batch: %s", err)
 				}
 
-				if err := p.AddEngineEvents(identifier, request.Events); err != nil {
-					return w.Errorf("failed to write events: %s", err)
+				if err := p.AddEngineEvents(r.Context(), identifier, request.Events); err != nil {
+					return w.Errorf("failed to write events: %w", err)
 				}
 
 				return nil
@@ -147,6 +287,66 @@ func Setup(a *auth.Service, p *state.Service, prefix *middleware.PathParser[clie
 	}
 }
 
+const (
+	streamPollInterval   = 15 * time.Second
+	defaultEventPageSize = 1000
+	maxEventPageSize     = 5000
+)
+
+// engineEventOptions parses the paging parameters shared by the results and events endpoints.
+func engineEventOptions(r *http.Request) (state.ListEngineEventOptions, error) {
+	opts := state.ListEngineEventOptions{}
+
+	pageSize, err := util.IntegerParam(r, "pageSize", defaultEventPageSize)
+	if err != nil {
+		return opts, err
+	}
+
+	if pageSize < 1 || pageSize > maxEventPageSize {
+		return opts, fmt.Errorf("parameter 'pageSize' must be between 1 and %d", maxEventPageSize)
+	}
+
+	opts.PageSize = pageSize
+
+	if token := r.URL.Query().Get("continuationToken"); token != "" {
+		sequence, err := state.ParseContinuationToken(token)
+		if err != nil {
+			return opts, err
+		}
+		opts.AfterSequence = sequence
+	}
+
+	return opts, nil
+}
+
+// writeEvent writes a single server-sent event with a JSON encoded payload.
+func writeEvent(w io.Writer, id, event string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	if id != "" {
+		if _, err := fmt.Fprintf(w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+
+	return err
+}
+
+// logUpdate adds the update to the request's log lines.
+func logUpdate(updateIdentifier *middleware.PathParser[client.UpdateIdentifier]) router.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			middleware.AddLogAttrs(r.Context(), slog.String("update_id", updateIdentifier.Value(r).UpdateID))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 var updateIdentifier = func(prefix *middleware.PathParser[client.StackIdentifier]) *middleware.PathParser[client.UpdateIdentifier] {
 	return middleware.NewPathParser(
 		func(r *http.Request) (client.UpdateIdentifier, error) {
@@ -4,22 +4,34 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
+	"time"
 
+	"github.com/pulumi/pulumi/pkg/v3/backend/httpstate/client"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/tokens"
 	"github.com/tinkerborg/open-pulumi-service/internal/handler/api/stacks/stack"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/audit"
 	"github.com/tinkerborg/open-pulumi-service/internal/service/auth"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/authz"
 	"github.com/tinkerborg/open-pulumi-service/internal/service/crypto"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/ratelimit"
 	"github.com/tinkerborg/open-pulumi-service/internal/service/state"
 	"github.com/tinkerborg/open-pulumi-service/internal/store"
 	"github.com/tinkerborg/open-pulumi-service/pkg/router"
 )
 
-func Setup(a *auth.Service, p *state.Service, c crypto.Service) router.Setup {
+func Setup(a *auth.Service, z *authz.Service, p *state.Service, c crypto.Service, limits *ratelimit.Service, checkpointTimeout time.Duration) router.Setup {
+	authorize := z.Stacks(func(r *http.Request) client.StackIdentifier {
+		return client.StackIdentifier{
+			Owner:   r.PathValue("owner"),
+			Project: r.PathValue("project"),
+		}
+	})
+
 	return func(r *router.Router) {
-		r.Mount("/", stack.Setup(a, p, c))
+		r.Mount("/", stack.Setup(a, z, p, c, limits, checkpointTimeout))
 
-		r.POST("/{owner}/{project}/{$}", func(w *router.ResponseWriter, r *http.Request) error {
+		r.POST("/{owner}/{project}/{$}", authorize.Require(authz.PermissionWrite, func(w *router.ResponseWriter, r *http.Request) error {
 			owner := r.PathValue("owner")
 			project := r.PathValue("project")
 
@@ -37,7 +49,7 @@ func Setup(a *auth.Service, p *state.Service, c crypto.Service) router.Setup {
 				Config:      request.Config,
 			}
 
-			if err := p.CreateStack(stack); err != nil {
+			if err := p.CreateStack(r.Context(), stack); err != nil {
 				if errors.Is(err, store.ErrExist) {
 					return w.WithStatus(http.StatusConflict).Errorf("stack already exists")
 				}
@@ -45,7 +57,9 @@ func Setup(a *auth.Service, p *state.Service, c crypto.Service) router.Setup {
 			}
 
 			return w.JSON(&apitype.CreateStackResponse{})
-		})
+		}), audit.Action("stack.create", func(r *http.Request) audit.Target {
+			return audit.Target{Organization: r.PathValue("owner"), Name: r.PathValue("project")}
+		}))
 
 	}
 }
@@ -2,15 +2,23 @@ package user
 
 import (
 	"net/http"
+	"net/url"
+	"strings"
 
+	"github.com/pulumi/pulumi/pkg/v3/backend/httpstate/client"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/tokens"
 	"github.com/tinkerborg/open-pulumi-service/internal/model"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/audit"
 	"github.com/tinkerborg/open-pulumi-service/internal/service/auth"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/authz"
 	"github.com/tinkerborg/open-pulumi-service/internal/service/state"
 	"github.com/tinkerborg/open-pulumi-service/pkg/router"
 )
 
-func Setup(a *auth.Service, p *state.Service) router.Setup {
+func Setup(a *auth.Service, z *authz.Service, p *state.Service, appBaseURL string) router.Setup {
+	appBaseURL = strings.TrimSuffix(appBaseURL, "/")
+
 	return func(r *router.Router) {
 		r.GET("/", func(w *router.ResponseWriter, r *http.Request) error {
 			claims, err := a.GetRequestClaims(r)
@@ -18,24 +26,106 @@ func Setup(a *auth.Service, p *state.Service) router.Setup {
 				return w.Error(err)
 			}
 
-			user, err := p.GetUser(claims.ID)
+			user, err := z.RequestUser(r)
 			if err != nil {
 				return w.WithStatus(http.StatusInternalServerError).Error(err)
 			}
 
+			if user.TokenInfo == nil && user.IsServiceAccount() {
+				organization, err := p.GetOrganizationByID(r.Context(), *user.ServiceAccountOrgID)
+				if err != nil {
+					return w.Error(err)
+				}
+
+				user.TokenInfo = &model.ServiceTokenInfo{
+					Name:         claims.Name,
+					Organization: organization.Name,
+				}
+			}
+
 			return w.JSON(user)
 		})
 
 		r.GET("/organizations/default/{$}", func(w *router.ResponseWriter, r *http.Request) error {
-			w.JSON(&apitype.GetDefaultOrganizationResponse{
-				GitHubLogin: "cimulate-ai",
-				Messages: []apitype.Message{
-					{Message: "Hello world"},
-				},
+			organization, err := defaultOrganization(a, p, r)
+			if err != nil {
+				return w.Error(err)
+			}
+
+			return w.JSON(&apitype.GetDefaultOrganizationResponse{
+				GitHubLogin: organization,
+				Messages:    []apitype.Message{},
 			})
-			return nil
 		})
 
+		r.POST("/organizations/{org}/default/{$}", func(w *router.ResponseWriter, r *http.Request) error {
+			user, err := requestUser(a, p, r)
+			if err != nil {
+				return w.Error(err)
+			}
+
+			if err := p.SetDefaultOrganization(r.Context(), user, r.PathValue("org")); err != nil {
+				return w.Error(err)
+			}
+
+			w.Write([]byte{})
+			return nil
+		}, audit.Action("user.default-organization.set", audit.OrganizationTarget("")))
+
+		// linking starts here, authenticated, and completes in the browser at the returned URL, where
+		// the user confirms it by logging in again before the provider identity joins them
+		r.POST("/identities/{provider}/{$}", func(w *router.ResponseWriter, r *http.Request) error {
+			claims, err := a.GetRequestClaims(r)
+			if err != nil {
+				return w.Error(err)
+			}
+
+			if claims.Type != auth.UserToken {
+				return w.WithStatus(http.StatusForbidden).Errorf("only users can link identities")
+			}
+
+			user, err := p.GetUser(r.Context(), claims.ID)
+			if err != nil {
+				return w.Error(err)
+			}
+
+			if user.IsServiceAccount() {
+				return w.WithStatus(http.StatusForbidden).Errorf("service accounts can't link identities")
+			}
+
+			provider := r.PathValue("provider")
+
+			state, err := a.CreateLoginState(r.Context(), &model.LoginStateRecord{
+				Provider:   provider,
+				LinkUserID: user.ID,
+			})
+			if err != nil {
+				return w.Error(err)
+			}
+
+			return w.JSON(linkResponse{
+				URL: appBaseURL + "/auth/" + url.PathEscape(provider) + "/?link=" + url.QueryEscape(state),
+			})
+		}, audit.Action("user.identity.link", func(r *http.Request) audit.Target {
+			return audit.Target{Name: r.PathValue("provider")}
+		}))
+
+		r.DELETE("/identities/{provider}/{$}", func(w *router.ResponseWriter, r *http.Request) error {
+			user, err := requestUser(a, p, r)
+			if err != nil {
+				return w.Error(err)
+			}
+
+			if err := p.UnlinkIdentity(r.Context(), user.ID, r.PathValue("provider")); err != nil {
+				return w.Error(err)
+			}
+
+			w.Write([]byte{})
+			return nil
+		}, audit.Action("user.identity.unlink", func(r *http.Request) audit.Target {
+			return audit.Target{Name: r.PathValue("provider")}
+		}))
+
 		r.GET("/stacks/{$}", func(w *router.ResponseWriter, r *http.Request) error {
 			// TODO tag
 			query := r.URL.Query()
@@ -43,12 +133,82 @@ func Setup(a *auth.Service, p *state.Service) router.Setup {
 			project := query.Get("project")
 
 			// TODO - should use default org
-			stacks, err := p.ListUserStacks(model.StackRecord{Owner: organization, Project: project})
+			stacks, err := p.ListUserStacks(r.Context(), model.StackRecord{Owner: organization, Project: project})
 			if err != nil {
 				return w.Error(err)
 			}
 
-			return w.JSON(&apitype.ListStacksResponse{Stacks: stacks})
+			identifiers := []client.StackIdentifier{}
+
+			for _, stack := range stacks {
+				stackName, err := tokens.ParseStackName(stack.StackName)
+				if err != nil {
+					return w.Error(err)
+				}
+
+				identifiers = append(identifiers, client.StackIdentifier{Owner: stack.OrgName, Project: stack.ProjectName, Stack: stackName})
+			}
+
+			permissions, err := z.RequestStackPermissions(r, identifiers)
+			if err != nil {
+				return w.Error(err)
+			}
+
+			readable := []apitype.StackSummary{}
+
+			for i, stack := range stacks {
+				if permissions[i] >= authz.PermissionRead {
+					readable = append(readable, stack)
+				}
+			}
+
+			return w.JSON(&apitype.ListStacksResponse{Stacks: readable})
 		})
 	}
 }
+
+// linkResponse is where the browser completes linking an identity.
+type linkResponse struct {
+	URL string `json:"url"`
+}
+
+// defaultOrganization returns the default organization of the caller. Team and organization tokens
+// always act within their organization.
+func defaultOrganization(a *auth.Service, p *state.Service, r *http.Request) (string, error) {
+	claims, err := a.GetRequestClaims(r)
+	if err != nil {
+		return "", err
+	}
+
+	if claims.Type == auth.TeamToken {
+		team, err := p.GetTeamByID(r.Context(), claims.ID)
+		if err != nil {
+			return "", err
+		}
+		return team.Organization.Name, nil
+	}
+
+	if claims.Type == auth.OrganizationToken {
+		organization, err := p.GetOrganizationByID(r.Context(), claims.ID)
+		if err != nil {
+			return "", err
+		}
+		return organization.Name, nil
+	}
+
+	user, err := p.GetUser(r.Context(), claims.ID)
+	if err != nil {
+		return "", err
+	}
+
+	return p.GetDefaultOrganization(r.Context(), user)
+}
+
+func requestUser(a *auth.Service, p *state.Service, r *http.Request) (*model.ServiceUser, error) {
+	claims, err := a.GetRequestClaims(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.GetUser(r.Context(), claims.ID)
+}
@@ -2,21 +2,22 @@ package app
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strings"
 
 	"github.com/go-pkgz/auth/token"
-	"github.com/gorilla/sessions"
 	"github.com/markbates/goth"
-	"github.com/markbates/goth/gothic"
+	"github.com/markbates/goth/providers/bitbucket"
 	"github.com/markbates/goth/providers/github"
+	"github.com/markbates/goth/providers/gitlab"
 	"github.com/tinkerborg/open-pulumi-service/internal/model"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/audit"
 	"github.com/tinkerborg/open-pulumi-service/internal/service/auth"
 	"github.com/tinkerborg/open-pulumi-service/internal/service/crypto"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/ratelimit"
 	"github.com/tinkerborg/open-pulumi-service/internal/service/state"
-	"github.com/tinkerborg/open-pulumi-service/internal/store"
 	"github.com/tinkerborg/open-pulumi-service/pkg/router"
 	"golang.org/x/oauth2"
 	googleoauth "golang.org/x/oauth2/google"
@@ -27,141 +28,100 @@ type OAuthConfig struct {
 	ClientID   string `env:"CLIENT_ID,required"`
 	Secret     string `env:"SECRET,required"`
 	AppBaseURL string
+	// Provider is the login provider the CLI is sent to: github, gitlab, bitbucket or oidc
+	Provider  string          `env:"PROVIDER" envDefault:"github"`
+	GitHub    GitHubConfig    `envPrefix:"GITHUB_"`
+	GitLab    GitLabConfig    `envPrefix:"GITLAB_"`
+	Bitbucket BitbucketConfig `envPrefix:"BITBUCKET_"`
+	OIDC      OIDCConfig      `envPrefix:"OIDC_"`
 }
 
-func Setup(a *auth.Service, s *state.Service, c crypto.Service, config OAuthConfig) router.Setup {
+func Setup(a *auth.Service, s *state.Service, c crypto.Service, l *audit.Service, limits *ratelimit.Service, config OAuthConfig) router.Setup {
 	config.AppBaseURL = strings.TrimSuffix(config.AppBaseURL, "/")
 
 	return func(r *router.Router) {
-		provider := github.New(
+		// logins are unauthenticated, so they are limited by address
+		r.Use(limits.Address, l.Middleware)
+
+		// providers are set up below; the map is complete before the first request
+		enabled := map[string]bool{oidcProvider: config.Provider == oidcProvider}
+
+		if config.Provider == oidcProvider {
+			r.Do(setupOIDC(a, s, config, enabled))
+		}
+
+		githubProvider := github.New(
 			config.ClientID,
 			config.Secret,
 			config.AppBaseURL+"/auth/github/callback",
 			"user",
 			"read:org",
 		)
+		githubProvider.HTTPClient = providerClient
+
+		providers := []loginProvider{
+			{
+				Provider:    githubProvider,
+				membership:  config.GitHub.MembershipConfig,
+				memberships: githubMemberships(config.GitHub),
+			},
+		}
 
-		key := "your-session-secret" // Replace with secure key
-		sessionStore := sessions.NewCookieStore([]byte(key))
-		sessionStore.MaxAge(86400 * 30)
-		sessionStore.Options.Path = "/"
-		sessionStore.Options.HttpOnly = true
-		sessionStore.Options.Secure = false // Set to true for HTTPS
-		gothic.Store = sessionStore
-
-		r.GET("/auth/github/{$}", func(w *router.ResponseWriter, r *http.Request) error {
-			query := r.URL.Query()
-			port := query.Get("port")
-			nonce := query.Get("nonce")
-
-			state := port + "|" + nonce
-
-			session, err := provider.BeginAuth(state)
-			if err != nil {
-				return w.Error(err)
-			}
-
-			url, err := session.GetAuthURL()
-			if err != nil {
-				return w.Error(err)
-			}
-
-			http.Redirect(w, r, url, http.StatusTemporaryRedirect)
-
-			return nil
-		})
-
-		r.GET("/auth/github/callback/{$}", func(w *router.ResponseWriter, r *http.Request) error {
-			state := r.URL.Query().Get("state")
-
-			// if state != "user123|home|randomNonce" {
-			// return w.WithStatus(http.StatusBadRequest).Errorf("invalid state")
-			// }
-
-			parts := strings.Split(state, "|")
-
-			port, nonce := parts[0], parts[1]
-
-			session, err := provider.BeginAuth(state) // Create session
-			if err != nil {
-				return w.Error(err)
-			}
-
-			_, err = session.Authorize(provider, r.URL.Query()) // Process code
-			if err != nil {
-				return w.Error(err)
-			}
-
-			sessionUser, err := provider.FetchUser(session)
-			if err != nil {
-				return w.Error(err)
-			}
-
-			// TODO - map provider attributes
-			// TODO - make more generic get or create logic
-			user, err := s.GetUserByName(sessionUser.NickName)
-			if err != nil {
-				if errors.Is(err, store.ErrNotFound) {
-					user = createServiceUser(sessionUser)
-					if err := s.CreateUser(user); err != nil {
-						if !errors.Is(err, store.ErrExist) {
-							return w.Error(err)
-						}
-					}
-				}
-			}
-
-			token, err := a.CreateToken(user.ID, "token")
-			if err != nil {
-				return w.Error(err)
-			}
-
-			dest := fmt.Sprintf("http://localhost:%s/?accessToken=%s&nonce=%s", port, token, nonce)
-
-			http.Redirect(w, r, dest, http.StatusFound)
-
-			return nil
-		})
+		if config.GitLab.ClientID != "" {
+			gitlabURL := strings.TrimSuffix(config.GitLab.URL, "/")
+
+			gitlabProvider := gitlab.NewCustomisedURL(
+				config.GitLab.ClientID,
+				config.GitLab.Secret,
+				config.AppBaseURL+"/auth/gitlab/callback",
+				gitlabURL+"/oauth/authorize",
+				gitlabURL+"/oauth/token",
+				gitlabURL+"/api/v4/user",
+				"read_user",
+				"read_api",
+			)
+			gitlabProvider.HTTPClient = providerClient
+
+			providers = append(providers, loginProvider{
+				Provider:    gitlabProvider,
+				membership:  config.GitLab.MembershipConfig,
+				memberships: gitlabMemberships(config.GitLab),
+			})
+		}
 
-		r.GET("/auth/{provider}/{$}", func(w *router.ResponseWriter, r *http.Request) error {
-			query := r.URL.Query()
-			nonce := query.Get("nonce")
-			port := query.Get("port")
+		if config.Bitbucket.ClientID != "" {
+			bitbucketProvider := bitbucket.New(
+				config.Bitbucket.ClientID,
+				config.Bitbucket.Secret,
+				config.AppBaseURL+"/auth/bitbucket/callback",
+				"account",
+				"email",
+			)
+			bitbucketProvider.HTTPClient = providerClient
+
+			providers = append(providers, loginProvider{
+				Provider:    bitbucketProvider,
+				membership:  config.Bitbucket.MembershipConfig,
+				memberships: bitbucketMemberships(config.Bitbucket),
+			})
+		}
 
-			session, _ := gothic.Store.Get(r, "gothic-session")
-			session.Values["nonce"] = nonce
-			session.Values["port"] = port
-			session.Store().Save(r, w, session)
+		for _, provider := range providers {
+			enabled[provider.Name()] = true
+			r.Do(setupProvider(a, s, config, enabled, provider))
+		}
 
-			gothic.BeginAuthHandler(w, r)
-			return nil
+		r.GET("/.well-known/jwks.json/{$}", func(w *router.ResponseWriter, r *http.Request) error {
+			return w.JSON(a.JWKS())
 		})
 
+		// the CLI sends the browser here once it has received its token
 		r.GET("/welcome/cli/{$}", func(w *router.ResponseWriter, r *http.Request) error {
-			session, _ := gothic.Store.Get(r, "gothic-session")
-			// TODO - getting wrong session values when switching github account
-			w.Write([]byte("Welcome, " + session.Values["email"].(string) + "!"))
-			return nil
-		})
-
-		r.GET("/auth/{provider}/callback/{$}", func(w *router.ResponseWriter, r *http.Request) error {
-			user, err := gothic.CompleteUserAuth(w, r)
-			if err != nil {
-				return w.Error(err)
-			}
-
-			session, _ := gothic.Store.Get(r, "gothic-session")
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
 
-			session.Values["email"] = user.Email
-			session.Store().Save(r, w, session)
-
-			dest := fmt.Sprintf("http://localhost:%s/?accessToken=%s&nonce=%s", session.Values["port"], "oooomlaut", session.Values["nonce"])
-
-			http.Redirect(w, r, dest, http.StatusFound)
-
-			return nil
-			// t, _ := template.ParseFiles("templates/success.html")
-			// return t.Execute(w, user)
+			_, err := w.Write([]byte(welcomePage))
+			return err
 		})
 
 		r.GET("/cli-login/{$}", func(w *router.ResponseWriter, r *http.Request) error {
@@ -169,19 +129,19 @@ func Setup(a *auth.Service, s *state.Service, c crypto.Service, config OAuthConf
 			nonce := query.Get("cliSessionNonce")
 			port := query.Get("cliSessionPort")
 
-			if nonce == "" && port == "" {
-				return w.WithStatus(http.StatusBadRequest).Errorf("invalid request")
+			if err := validateCLISession(port, nonce); err != nil {
+				return w.WithStatus(http.StatusBadRequest).Error(err)
+			}
+
+			if !enabled[config.Provider] {
+				return w.WithStatus(http.StatusInternalServerError).Errorf("login provider '%s' is not configured", config.Provider)
 			}
 
-			dest := fmt.Sprintf("/auth/github?port=%s&nonce=%s", port, nonce)
+			dest := fmt.Sprintf("/auth/%s?port=%s&nonce=%s", config.Provider, url.QueryEscape(port), url.QueryEscape(nonce))
 
 			http.Redirect(w, r, dest, http.StatusFound)
 
-			gothic.BeginAuthHandler(w, r)
-
-			return w.JSON(struct {
-				Token string `json:"token"`
-			}{Token: "moo"})
+			return nil
 		})
 	}
 }
@@ -204,7 +164,7 @@ func claimsUpdaterWithDB() token.ClaimsUpdFunc {
 
 		// db := getDB()
 		// if !db.UserExists(claims.User.ID) {
-		// db.CreateUser(*claims.User)
+		// db.CreateUser(r.Context(), *claims.User)
 		// }
 
 		return claims
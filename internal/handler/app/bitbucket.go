@@ -0,0 +1,51 @@
+package app
+
+import (
+	"context"
+
+	"fmt"
+	"strings"
+)
+
+type BitbucketConfig struct {
+	ClientID string `env:"CLIENT_ID"`
+	Secret   string `env:"SECRET"`
+	APIURL   string `env:"API_URL" envDefault:"https://api.bitbucket.org/2.0"`
+	MembershipConfig
+}
+
+type bitbucketWorkspacePage struct {
+	Values []struct {
+		Workspace struct {
+			Slug string `json:"slug"`
+		} `json:"workspace"`
+	} `json:"values"`
+	Next string `json:"next"`
+}
+
+// bitbucketMemberships returns the workspaces a Bitbucket user belongs to, keyed by slug. Bitbucket
+// has no teams within workspaces that can be listed for a user.
+func bitbucketMemberships(config BitbucketConfig) func(ctx context.Context, accessToken string) (map[string][]string, error) {
+	apiURL := strings.TrimSuffix(config.APIURL, "/")
+
+	return func(ctx context.Context, accessToken string) (map[string][]string, error) {
+		memberships := map[string][]string{}
+
+		next := fmt.Sprintf("%s/user/permissions/workspaces?pagelen=%d", apiURL, listPageSize)
+
+		for next != "" {
+			page := bitbucketWorkspacePage{}
+			if err := getJSON(ctx, next, accessToken, &page); err != nil {
+				return nil, err
+			}
+
+			for _, value := range page.Values {
+				memberships[value.Workspace.Slug] = []string{}
+			}
+
+			next = page.Next
+		}
+
+		return memberships, nil
+	}
+}
@@ -0,0 +1,52 @@
+package app
+
+import (
+	"context"
+
+	"strings"
+)
+
+type GitHubConfig struct {
+	APIURL string `env:"API_URL" envDefault:"https://api.github.com"`
+	MembershipConfig
+}
+
+type githubOrganization struct {
+	Login string `json:"login"`
+}
+
+type githubTeam struct {
+	Slug         string             `json:"slug"`
+	Organization githubOrganization `json:"organization"`
+}
+
+// githubMemberships returns the organizations a GitHub user belongs to, keyed by login, with the
+// slugs of their teams in each.
+func githubMemberships(config GitHubConfig) func(ctx context.Context, accessToken string) (map[string][]string, error) {
+	apiURL := strings.TrimSuffix(config.APIURL, "/")
+
+	return func(ctx context.Context, accessToken string) (map[string][]string, error) {
+		organizations := []githubOrganization{}
+		if err := listPages(ctx, apiURL+"/user/orgs", accessToken, &organizations); err != nil {
+			return nil, err
+		}
+
+		teams := []githubTeam{}
+		if err := listPages(ctx, apiURL+"/user/teams", accessToken, &teams); err != nil {
+			return nil, err
+		}
+
+		memberships := map[string][]string{}
+
+		for _, organization := range organizations {
+			memberships[organization.Login] = []string{}
+		}
+
+		for _, team := range teams {
+			login := team.Organization.Login
+			memberships[login] = append(memberships[login], team.Slug)
+		}
+
+		return memberships, nil
+	}
+}
@@ -0,0 +1,81 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestGitHubMemberships(t *testing.T) {
+	// a full first page of organizations makes the second page be fetched
+	organizations := []githubOrganization{}
+	for i := range listPageSize + 1 {
+		organizations = append(organizations, githubOrganization{Login: fmt.Sprintf("org-%d", i)})
+	}
+
+	teams := []githubTeam{
+		{Slug: "ops", Organization: githubOrganization{Login: "org-0"}},
+		{Slug: "dev", Organization: githubOrganization{Login: "org-0"}},
+		{Slug: "web", Organization: githubOrganization{Login: "org-100"}},
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		start := min((page-1)*listPageSize, len(organizations))
+		end := min(start+listPageSize, len(organizations))
+
+		switch r.URL.Path {
+		case "/user/orgs":
+			json.NewEncoder(w).Encode(organizations[start:end])
+		case "/user/teams":
+			if page > 1 {
+				json.NewEncoder(w).Encode([]githubTeam{})
+				return
+			}
+			json.NewEncoder(w).Encode(teams)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	memberships := githubMemberships(GitHubConfig{APIURL: server.URL + "/"})
+
+	tests := []struct {
+		login string
+		teams []string
+	}{
+		{login: "org-0", teams: []string{"ops", "dev"}},
+		{login: "org-1", teams: []string{}},
+		{login: "org-100", teams: []string{"web"}},
+	}
+
+	got, err := memberships(context.Background(), "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != len(organizations) {
+		t.Errorf("got %d organizations, want %d", len(got), len(organizations))
+	}
+
+	for _, test := range tests {
+		if teams, ok := got[test.login]; !ok || !slices.Equal(teams, test.teams) {
+			t.Errorf("%s: got teams %v, want %v", test.login, teams, test.teams)
+		}
+	}
+
+	if _, err := memberships(context.Background(), "wrong"); err == nil {
+		t.Error("request with a rejected token succeeded")
+	}
+}
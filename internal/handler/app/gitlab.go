@@ -0,0 +1,48 @@
+package app
+
+import (
+	"context"
+
+	"strings"
+)
+
+type GitLabConfig struct {
+	ClientID string `env:"CLIENT_ID"`
+	Secret   string `env:"SECRET"`
+	// URL of the GitLab instance, for self-hosted GitLab
+	URL string `env:"URL" envDefault:"https://gitlab.com"`
+	MembershipConfig
+}
+
+type gitlabGroup struct {
+	FullPath string `json:"full_path"`
+}
+
+// gitlabMemberships returns the top-level groups a GitLab user belongs to, keyed by path. Subgroups
+// are the teams of their top-level group, named by their path below it with "/" replaced by "-".
+func gitlabMemberships(config GitLabConfig) func(ctx context.Context, accessToken string) (map[string][]string, error) {
+	baseURL := strings.TrimSuffix(config.URL, "/")
+
+	return func(ctx context.Context, accessToken string) (map[string][]string, error) {
+		groups := []gitlabGroup{}
+		if err := listPages(ctx, baseURL+"/api/v4/groups?min_access_level=10", accessToken, &groups); err != nil {
+			return nil, err
+		}
+
+		memberships := map[string][]string{}
+
+		for _, group := range groups {
+			organization, subgroup, _ := strings.Cut(group.FullPath, "/")
+
+			if _, ok := memberships[organization]; !ok {
+				memberships[organization] = []string{}
+			}
+
+			if subgroup != "" {
+				memberships[organization] = append(memberships[organization], strings.ReplaceAll(subgroup, "/", "-"))
+			}
+		}
+
+		return memberships, nil
+	}
+}
@@ -0,0 +1,325 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/tinkerborg/open-pulumi-service/internal/model"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/audit"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/auth"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/state"
+	"github.com/tinkerborg/open-pulumi-service/internal/store"
+	"github.com/tinkerborg/open-pulumi-service/pkg/router"
+)
+
+const loginCookieName = "login_state"
+
+// the CLI sends a hex encoded random nonce
+var validNonce = regexp.MustCompile(`^[a-zA-Z0-9_-]{16,256}$`)
+
+// beginLogin records a login in progress for a CLI session and binds it to the browser with a
+// cookie, returning the state parameter to send to the login provider.
+//
+// An identity link is started through the API, and its state only goes on to the provider in a
+// browser it is bound to. A link URL opened anywhere else asks the user to confirm the link by
+// logging in as the user who started it, and only that login binds a state to the browser.
+// beginLogin then responds itself and returns an empty state.
+func beginLogin(w *router.ResponseWriter, r *http.Request, a *auth.Service, s *state.Service, config OAuthConfig, enabled map[string]bool, provider string) (string, *model.LoginStateRecord, error) {
+	query := r.URL.Query()
+
+	if state := query.Get("link"); state != "" {
+		login, err := a.ReadLoginState(r.Context(), provider, state)
+		if err != nil {
+			return "", nil, err
+		}
+
+		if login.LinkUserID == "" {
+			return "", nil, auth.ErrInvalidLoginState
+		}
+
+		if cookie, err := r.Cookie(loginCookieName); err == nil && cookie.Value == auth.LoginStateID(state) {
+			return state, login, nil
+		}
+
+		if via := query.Get("via"); via != "" {
+			return "", nil, confirmLink(w, r, a, config, enabled, login, provider, via)
+		}
+
+		return "", nil, renderLinkConfirmation(w, r, s, enabled, login, provider, state)
+	}
+
+	port, nonce := query.Get("port"), query.Get("nonce")
+
+	if err := validateCLISession(port, nonce); err != nil {
+		return "", nil, err
+	}
+
+	login := &model.LoginStateRecord{
+		Provider: provider,
+		Port:     port,
+		Nonce:    nonce,
+	}
+
+	state, err := a.CreateLoginState(r.Context(), login)
+	if err != nil {
+		return "", nil, err
+	}
+
+	http.SetCookie(w, loginCookie(config, auth.LoginStateID(state), 600))
+
+	return state, login, nil
+}
+
+// consumeLogin returns the login a provider callback completes. The state must be signed, unused,
+// and belong to the browser the login started in.
+func consumeLogin(w *router.ResponseWriter, r *http.Request, a *auth.Service, config OAuthConfig, provider string) (*model.LoginStateRecord, error) {
+	state := r.URL.Query().Get("state")
+
+	cookie, err := r.Cookie(loginCookieName)
+	if err != nil || cookie.Value != auth.LoginStateID(state) {
+		return nil, auth.ErrInvalidLoginState
+	}
+
+	http.SetCookie(w, loginCookie(config, "", -1))
+
+	return a.ConsumeLoginState(r.Context(), provider, state)
+}
+
+// renderLinkConfirmation asks the user to confirm a link by logging in with one of the identities
+// the user who started it already has.
+func renderLinkConfirmation(w *router.ResponseWriter, r *http.Request, s *state.Service, enabled map[string]bool, login *model.LoginStateRecord, provider string, linkState string) error {
+	user, err := s.GetUser(r.Context(), login.LinkUserID)
+	if err != nil {
+		return err
+	}
+
+	identities, err := s.ListUserIdentities(r.Context(), user.ID)
+	if err != nil {
+		return err
+	}
+
+	type option struct{ Provider, URL string }
+
+	options := []option{}
+	for _, identity := range identities {
+		if enabled[identity.Provider] {
+			options = append(options, option{
+				Provider: identity.Provider,
+				URL:      "/auth/" + url.PathEscape(provider) + "/?" + url.Values{"link": {linkState}, "via": {identity.Provider}}.Encode(),
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+
+	return linkConfirmPage.Execute(w, struct {
+		Login    string
+		Provider string
+		Options  []option
+	}{
+		Login:    user.GitHubLogin,
+		Provider: provider,
+		Options:  options,
+	})
+}
+
+// confirmLink starts the login that confirms a link, with the provider of an identity the user
+// already has, and binds its state to the browser.
+func confirmLink(w *router.ResponseWriter, r *http.Request, a *auth.Service, config OAuthConfig, enabled map[string]bool, link *model.LoginStateRecord, provider string, via string) error {
+	if !enabled[via] {
+		return fmt.Errorf("login provider '%s' is not configured", via)
+	}
+
+	state, err := a.CreateLoginState(r.Context(), &model.LoginStateRecord{
+		Provider:     via,
+		LinkUserID:   link.LinkUserID,
+		LinkProvider: provider,
+	})
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, loginCookie(config, auth.LoginStateID(state), 600))
+	http.Redirect(w, r, "/auth/"+url.PathEscape(via)+"/?"+url.Values{"link": {state}}.Encode(), http.StatusSeeOther)
+
+	return nil
+}
+
+// continueLink completes the login confirming a link. Only the user who started the link
+// confirms it; their browser is then bound to a state for the linked provider and sent on to it.
+func continueLink(w *router.ResponseWriter, r *http.Request, a *auth.Service, s *state.Service, config OAuthConfig, login *model.LoginStateRecord, provider string, subject string) error {
+	audit.SetAction(r, "user.identity.link.confirm")
+
+	identity, err := s.GetIdentity(r.Context(), provider, subject)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return w.Error(err)
+	}
+
+	if identity == nil || identity.UserID != login.LinkUserID {
+		return w.WithStatus(http.StatusForbidden).Errorf("only the user who started the link can confirm it")
+	}
+
+	state, err := a.CreateLoginState(r.Context(), &model.LoginStateRecord{
+		Provider:   login.LinkProvider,
+		LinkUserID: login.LinkUserID,
+	})
+	if err != nil {
+		return w.Error(err)
+	}
+
+	http.SetCookie(w, loginCookie(config, auth.LoginStateID(state), 600))
+	http.Redirect(w, r, "/auth/"+url.PathEscape(login.LinkProvider)+"/?"+url.Values{"link": {state}}.Encode(), http.StatusSeeOther)
+
+	return nil
+}
+
+func loginCookie(config OAuthConfig, value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     loginCookieName,
+		Value:    value,
+		Path:     "/auth/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(config.AppBaseURL, "https://"),
+		// Lax so the cookie is sent on the top level redirect back from the provider
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+func validateCLISession(port string, nonce string) error {
+	if value, err := strconv.Atoi(port); err != nil || value < 1 || value > 65535 {
+		return fmt.Errorf("invalid CLI port '%s'", port)
+	}
+
+	if !validNonce.MatchString(nonce) {
+		return errors.New("invalid CLI nonce")
+	}
+
+	return nil
+}
+
+// completeLogin issues an access token and renders a page that hands it to the CLI waiting on
+// localhost. The page navigates to the CLI rather than fetching from it, as the CLI answers with a
+// redirect to /welcome/cli, which a page can't follow from script. Replacing the page keeps the
+// token out of the service's own history entry.
+func completeLogin(w *router.ResponseWriter, r *http.Request, a *auth.Service, user *model.ServiceUser, login *model.LoginStateRecord) error {
+	audit.SetActor(r, audit.Actor{Type: auth.UserToken, ID: user.ID, Name: user.GitHubLogin})
+
+	token, err := a.CreateToken(r.Context(), user.ID, auth.UserToken)
+	if err != nil {
+		return w.Error(err)
+	}
+
+	callbackURL := "http://localhost:" + login.Port + "/?" + url.Values{
+		"accessToken": {token},
+		"nonce":       {login.Nonce},
+	}.Encode()
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+
+	return loginCompletePage.Execute(w, struct {
+		Login       string
+		CallbackURL string
+	}{
+		Login:       user.GitHubLogin,
+		CallbackURL: callbackURL,
+	})
+}
+
+// completeLink renders a page confirming that an identity was linked to the user who started the
+// link. No token is issued, the user keeps using the one they linked with.
+func completeLink(w *router.ResponseWriter, r *http.Request, user *model.ServiceUser, provider string) error {
+	audit.SetActor(r, audit.Actor{Type: auth.UserToken, ID: user.ID, Name: user.GitHubLogin})
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+
+	return linkCompletePage.Execute(w, struct {
+		Login    string
+		Provider string
+	}{
+		Login:    user.GitHubLogin,
+		Provider: provider,
+	})
+}
+
+func loginTarget(provider string) func(r *http.Request) audit.Target {
+	return func(r *http.Request) audit.Target {
+		return audit.Target{Name: provider}
+	}
+}
+
+var loginCompletePage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Login complete</title>
+<style>body { font-family: sans-serif; margin: 4em auto; max-width: 36em; text-align: center; }</style>
+</head>
+<body>
+<h1>Completing login&hellip;</h1>
+<p>Logged in as {{.Login}}. Returning to the CLI&hellip;</p>
+<p><a href="{{.CallbackURL}}">Continue</a> if nothing happens.</p>
+<script>window.location.replace({{.CallbackURL}});</script>
+</body>
+</html>
+`))
+
+var linkConfirmPage = template.Must(template.New("link-confirm").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Confirm identity link</title>
+<style>body { font-family: sans-serif; margin: 4em auto; max-width: 36em; text-align: center; }</style>
+</head>
+<body>
+<h1>Link a {{.Provider}} identity to {{.Login}}?</h1>
+<p>Once linked, your {{.Provider}} identity logs in as {{.Login}}. Only continue if you are {{.Login}} and started this link.</p>
+{{if .Options}}<p>Confirm by logging in as {{.Login}} with:</p>
+<ul style="list-style: none; padding: 0">
+{{range .Options}}<li><a href="{{.URL}}">{{.Provider}}</a></li>
+{{end}}</ul>
+{{else}}<p>{{.Login}} has no identity at a configured login provider to confirm the link with.</p>
+{{end}}</body>
+</html>
+`))
+
+var linkCompletePage = template.Must(template.New("link").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Identity linked</title>
+<style>body { font-family: sans-serif; margin: 4em auto; max-width: 36em; text-align: center; }</style>
+</head>
+<body>
+<h1>Identity linked</h1>
+<p>Your {{.Provider}} identity is linked to {{.Login}}, and can be used to log in as {{.Login}}. You can close this window.</p>
+</body>
+</html>
+`))
+
+const welcomePage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Login complete</title>
+<style>body { font-family: sans-serif; margin: 4em auto; max-width: 36em; text-align: center; }</style>
+</head>
+<body>
+<h1>Login complete</h1>
+<p>You can close this window and return to the CLI.</p>
+</body>
+</html>
+`
@@ -0,0 +1,128 @@
+package app
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoginCompletePage(t *testing.T) {
+	tests := []struct {
+		name        string
+		login       string
+		callbackURL string
+		contains    []string
+		excludes    []string
+	}{
+		{
+			name:        "navigates to the CLI",
+			login:       "jdoe",
+			callbackURL: "http://localhost:1234/?accessToken=token&nonce=nonce",
+			contains:    []string{`window.location.replace("http://localhost:1234/?accessToken=token\u0026nonce=nonce")`, "Logged in as jdoe."},
+			excludes:    []string{"fetch("},
+		},
+		{
+			name:        "escapes the login",
+			login:       "<script>",
+			callbackURL: "http://localhost:1234/",
+			contains:    []string{"Logged in as &lt;script&gt;."},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			page := &strings.Builder{}
+
+			err := loginCompletePage.Execute(page, struct {
+				Login       string
+				CallbackURL string
+			}{test.login, test.callbackURL})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, s := range test.contains {
+				if !strings.Contains(page.String(), s) {
+					t.Errorf("page does not contain %s:\n%s", s, page)
+				}
+			}
+
+			for _, s := range test.excludes {
+				if strings.Contains(page.String(), s) {
+					t.Errorf("page contains %s", s)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateCLISession(t *testing.T) {
+	tests := []struct {
+		name    string
+		port    string
+		nonce   string
+		wantErr bool
+	}{
+		{name: "valid", port: "8080", nonce: "0123456789abcdef"},
+		{name: "port out of range", port: "70000", nonce: "0123456789abcdef", wantErr: true},
+		{name: "port not a number", port: "80a", nonce: "0123456789abcdef", wantErr: true},
+		{name: "short nonce", port: "8080", nonce: "abc", wantErr: true},
+		{name: "nonce with markup", port: "8080", nonce: "0123456789abcdef<", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := validateCLISession(test.port, test.nonce); (err != nil) != test.wantErr {
+				t.Errorf("got error %v, want error %t", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestLinkConfirmPage(t *testing.T) {
+	type option struct{ Provider, URL string }
+
+	tests := []struct {
+		name     string
+		login    string
+		options  []option
+		contains []string
+	}{
+		{
+			name:     "offers the user's identities",
+			login:    "jdoe",
+			options:  []option{{"github", "/auth/gitlab/?link=state&via=github"}},
+			contains: []string{"Link a gitlab identity to jdoe?", `<a href="/auth/gitlab/?link=state&amp;via=github">github</a>`},
+		},
+		{
+			name:     "without identities",
+			login:    "jdoe",
+			contains: []string{"jdoe has no identity at a configured login provider"},
+		},
+		{
+			name:     "escapes the login",
+			login:    "<script>",
+			contains: []string{"Link a gitlab identity to &lt;script&gt;?"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			page := &strings.Builder{}
+
+			err := linkConfirmPage.Execute(page, struct {
+				Login    string
+				Provider string
+				Options  []option
+			}{test.login, "gitlab", test.options})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, s := range test.contains {
+				if !strings.Contains(page.String(), s) {
+					t.Errorf("page does not contain %s:\n%s", s, page)
+				}
+			}
+		})
+	}
+}
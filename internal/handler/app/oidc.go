@@ -0,0 +1,250 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/tinkerborg/open-pulumi-service/internal/model"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/audit"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/auth"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/state"
+	"github.com/tinkerborg/open-pulumi-service/internal/store"
+	"github.com/tinkerborg/open-pulumi-service/pkg/router"
+	"golang.org/x/oauth2"
+)
+
+const oidcProvider = "oidc"
+
+// OIDCConfig configures login against any OpenID Connect provider. It uses the OAuth client ID and
+// secret.
+type OIDCConfig struct {
+	Issuer string   `env:"ISSUER"`
+	Scopes []string `env:"SCOPES" envSeparator:"," envDefault:"openid,profile,email"`
+	// Claims mapped onto the fields of new users. Users are identified by the issuer and subject
+	// only, as providers let users change the other claims.
+	LoginClaim  string `env:"LOGIN_CLAIM" envDefault:"preferred_username"`
+	NameClaim   string `env:"NAME_CLAIM" envDefault:"name"`
+	EmailClaim  string `env:"EMAIL_CLAIM" envDefault:"email"`
+	AvatarClaim string `env:"AVATAR_CLAIM" envDefault:"picture"`
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+}
+
+// oidcClient discovers the provider's endpoints on first use, so a provider that is briefly
+// unreachable doesn't stop the service from starting.
+type oidcClient struct {
+	config      OAuthConfig
+	mutex       sync.Mutex
+	oauthConfig *oauth2.Config
+	discovery   *oidcDiscovery
+}
+
+func setupOIDC(a *auth.Service, s *state.Service, config OAuthConfig, enabled map[string]bool) router.Setup {
+	client := &oidcClient{config: config}
+
+	return func(r *router.Router) {
+		r.GET("/auth/oidc/{$}", func(w *router.ResponseWriter, r *http.Request) error {
+			oauthConfig, _, err := client.discover(r.Context())
+			if err != nil {
+				return w.WithStatus(http.StatusBadGateway).Error(err)
+			}
+
+			state, login, err := beginLogin(w, r, a, s, config, enabled, oidcProvider)
+			if err != nil {
+				return w.WithStatus(http.StatusBadRequest).Error(err)
+			} else if state == "" {
+				return nil
+			}
+
+			url := oauthConfig.AuthCodeURL(state, oauth2.S256ChallengeOption(login.Verifier))
+
+			http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+
+			return nil
+		})
+
+		r.GET("/auth/oidc/callback/{$}", func(w *router.ResponseWriter, r *http.Request) error {
+			oauthConfig, discovery, err := client.discover(r.Context())
+			if err != nil {
+				return w.WithStatus(http.StatusBadGateway).Error(err)
+			}
+
+			query := r.URL.Query()
+
+			login, err := consumeLogin(w, r, a, config, oidcProvider)
+			if err != nil {
+				return w.WithStatus(http.StatusBadRequest).Error(err)
+			}
+
+			if errorCode := query.Get("error"); errorCode != "" {
+				return w.WithStatus(http.StatusUnauthorized).Errorf("login failed: %s %s", errorCode, query.Get("error_description"))
+			}
+
+			token, err := oauthConfig.Exchange(r.Context(), query.Get("code"), oauth2.VerifierOption(login.Verifier))
+			if err != nil {
+				return w.WithStatus(http.StatusUnauthorized).Errorf("login failed: %s", err)
+			}
+
+			claims, err := oidcUserInfo(r.Context(), oauthConfig, discovery, token)
+			if err != nil {
+				return w.WithStatus(http.StatusBadGateway).Error(err)
+			}
+
+			sessionUser, err := client.serviceUser(claims)
+			if err != nil {
+				return w.WithStatus(http.StatusUnauthorized).Error(err)
+			}
+
+			subject, _ := claims["sub"].(string)
+			if subject == "" {
+				return w.WithStatus(http.StatusUnauthorized).Errorf("oidc claim 'sub' is missing")
+			}
+
+			if login.LinkProvider != "" {
+				return continueLink(w, r, a, s, config, login, oidcProvider, oidcSubject(discovery.Issuer, subject))
+			}
+
+			if login.LinkUserID != "" {
+				audit.SetAction(r, "user.identity.link")
+
+				user, err := s.LinkIdentity(r.Context(), login.LinkUserID, oidcProvider, oidcSubject(discovery.Issuer, subject), sessionUser.GitHubLogin)
+				if err != nil {
+					return w.Error(err)
+				}
+
+				return completeLink(w, r, user, oidcProvider)
+			}
+
+			// subjects are only unique per issuer; identities stored before they were qualified
+			// with the issuer are moved over on login
+			options := state.IdentityOptions{PreviousSubject: subject}
+
+			user, err := s.LoginIdentity(r.Context(), oidcProvider, oidcSubject(discovery.Issuer, subject), sessionUser, options)
+			if err != nil {
+				if errors.Is(err, store.ErrExist) {
+					return w.WithStatus(http.StatusConflict).Error(err)
+				}
+				return w.Error(err)
+			}
+
+			return completeLogin(w, r, a, user, login)
+		}, audit.Action("user.login", loginTarget(oidcProvider)))
+	}
+}
+
+// oidcSubject identifies a user by their issuer and subject, as subjects are only unique per
+// issuer.
+func oidcSubject(issuer string, subject string) string {
+	return strings.TrimSuffix(issuer, "/") + "#" + subject
+}
+
+func (c *oidcClient) discover(ctx context.Context) (*oauth2.Config, *oidcDiscovery, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.discovery != nil {
+		return c.oauthConfig, c.discovery, nil
+	}
+
+	issuer := strings.TrimSuffix(c.config.OIDC.Issuer, "/")
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, nil, fmt.Errorf("oidc discovery failed: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("oidc discovery failed: %s", response.Status)
+	}
+
+	discovery := &oidcDiscovery{}
+	if err := json.NewDecoder(response.Body).Decode(discovery); err != nil {
+		return nil, nil, fmt.Errorf("invalid oidc discovery document: %w", err)
+	}
+
+	if strings.TrimSuffix(discovery.Issuer, "/") != issuer {
+		return nil, nil, fmt.Errorf("oidc discovery issuer '%s' does not match '%s'", discovery.Issuer, issuer)
+	}
+
+	if discovery.UserinfoEndpoint == "" {
+		return nil, nil, fmt.Errorf("oidc provider has no userinfo endpoint")
+	}
+
+	c.discovery = discovery
+	c.oauthConfig = &oauth2.Config{
+		ClientID:     c.config.ClientID,
+		ClientSecret: c.config.Secret,
+		RedirectURL:  c.config.AppBaseURL + "/auth/oidc/callback",
+		Scopes:       c.config.OIDC.Scopes,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  discovery.AuthorizationEndpoint,
+			TokenURL: discovery.TokenEndpoint,
+		},
+	}
+
+	return c.oauthConfig, c.discovery, nil
+}
+
+// serviceUser maps userinfo claims onto a service user using the configured claim names.
+func (c *oidcClient) serviceUser(claims map[string]any) (*model.ServiceUser, error) {
+	claim := func(name string) string {
+		value, _ := claims[name].(string)
+		return value
+	}
+
+	config := c.config.OIDC
+
+	login := claim(config.LoginClaim)
+	if login == "" {
+		return nil, fmt.Errorf("oidc claim '%s' is missing", config.LoginClaim)
+	}
+
+	name := claim(config.NameClaim)
+	if name == "" {
+		name = login
+	}
+
+	return &model.ServiceUser{
+		GitHubLogin:   login,
+		Name:          name,
+		Email:         claim(config.EmailClaim),
+		AvatarURL:     claim(config.AvatarClaim),
+		Organizations: []model.ServiceUserInfo{},
+		Identities:    []string{},
+	}, nil
+}
+
+func oidcUserInfo(ctx context.Context, oauthConfig *oauth2.Config, discovery *oidcDiscovery, token *oauth2.Token) (map[string]any, error) {
+	response, err := oauthConfig.Client(ctx, token).Get(discovery.UserinfoEndpoint)
+	if err != nil {
+		return nil, fmt.Errorf("oidc userinfo request failed: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc userinfo request failed: %s", response.Status)
+	}
+
+	claims := map[string]any{}
+	if err := json.NewDecoder(response.Body).Decode(&claims); err != nil {
+		return nil, fmt.Errorf("invalid oidc userinfo response: %w", err)
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,83 @@
+package app
+
+import (
+	"testing"
+)
+
+func TestOIDCSubject(t *testing.T) {
+	tests := []struct {
+		issuer  string
+		subject string
+		want    string
+	}{
+		{issuer: "https://login.example.com", subject: "1234", want: "https://login.example.com#1234"},
+		{issuer: "https://login.example.com/", subject: "1234", want: "https://login.example.com#1234"},
+		{issuer: "https://example.com/realms/a", subject: "x#y", want: "https://example.com/realms/a#x#y"},
+	}
+
+	for _, test := range tests {
+		if got := oidcSubject(test.issuer, test.subject); got != test.want {
+			t.Errorf("oidcSubject(%s, %s) = '%s', want '%s'", test.issuer, test.subject, got, test.want)
+		}
+	}
+}
+
+func TestOIDCServiceUser(t *testing.T) {
+	client := &oidcClient{config: OAuthConfig{OIDC: OIDCConfig{
+		LoginClaim:  "preferred_username",
+		NameClaim:   "name",
+		EmailClaim:  "email",
+		AvatarClaim: "picture",
+	}}}
+
+	tests := []struct {
+		name   string
+		claims map[string]any
+		login  string
+		user   string
+		err    bool
+	}{
+		{
+			name:   "all claims",
+			claims: map[string]any{"preferred_username": "jdoe", "name": "J Doe", "email": "j@example.com"},
+			login:  "jdoe",
+			user:   "J Doe",
+		},
+		{
+			name:   "name defaults to login",
+			claims: map[string]any{"preferred_username": "jdoe"},
+			login:  "jdoe",
+			user:   "jdoe",
+		},
+		{
+			name:   "missing login",
+			claims: map[string]any{"name": "J Doe"},
+			err:    true,
+		},
+		{
+			name:   "login of the wrong type",
+			claims: map[string]any{"preferred_username": 42},
+			err:    true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			user, err := client.serviceUser(test.claims)
+
+			if test.err {
+				if err == nil {
+					t.Fatal("succeeded, want error")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user.GitHubLogin != test.login || user.Name != test.user {
+				t.Errorf("got (%s, %s), want (%s, %s)", user.GitHubLogin, user.Name, test.login, test.user)
+			}
+		})
+	}
+}
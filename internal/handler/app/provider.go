@@ -0,0 +1,216 @@
+package app
+
+import (
+	"context"
+
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/markbates/goth"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/audit"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/auth"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/state"
+	"github.com/tinkerborg/open-pulumi-service/internal/store"
+	"github.com/tinkerborg/open-pulumi-service/pkg/router"
+)
+
+const (
+	listPageSize = 100
+	// providerTimeout bounds each request to a login provider, so a slow provider fails logins
+	// rather than holding them open
+	providerTimeout = 10 * time.Second
+)
+
+// providerClient makes the requests to login providers, for goth as well as membership lookups.
+var providerClient = &http.Client{Timeout: providerTimeout}
+
+// MembershipConfig restricts login to members of provider organizations (GitHub organizations,
+// GitLab groups, Bitbucket workspaces) and maps them onto service organizations.
+type MembershipConfig struct {
+	// AllowedOrgs restricts login to members of these organizations, empty allows anyone
+	AllowedOrgs []string `env:"ALLOWED_ORGS" envSeparator:","`
+	// OrgMapping maps provider organizations to the service organizations their members join, as
+	// provider-org:service-org pairs. Unmapped organizations grant nothing, as anyone can create a
+	// service organization named after a provider organization they don't belong to.
+	OrgMapping map[string]string `env:"ORG_MAPPING"`
+}
+
+// loginProvider is an OAuth provider users log in with through goth.
+type loginProvider struct {
+	goth.Provider
+	membership MembershipConfig
+	// memberships returns the provider organizations a user belongs to, keyed by name, with the
+	// names of their teams in each
+	memberships func(ctx context.Context, accessToken string) (map[string][]string, error)
+}
+
+func setupProvider(a *auth.Service, s *state.Service, config OAuthConfig, enabled map[string]bool, provider loginProvider) router.Setup {
+	name := provider.Name()
+
+	return func(r *router.Router) {
+		r.GET("/auth/"+name+"/{$}", func(w *router.ResponseWriter, r *http.Request) error {
+			state, _, err := beginLogin(w, r, a, s, config, enabled, name)
+			if err != nil {
+				return w.WithStatus(http.StatusBadRequest).Error(err)
+			} else if state == "" {
+				return nil
+			}
+
+			session, err := provider.BeginAuth(state)
+			if err != nil {
+				return w.Error(err)
+			}
+
+			url, err := session.GetAuthURL()
+			if err != nil {
+				return w.Error(err)
+			}
+
+			http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+
+			return nil
+		})
+
+		r.GET("/auth/"+name+"/callback/{$}", func(w *router.ResponseWriter, r *http.Request) error {
+			login, err := consumeLogin(w, r, a, config, name)
+			if err != nil {
+				return w.WithStatus(http.StatusBadRequest).Error(err)
+			}
+
+			session, err := provider.BeginAuth(r.URL.Query().Get("state")) // Create session
+			if err != nil {
+				return w.Error(err)
+			}
+
+			_, err = session.Authorize(provider, r.URL.Query()) // Process code
+			if err != nil {
+				return w.Error(err)
+			}
+
+			sessionUser, err := provider.FetchUser(session)
+			if err != nil {
+				return w.Error(err)
+			}
+
+			if login.LinkProvider != "" {
+				return continueLink(w, r, a, s, config, login, name, sessionUser.UserID)
+			}
+
+			memberships, err := provider.memberships(r.Context(), sessionUser.AccessToken)
+			if err != nil {
+				return w.Error(err)
+			}
+
+			if !provider.membership.allowed(memberships) {
+				return w.WithStatus(http.StatusForbidden).Errorf("user is not a member of an allowed organization")
+			}
+
+			// memberships are only synced on login, as the identity's own user
+			if login.LinkUserID != "" {
+				audit.SetAction(r, "user.identity.link")
+
+				user, err := s.LinkIdentity(r.Context(), login.LinkUserID, name, sessionUser.UserID, sessionUser.NickName)
+				if err != nil {
+					return w.Error(err)
+				}
+
+				return completeLink(w, r, user, name)
+			}
+
+			user, err := s.LoginIdentity(r.Context(), name, sessionUser.UserID, createServiceUser(sessionUser))
+			if err != nil {
+				if errors.Is(err, store.ErrExist) {
+					return w.WithStatus(http.StatusConflict).Error(err)
+				}
+				return w.Error(err)
+			}
+
+			if err := s.SyncMemberships(r.Context(), user.ID, name, provider.membership.organizations(memberships)); err != nil {
+				return w.Error(err)
+			}
+
+			return completeLogin(w, r, a, user, login)
+		}, audit.Action("user.login", loginTarget(name)))
+	}
+}
+
+// allowed reports whether a user with the given memberships may log in.
+func (c MembershipConfig) allowed(memberships map[string][]string) bool {
+	if len(c.AllowedOrgs) == 0 {
+		return true
+	}
+
+	for organization := range memberships {
+		if slices.ContainsFunc(c.AllowedOrgs, func(allowed string) bool {
+			return strings.EqualFold(allowed, organization)
+		}) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// organizations maps provider memberships onto the service organizations they grant, through
+// OrgMapping only.
+func (c MembershipConfig) organizations(memberships map[string][]string) []state.ExternalOrganization {
+	organizations := []state.ExternalOrganization{}
+
+	for login, teams := range memberships {
+		if name, ok := c.OrgMapping[login]; ok {
+			organizations = append(organizations, state.ExternalOrganization{Name: name, Teams: teams})
+		}
+	}
+
+	return organizations
+}
+
+// listPages fetches every page of a list endpoint paged with per_page and page parameters, as used
+// by GitHub and GitLab.
+func listPages[T any](ctx context.Context, url string, accessToken string, items *[]T) error {
+	separator := "?"
+	if strings.Contains(url, "?") {
+		separator = "&"
+	}
+
+	for page := 1; ; page++ {
+		pageItems := []T{}
+
+		if err := getJSON(ctx, fmt.Sprintf("%s%sper_page=%d&page=%d", url, separator, listPageSize, page), accessToken, &pageItems); err != nil {
+			return err
+		}
+
+		*items = append(*items, pageItems...)
+
+		if len(pageItems) < listPageSize {
+			return nil
+		}
+	}
+}
+
+func getJSON(ctx context.Context, url string, accessToken string, value any) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Accept", "application/json")
+	request.Header.Set("Authorization", "Bearer "+accessToken)
+
+	response, err := providerClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("request %s failed: %s", url, response.Status)
+	}
+
+	return json.NewDecoder(response.Body).Decode(value)
+}
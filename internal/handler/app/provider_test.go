@@ -0,0 +1,68 @@
+package app
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/tinkerborg/open-pulumi-service/internal/service/state"
+)
+
+func TestMembershipConfigAllowed(t *testing.T) {
+	tests := []struct {
+		name        string
+		allowed     []string
+		memberships map[string][]string
+		want        bool
+	}{
+		{name: "anyone", memberships: map[string][]string{}, want: true},
+		{name: "member", allowed: []string{"acme"}, memberships: map[string][]string{"acme": {}}, want: true},
+		{name: "case insensitive", allowed: []string{"Acme"}, memberships: map[string][]string{"acme": {}}, want: true},
+		{name: "not a member", allowed: []string{"acme"}, memberships: map[string][]string{"other": {}}, want: false},
+		{name: "no memberships", allowed: []string{"acme"}, memberships: map[string][]string{}, want: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := MembershipConfig{AllowedOrgs: test.allowed}
+
+			if got := config.allowed(test.memberships); got != test.want {
+				t.Errorf("got %t, want %t", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMembershipConfigOrganizations(t *testing.T) {
+	tests := []struct {
+		name        string
+		mapping     map[string]string
+		memberships map[string][]string
+		want        []state.ExternalOrganization
+	}{
+		{
+			name:        "unmapped organizations grant nothing",
+			memberships: map[string][]string{"acme": {"ops"}},
+			want:        []state.ExternalOrganization{},
+		},
+		{
+			name:        "mapped organization",
+			mapping:     map[string]string{"acme-gh": "acme"},
+			memberships: map[string][]string{"acme-gh": {"ops", "dev"}, "other": {"x"}},
+			want:        []state.ExternalOrganization{{Name: "acme", Teams: []string{"ops", "dev"}}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := MembershipConfig{OrgMapping: test.mapping}
+
+			got := config.organizations(test.memberships)
+
+			if !slices.EqualFunc(got, test.want, func(a, b state.ExternalOrganization) bool {
+				return a.Name == b.Name && slices.Equal(a.Teams, b.Teams)
+			}) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
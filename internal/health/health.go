@@ -0,0 +1,142 @@
+// Package health serves liveness and readiness endpoints for orchestrators such as Kubernetes.
+package health
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tinkerborg/open-pulumi-service/pkg/router"
+)
+
+// checkTimeout bounds each readiness check, so a hanging dependency fails the probe instead of
+// timing it out.
+const checkTimeout = 5 * time.Second
+
+// Check reports whether a dependency is usable.
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Service tracks whether the service is ready to take requests.
+type Service struct {
+	checks   []namedCheck
+	draining atomic.Bool
+}
+
+func New() *Service {
+	return &Service{}
+}
+
+// Add registers a check that must pass for the service to be ready.
+func (h *Service) Add(name string, check Check) {
+	h.checks = append(h.checks, namedCheck{name, check})
+}
+
+// Drain marks the service as shutting down, failing readiness so no new requests are routed to it
+// while in-flight requests finish.
+func (h *Service) Drain() {
+	h.draining.Store(true)
+}
+
+// Setup serves /healthz, which succeeds while the process is serving requests, and /readyz, which
+// succeeds when every check passes.
+func (h *Service) Setup() router.Setup {
+	return func(r *router.Router) {
+		r.GET("/healthz/{$}", func(w *router.ResponseWriter, r *http.Request) error {
+			return w.JSON(&Status{Status: StatusOK})
+		})
+
+		r.GET("/readyz/{$}", func(w *router.ResponseWriter, r *http.Request) error {
+			status := h.ready(r.Context())
+			if status.Status != StatusOK {
+				// JSON sets the content type too late once the status is written
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+
+			return w.JSON(status)
+		})
+	}
+}
+
+// ready runs the checks concurrently. Failures are logged rather than returned, as the endpoint is
+// unauthenticated.
+func (h *Service) ready(ctx context.Context) *Status {
+	status := &Status{Status: StatusOK, Checks: map[string]string{}}
+
+	if h.draining.Load() {
+		status.Status = StatusUnavailable
+		return status
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	var mutex sync.Mutex
+	var wait sync.WaitGroup
+
+	for _, check := range h.checks {
+		wait.Add(1)
+
+		go func() {
+			defer wait.Done()
+
+			result := StatusOK
+			if err := check.check(ctx); err != nil {
+				slog.WarnContext(ctx, "readiness check failed", "check", check.name, "error", err)
+				result = StatusUnavailable
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			status.Checks[check.name] = result
+			if result != StatusOK {
+				status.Status = StatusUnavailable
+			}
+		}()
+	}
+
+	wait.Wait()
+
+	return status
+}
+
+// Cached wraps a check that is expensive or rate limited to run at most once per ttl, reusing its
+// last result in between.
+func Cached(ttl time.Duration, check Check) Check {
+	var mutex sync.Mutex
+	var checkedAt time.Time
+	var result error
+
+	return func(ctx context.Context) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if !checkedAt.IsZero() && time.Since(checkedAt) < ttl {
+			return result
+		}
+
+		result = check(ctx)
+		checkedAt = time.Now()
+
+		return result
+	}
+}
+
+const (
+	StatusOK          = "ok"
+	StatusUnavailable = "unavailable"
+)
+
+type Status struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
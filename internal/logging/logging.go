@@ -0,0 +1,82 @@
+// Package logging sets up structured logging with log/slog.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/tinkerborg/open-pulumi-service/pkg/router/middleware"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+type Config struct {
+	// Level is the minimum level logged: debug, info, warn or error
+	Level  string `env:"LOG_LEVEL" envDefault:"info"`
+	Format string `env:"LOG_FORMAT" envDefault:"json"`
+}
+
+// Setup makes slog, and the log package through it, write to stdout in the configured format.
+// Lines logged with a request's context carry the request's ID, the attributes handlers added with
+// middleware.AddLogAttrs and the trace ID.
+func Setup(config Config) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(config.Level)); err != nil {
+		return fmt.Errorf("invalid log level '%s'", config.Level)
+	}
+
+	handler, err := newHandler(os.Stdout, config.Format, level)
+	if err != nil {
+		return err
+	}
+
+	slog.SetDefault(slog.New(contextHandler{handler}))
+
+	return nil
+}
+
+func newHandler(w io.Writer, format string, level slog.Level) (slog.Handler, error) {
+	options := &slog.HandlerOptions{Level: level}
+
+	switch format {
+	case FormatJSON:
+		return slog.NewJSONHandler(w, options), nil
+	case FormatText:
+		return slog.NewTextHandler(w, options), nil
+	default:
+		return nil, fmt.Errorf("invalid log format '%s'", format)
+	}
+}
+
+// contextHandler adds request and trace attributes from the context to each record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	record.AddAttrs(middleware.LogAttrs(ctx)...)
+
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		record.AddAttrs(
+			slog.String("trace_id", span.TraceID().String()),
+			slog.String("span_id", span.SpanID().String()),
+		)
+	}
+
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
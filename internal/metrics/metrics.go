@@ -0,0 +1,137 @@
+// Package metrics exposes service metrics for Prometheus.
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/tinkerborg/open-pulumi-service/pkg/router"
+)
+
+const namespace = "open_pulumi_service"
+
+var (
+	requests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route pattern and status code.",
+	}, []string{"method", "route", "code"})
+
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by method and route pattern.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	updates = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "updates_total",
+		Help:      "Updates by kind and result, where result is started, succeeded or failed.",
+	}, []string{"kind", "result"})
+
+	checkpointSize = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "checkpoint_size_bytes",
+		Help:      "Size of checkpoints saved by updates.",
+		Buckets:   prometheus.ExponentialBuckets(1024, 4, 10),
+	})
+
+	engineEvents = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "engine_events_total",
+		Help:      "Engine events received from updates.",
+	})
+
+	cryptoDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "crypto_duration_seconds",
+		Help:      "Latency of crypto provider calls by operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	cryptoErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "crypto_errors_total",
+		Help:      "Failed crypto provider calls by operation.",
+	}, []string{"operation"})
+)
+
+// Setup serves metrics in the Prometheus text format.
+func Setup() router.Setup {
+	handler := promhttp.Handler()
+
+	return func(r *router.Router) {
+		r.GET("/{$}", func(w *router.ResponseWriter, r *http.Request) error {
+			handler.ServeHTTP(w, r)
+			return nil
+		})
+	}
+}
+
+// Middleware counts requests and their latency by route pattern rather than path, so stack names
+// and IDs don't multiply the series.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := router.NewStatusRecorder(w)
+
+		next.ServeHTTP(recorder, r)
+
+		route := router.RoutePattern(r)
+		if route == "" {
+			route = "unmatched"
+		}
+
+		method := methodLabel(r.Method)
+
+		requests.WithLabelValues(method, route, strconv.Itoa(recorder.StatusCode())).Inc()
+		requestDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+	})
+}
+
+// methodLabel collapses methods other than the standard ones to OTHER, as clients can send any
+// method and each would be a new series.
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "OTHER"
+}
+
+// RegisterDB exports connection pool statistics of a database.
+func RegisterDB(db *sql.DB, name string) error {
+	return prometheus.Register(collectors.NewDBStatsCollector(db, name))
+}
+
+func UpdateStarted(kind string) {
+	updates.WithLabelValues(kind, "started").Inc()
+}
+
+func UpdateCompleted(kind string, result string) {
+	updates.WithLabelValues(kind, result).Inc()
+}
+
+func CheckpointSaved(size int) {
+	checkpointSize.Observe(float64(size))
+}
+
+func EngineEventsReceived(count int) {
+	engineEvents.Add(float64(count))
+}
+
+// ObserveCrypto records the latency and outcome of a crypto provider call.
+func ObserveCrypto(operation string, start time.Time, err error) {
+	cryptoDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		cryptoErrors.WithLabelValues(operation).Inc()
+	}
+}
@@ -0,0 +1,28 @@
+package metrics
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestMethodLabel(t *testing.T) {
+	tests := []struct {
+		method string
+		want   string
+	}{
+		{method: http.MethodGet, want: "GET"},
+		{method: http.MethodPatch, want: "PATCH"},
+		{method: http.MethodOptions, want: "OPTIONS"},
+		{method: "get", want: "OTHER"},
+		{method: "PROPFIND", want: "OTHER"},
+		{method: "X-RANDOM-1234", want: "OTHER"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.method, func(t *testing.T) {
+			if got := methodLabel(test.method); got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
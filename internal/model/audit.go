@@ -0,0 +1,28 @@
+package model
+
+import "time"
+
+const (
+	AuditOutcomeSuccess = "success"
+	AuditOutcomeDenied  = "denied"
+	AuditOutcomeFailure = "failure"
+)
+
+// AuditEventRecord is an entry in the append-only audit log.
+type AuditEventRecord struct {
+	ID        uint      `json:"id" gorm:"primaryKey;autoIncrement"`
+	Timestamp time.Time `json:"timestamp" gorm:"index"`
+	// ActorType is the kind of token the actor used, or "system" for events recorded by services
+	ActorType    string `json:"actorType"`
+	ActorID      string `json:"-" gorm:"type:text"`
+	Actor        string `json:"actor" gorm:"index"`
+	TokenID      string `json:"tokenId,omitempty"`
+	Action       string `json:"action" gorm:"index"`
+	Organization string `json:"organization,omitempty" gorm:"index"`
+	// Stack is project/stack within the organization
+	Stack    string `json:"stack,omitempty" gorm:"index"`
+	Target   string `json:"target,omitempty"`
+	SourceIP string `json:"sourceIp,omitempty"`
+	Outcome  string `json:"outcome"`
+	Status   int    `json:"status,omitempty"`
+}
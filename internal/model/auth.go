@@ -2,17 +2,62 @@ package model
 
 import (
 	"crypto/rsa"
+	"time"
 )
 
+// AuthToken is an issued token. Tokens other than update tokens are only accepted while their
+// record exists, so deleting it revokes the token.
 type AuthToken struct {
 	ID       int
 	UserID   string   `gorm:"index"`
 	Value    string   `gorm:"index"`
 	Purposes []string `gorm:"type:jsonb"`
+	// Name is the name the token was created with, if any
+	Name      string
+	CreatedAt time.Time
 }
 
+// LoginStateRecord is a browser login in progress. It is consumed when the login provider calls
+// back, so each state can only complete one login.
+type LoginStateRecord struct {
+	ID       string `gorm:"primaryKey"`
+	Provider string
+	Port     string
+	Nonce    string
+	// Verifier is the PKCE code verifier, for providers that use PKCE
+	Verifier string
+	// LinkUserID is the user an identity link adds the provider identity to, empty for logins
+	LinkUserID string
+	// LinkProvider is set while the user confirms a link by logging in with an identity they
+	// already have, and is the provider whose identity is being linked
+	LinkProvider string
+	ExpiresAt    time.Time `gorm:"index"`
+	CreatedAt    time.Time
+}
+
+// SigningKeyRecord is a token signing key. The private key is stored encrypted by the crypto
+// service.
+type SigningKeyRecord struct {
+	ID           string `gorm:"primaryKey"`
+	EncryptedKey []byte
+	PublicKey    []byte
+	CreatedAt    time.Time `gorm:"index"`
+	// ExpiresAt is when tokens signed with the key stop being accepted
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+// RSAKey is the unencrypted signing key used before key rotation. It is imported as a signing key
+// and removed on startup.
 type RSAKey struct {
 	ID    int             `gorm:"primaryKey"`
 	Name  string          `gorm:"primaryKey"`
 	Value *rsa.PrivateKey `gorm:"type:jsonb;serializer:json"`
 }
+
+// RateLimitRecord counts a caller's requests in the current window, for rate limits shared by
+// replicas.
+type RateLimitRecord struct {
+	Key         string    `gorm:"primaryKey"`
+	WindowStart time.Time `gorm:"index"`
+	Count       int
+}
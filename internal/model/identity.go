@@ -0,0 +1,14 @@
+package model
+
+import "time"
+
+// IdentityRecord links an account at a login provider to a service user. A user can have an
+// identity at each provider they log in with.
+type IdentityRecord struct {
+	Provider  string           `gorm:"primaryKey"`
+	Subject   string           `gorm:"primaryKey"`
+	UserID    string           `gorm:"type:uuid;index"`
+	Login     string           `gorm:"type:text"`
+	User      *ServiceUserInfo `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	CreatedAt time.Time
+}
@@ -0,0 +1,82 @@
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/tinkerborg/open-pulumi-service/internal/errs"
+)
+
+const (
+	OIDCPolicyOrganization = "organization"
+	OIDCPolicyTeam         = "team"
+)
+
+// OIDCIssuerRecord is an external OIDC issuer, such as a CI system, whose tokens an organization
+// accepts in exchange for short-lived access tokens.
+type OIDCIssuerRecord struct {
+	ID             string `json:"id" gorm:"type:uuid;default:gen_random_uuid();uniqueIndex"`
+	OrganizationID string `json:"-" gorm:"primaryKey;type:uuid"`
+	Name           string `json:"name" gorm:"primaryKey"`
+	URL            string `json:"url" gorm:"index"`
+	// MaxExpiration caps the lifetime of exchanged tokens, in seconds
+	MaxExpiration int                 `json:"maxExpiration"`
+	Policies      []OIDCPolicy        `json:"policies" gorm:"type:jsonb;serializer:json"`
+	Organization  *OrganizationRecord `json:"-" gorm:"foreignKey:OrganizationID;references:ID;constraint:OnDelete:CASCADE"`
+	CreatedAt     time.Time           `json:"created"`
+	UpdatedAt     time.Time           `json:"modified"`
+}
+
+// OIDCPolicy allows exchanging tokens whose claims match every rule for a token of the given type.
+// Rules map claim names to patterns, where "*" matches any run of characters, so a policy can be
+// limited to a repository, branch or environment.
+type OIDCPolicy struct {
+	TokenType string            `json:"tokenType"`
+	TeamName  string            `json:"teamName,omitempty"`
+	Rules     map[string]string `json:"rules"`
+}
+
+func (p OIDCPolicy) Validate() error {
+	switch p.TokenType {
+	case OIDCPolicyOrganization:
+	case OIDCPolicyTeam:
+		if p.TeamName == "" {
+			return errs.New(errs.ErrInvalidArgument, "team policy requires a team name")
+		}
+	default:
+		return errs.Errorf(errs.ErrInvalidArgument, "invalid policy token type '%s'", p.TokenType)
+	}
+
+	if len(p.Rules) == 0 {
+		return errs.New(errs.ErrInvalidArgument, "policy requires at least one rule")
+	}
+
+	return nil
+}
+
+// Matches reports whether token claims satisfy every rule of the policy.
+func (p OIDCPolicy) Matches(claims map[string]interface{}) bool {
+	for claim, pattern := range p.Rules {
+		value, ok := claims[claim]
+		if !ok {
+			return false
+		}
+
+		if !matchPattern(pattern, fmt.Sprint(value)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func matchPattern(pattern string, value string) bool {
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+
+	return regexp.MustCompile("^" + strings.Join(parts, ".*") + "$").MatchString(value)
+}
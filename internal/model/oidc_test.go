@@ -0,0 +1,39 @@
+package model
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/tinkerborg/open-pulumi-service/internal/errs"
+)
+
+func TestOIDCPolicyValidate(t *testing.T) {
+	rules := map[string]string{"sub": "repo:*"}
+
+	tests := []struct {
+		name    string
+		policy  OIDCPolicy
+		invalid bool
+	}{
+		{name: "organization", policy: OIDCPolicy{TokenType: OIDCPolicyOrganization, Rules: rules}},
+		{name: "team", policy: OIDCPolicy{TokenType: OIDCPolicyTeam, TeamName: "ops", Rules: rules}},
+		{name: "team without name", policy: OIDCPolicy{TokenType: OIDCPolicyTeam, Rules: rules}, invalid: true},
+		{name: "unknown token type", policy: OIDCPolicy{TokenType: "personal", Rules: rules}, invalid: true},
+		{name: "no rules", policy: OIDCPolicy{TokenType: OIDCPolicyOrganization}, invalid: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.policy.Validate()
+			if !test.invalid {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, errs.ErrInvalidArgument) {
+				t.Fatalf("want ErrInvalidArgument, got %v", err)
+			}
+		})
+	}
+}
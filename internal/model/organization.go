@@ -0,0 +1,56 @@
+package model
+
+import (
+	"time"
+
+	"github.com/tinkerborg/open-pulumi-service/internal/errs"
+)
+
+type OrganizationRole string
+
+// Billing is not modelled, so there is no billing manager role.
+const (
+	OrganizationAdmin  OrganizationRole = "admin"
+	OrganizationMember OrganizationRole = "member"
+)
+
+func ParseOrganizationRole(role string) (OrganizationRole, error) {
+	switch OrganizationRole(role) {
+	case OrganizationAdmin, OrganizationMember:
+		return OrganizationRole(role), nil
+	}
+	return OrganizationRole(""), errs.Errorf(errs.ErrInvalidArgument, "invalid organization role '%s'", role)
+}
+
+type OrganizationRecord struct {
+	ID          string `json:"-" gorm:"type:uuid;default:gen_random_uuid();unique"`
+	Name        string `json:"githubLogin" gorm:"primaryKey"`
+	DisplayName string `json:"name"`
+	AvatarURL   string `json:"avatarUrl"`
+	// DefaultStackPermission is what members get on every stack before team grants, write unless
+	// set otherwise
+	DefaultStackPermission StackPermission `json:"defaultStackPermission" gorm:"default:102"`
+	CreatedAt              time.Time       `json:"-"`
+	UpdatedAt              time.Time       `json:"-"`
+}
+
+type OrganizationMemberRecord struct {
+	OrganizationID string              `json:"-" gorm:"primaryKey;type:uuid"`
+	UserID         string              `json:"-" gorm:"primaryKey;type:uuid;index"`
+	Role           OrganizationRole    `json:"role"`
+	Organization   *OrganizationRecord `json:"-" gorm:"foreignKey:OrganizationID;references:ID;constraint:OnDelete:CASCADE"`
+	User           *ServiceUserInfo    `json:"user" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	// Source names the identity provider that manages the membership, empty when added via the API
+	Source    string    `json:"-"`
+	CreatedAt time.Time `json:"created"`
+}
+
+// Info returns the organization in the shape the CLI expects in user responses.
+func (o *OrganizationRecord) Info() ServiceUserInfo {
+	return ServiceUserInfo{
+		ID:          o.ID,
+		Name:        o.DisplayName,
+		GitHubLogin: o.Name,
+		AvatarURL:   o.AvatarURL,
+	}
+}
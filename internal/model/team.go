@@ -0,0 +1,73 @@
+package model
+
+import (
+	"time"
+
+	"github.com/tinkerborg/open-pulumi-service/internal/errs"
+)
+
+// StackPermission values match the ones used by the Pulumi Cloud API.
+type StackPermission int
+
+const (
+	StackPermissionNone  StackPermission = 0
+	StackPermissionRead  StackPermission = 101
+	StackPermissionWrite StackPermission = 102
+	StackPermissionAdmin StackPermission = 103
+)
+
+func (p StackPermission) String() string {
+	switch p {
+	case StackPermissionRead:
+		return "read"
+	case StackPermissionWrite:
+		return "write"
+	case StackPermissionAdmin:
+		return "admin"
+	}
+	return "none"
+}
+
+// ParseStackPermission parses a permission that is granted, which none is not.
+func ParseStackPermission(permission int) (StackPermission, error) {
+	switch StackPermission(permission) {
+	case StackPermissionRead, StackPermissionWrite, StackPermissionAdmin:
+		return StackPermission(permission), nil
+	case StackPermissionNone:
+		return StackPermissionNone, errs.New(errs.ErrInvalidArgument, "stack permission must be read, write or admin")
+	}
+	return StackPermissionNone, errs.Errorf(errs.ErrInvalidArgument, "invalid stack permission '%d'", permission)
+}
+
+type TeamRecord struct {
+	ID             string `gorm:"type:uuid;default:gen_random_uuid();uniqueIndex"`
+	OrganizationID string `gorm:"primaryKey;type:uuid"`
+	Name           string `gorm:"primaryKey"`
+	DisplayName    string
+	Description    string
+	Organization   *OrganizationRecord `gorm:"foreignKey:OrganizationID;references:ID;constraint:OnDelete:CASCADE"`
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+type TeamMemberRecord struct {
+	TeamID string           `gorm:"primaryKey;type:uuid"`
+	UserID string           `gorm:"primaryKey;type:uuid;index"`
+	Team   *TeamRecord      `gorm:"foreignKey:TeamID;references:ID;constraint:OnDelete:CASCADE"`
+	User   *ServiceUserInfo `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	// Source names the identity provider that manages the membership, empty when added via the API
+	Source string
+}
+
+// AllStacks in place of a stack name grants a permission on every stack in a project.
+const AllStacks = "*"
+
+// TeamStackGrantRecord grants a team a permission on a stack, or on every stack in a project when
+// Stack is AllStacks.
+type TeamStackGrantRecord struct {
+	TeamID     string `gorm:"primaryKey;type:uuid"`
+	Project    string `gorm:"primaryKey"`
+	Stack      string `gorm:"primaryKey"`
+	Permission StackPermission
+	Team       *TeamRecord `gorm:"foreignKey:TeamID;references:ID;constraint:OnDelete:CASCADE"`
+}
@@ -0,0 +1,41 @@
+package model
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/tinkerborg/open-pulumi-service/internal/errs"
+)
+
+func TestParseStackPermission(t *testing.T) {
+	tests := []struct {
+		name       string
+		permission int
+		want       StackPermission
+		invalid    bool
+	}{
+		{name: "read", permission: 101, want: StackPermissionRead},
+		{name: "write", permission: 102, want: StackPermissionWrite},
+		{name: "admin", permission: 103, want: StackPermissionAdmin},
+		{name: "none", permission: 0, invalid: true},
+		{name: "unknown", permission: 104, invalid: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			permission, err := ParseStackPermission(test.permission)
+			if test.invalid {
+				if !errors.Is(err, errs.ErrInvalidArgument) {
+					t.Fatalf("want ErrInvalidArgument, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if permission != test.want {
+				t.Fatalf("want %s, got %s", test.want, permission)
+			}
+		})
+	}
+}
@@ -1,27 +1,30 @@
 package model
 
 import (
-	"fmt"
 	"time"
 
 	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+	"github.com/tinkerborg/open-pulumi-service/internal/errs"
 )
 
 type UpdateRecord struct {
-	ID              string                         `gorm:"index;type:uuid;default:gen_random_uuid()"`
-	StackID         string                         `gorm:"type:uuid;idx_stack_id_version_dryrun"`
-	Version         int                            `gorm:"index:idx_stack_id_version_dryrun"`
-	DryRun          *bool                          `gorm:"index:idx_stack_id_version_dryrun"`
-	Update          *apitype.UpdateProgram         `gorm:"type:jsonb;serializer:json"`
-	Options         *apitype.UpdateOptions         `gorm:"type:jsonb;serializer:json"`
-	Config          map[string]apitype.ConfigValue `gorm:"type:jsonb;serializer:json"`
-	Metadata        *apitype.UpdateMetadata        `gorm:"type:jsonb;serializer:json"`
-	Results         apitype.UpdateResults          `gorm:"type:jsonb;serializer:json"`
-	UserID          string                         `gorm:"type:uuid;index"`
-	RequestedBy     ServiceUserInfo                `gorm:"foreignKey:UserID"`
-	Checkpoint      CheckpointRecord               `gorm:"foreignKey:UpdateID;constraint:OnDelete:CASCADE"`
-	Events          []EngineEventRecord            `gorm:"foreignKey:UpdateID;constraint:OnDelete:CASCADE"`
-	ResourceChanges ResourceChanges                `gorm:"type:jsonb;serializer:json"`
+	ID          string                         `gorm:"index;type:uuid;default:gen_random_uuid()"`
+	StackID     string                         `gorm:"type:uuid;idx_stack_id_version_dryrun"`
+	Version     int                            `gorm:"index:idx_stack_id_version_dryrun"`
+	DryRun      *bool                          `gorm:"index:idx_stack_id_version_dryrun"`
+	Update      *apitype.UpdateProgram         `gorm:"type:jsonb;serializer:json"`
+	Options     *apitype.UpdateOptions         `gorm:"type:jsonb;serializer:json"`
+	Config      map[string]apitype.ConfigValue `gorm:"type:jsonb;serializer:json"`
+	Metadata    *apitype.UpdateMetadata        `gorm:"type:jsonb;serializer:json"`
+	Results     apitype.UpdateResults          `gorm:"type:jsonb;serializer:json"`
+	UserID      *string                        `gorm:"type:uuid;index"`
+	RequestedBy *ServiceUserInfo               `gorm:"foreignKey:UserID"`
+	// Requester is the team or organization whose token requested the update, as it was then;
+	// users are referenced by RequestedBy instead
+	Requester       *ServiceUserInfo    `gorm:"type:jsonb;serializer:json"`
+	Checkpoint      CheckpointRecord    `gorm:"foreignKey:UpdateID;constraint:OnDelete:CASCADE"`
+	Events          []EngineEventRecord `gorm:"foreignKey:UpdateID;constraint:OnDelete:CASCADE"`
+	ResourceChanges ResourceChanges     `gorm:"type:jsonb;serializer:json"`
 	ResourceCount   int
 	Kind            apitype.UpdateKind
 	StartTime       time.Time
@@ -36,11 +39,87 @@ type CheckpointRecord struct {
 }
 
 type EngineEventRecord struct {
-	UpdateID    string               `gorm:"primaryKey;type:text"`
+	UpdateID    string               `gorm:"primaryKey;type:text;index:idx_engine_event_update_type,priority:1"`
 	Sequence    int                  `gorm:"primaryKey;orderBy"`
+	Type        string               `gorm:"type:text;index:idx_engine_event_update_type,priority:2"`
+	URN         string               `gorm:"type:text"`
+	Severity    string               `gorm:"type:text"`
 	EngineEvent *apitype.EngineEvent `gorm:"type:jsonb;serializer:json"`
 }
 
+// NewEngineEventRecord creates a record for an engine event with its type, URN and severity
+// extracted into their own columns so that they can be filtered on.
+func NewEngineEventRecord(updateID string, event *apitype.EngineEvent) *EngineEventRecord {
+	record := &EngineEventRecord{
+		UpdateID:    updateID,
+		Sequence:    event.Sequence,
+		Type:        EngineEventType(event),
+		EngineEvent: event,
+	}
+
+	switch {
+	case event.DiagnosticEvent != nil:
+		record.URN = event.DiagnosticEvent.URN
+		record.Severity = event.DiagnosticEvent.Severity
+	case event.ResourcePreEvent != nil:
+		record.URN = event.ResourcePreEvent.Metadata.URN
+	case event.ResOutputsEvent != nil:
+		record.URN = event.ResOutputsEvent.Metadata.URN
+	case event.ResOpFailedEvent != nil:
+		record.URN = event.ResOpFailedEvent.Metadata.URN
+	case event.PolicyEvent != nil:
+		record.URN = event.PolicyEvent.ResourceURN
+		record.Severity = event.PolicyEvent.EnforcementLevel
+	case event.PolicyRemediationEvent != nil:
+		record.URN = event.PolicyRemediationEvent.ResourceURN
+	}
+
+	return record
+}
+
+// EngineEventType returns the name of the populated event field without its "Event" suffix,
+// e.g. "diagnostic" or "resourcePre".
+func EngineEventType(event *apitype.EngineEvent) string {
+	switch {
+	case event.CancelEvent != nil:
+		return "cancel"
+	case event.StdoutEvent != nil:
+		return "stdout"
+	case event.DiagnosticEvent != nil:
+		return "diagnostic"
+	case event.PreludeEvent != nil:
+		return "prelude"
+	case event.SummaryEvent != nil:
+		return "summary"
+	case event.ResourcePreEvent != nil:
+		return "resourcePre"
+	case event.ResOutputsEvent != nil:
+		return "resOutputs"
+	case event.ResOpFailedEvent != nil:
+		return "resOpFailed"
+	case event.PolicyEvent != nil:
+		return "policy"
+	case event.PolicyRemediationEvent != nil:
+		return "policyRemediation"
+	case event.PolicyLoadEvent != nil:
+		return "policyLoad"
+	case event.PolicyAnalyzeSummaryEvent != nil:
+		return "policyAnalyzeSummary"
+	case event.PolicyRemediateSummaryEvent != nil:
+		return "policyRemediateSummary"
+	case event.PolicyAnalyzeStackSummaryEvent != nil:
+		return "policyAnalyzeStackSummary"
+	case event.StartDebuggingEvent != nil:
+		return "startDebugging"
+	case event.ProgressEvent != nil:
+		return "progress"
+	case event.ErrorEvent != nil:
+		return "error"
+	}
+
+	return ""
+}
+
 type StackUpdate struct {
 	Info             apitype.UpdateInfo `json:"info"`
 	RequestedBy      *ServiceUserInfo   `json:"requestedBy"`
@@ -66,7 +145,7 @@ func ParseUpdateKind(kind string) (apitype.UpdateKind, error) {
 		apitype.ResourceImportUpdate:
 		return apitype.UpdateKind(kind), nil
 	}
-	return apitype.UpdateKind(""), fmt.Errorf("invalid update kind '%s'", kind)
+	return apitype.UpdateKind(""), errs.Errorf(errs.ErrInvalidArgument, "invalid update kind '%s'", kind)
 }
 
 func ConvertUpdateStatus(status apitype.UpdateStatus) apitype.UpdateResult {
@@ -83,3 +162,12 @@ func ConvertUpdateStatus(status apitype.UpdateStatus) apitype.UpdateResult {
 
 	return apitype.UpdateResult("")
 }
+
+func IsUpdateComplete(status apitype.UpdateStatus) bool {
+	switch status {
+	case apitype.StatusSucceeded, apitype.StatusFailed, apitype.UpdateStatusCancelled:
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,73 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+)
+
+func TestNewEngineEventRecord(t *testing.T) {
+	tests := []struct {
+		name     string
+		event    apitype.EngineEvent
+		kind     string
+		urn      string
+		severity string
+	}{
+		{
+			name:  "stdout",
+			event: apitype.EngineEvent{StdoutEvent: &apitype.StdoutEngineEvent{Message: "hello"}},
+			kind:  "stdout",
+		},
+		{
+			name:     "diagnostic",
+			event:    apitype.EngineEvent{DiagnosticEvent: &apitype.DiagnosticEvent{URN: "urn:a", Severity: "error"}},
+			kind:     "diagnostic",
+			urn:      "urn:a",
+			severity: "error",
+		},
+		{
+			name:  "resource pre",
+			event: apitype.EngineEvent{ResourcePreEvent: &apitype.ResourcePreEvent{Metadata: apitype.StepEventMetadata{URN: "urn:b"}}},
+			kind:  "resourcePre",
+			urn:   "urn:b",
+		},
+		{
+			name:     "policy",
+			event:    apitype.EngineEvent{PolicyEvent: &apitype.PolicyEvent{ResourceURN: "urn:c", EnforcementLevel: "mandatory"}},
+			kind:     "policy",
+			urn:      "urn:c",
+			severity: "mandatory",
+		},
+		{
+			name:  "summary",
+			event: apitype.EngineEvent{SummaryEvent: &apitype.SummaryEvent{}},
+			kind:  "summary",
+		},
+		{
+			name:  "empty",
+			event: apitype.EngineEvent{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.event.Sequence = 7
+
+			record := NewEngineEventRecord("update", &test.event)
+
+			if record.UpdateID != "update" || record.Sequence != 7 {
+				t.Errorf("got key (%s, %d), want (update, 7)", record.UpdateID, record.Sequence)
+			}
+			if record.Type != test.kind {
+				t.Errorf("got type '%s', want '%s'", record.Type, test.kind)
+			}
+			if record.URN != test.urn {
+				t.Errorf("got URN '%s', want '%s'", record.URN, test.urn)
+			}
+			if record.Severity != test.severity {
+				t.Errorf("got severity '%s', want '%s'", record.Severity, test.severity)
+			}
+		})
+	}
+}
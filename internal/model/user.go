@@ -5,14 +5,23 @@ package model
 // TODO understand what identities and tokeninfo do
 type ServiceUser struct {
 	ID            string            `gorm:"primaryKey;type:uuid;default:gen_random_uuid();"`
-	GitHubLogin   string            `json:"githubLogin" gorm:"uniqueIndex"`
+	GitHubLogin   string            `json:"githubLogin" gorm:"uniqueIndex:idx_service_user_login,where:service_account_org_id IS NULL;uniqueIndex:idx_service_account_login,priority:2"`
 	Name          string            `json:"name"`
 	Email         string            `json:"email" gorm:"uniqueIndex"`
 	AvatarURL     string            `json:"avatarUrl"`
-	Organizations []ServiceUserInfo `json:"organizations" gorm:"many2many:user_organizations;joinReferences:service_user_organization_id"`
+	Organizations []ServiceUserInfo `json:"organizations" gorm:"-"`
+	DefaultOrg    string            `json:"-"`
 	Identities    []string          `json:"identities" gorm:"-"`
 	SiteAdmin     *bool             `json:"siteAdmin,omitempty"`
 	TokenInfo     *ServiceTokenInfo `json:"tokenInfo,omitempty" gorm:"-"`
+	// ServiceAccountOrgID is the organization owning a service account, nil for people. Logins
+	// are unique among people, and service account logins only within their organization.
+	ServiceAccountOrgID *string `json:"-" gorm:"type:uuid;index;uniqueIndex:idx_service_account_login,priority:1"`
+}
+
+// IsServiceAccount reports whether the user is a machine user that can't log in interactively.
+func (u *ServiceUser) IsServiceAccount() bool {
+	return u.ServiceAccountOrgID != nil
 }
 
 type ServiceUserInfo struct {
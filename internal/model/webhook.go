@@ -0,0 +1,50 @@
+package model
+
+import "time"
+
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliverySucceeded = "succeeded"
+	WebhookDeliveryFailed    = "failed"
+)
+
+// WebhookRecord sends events to a URL. Organization webhooks have no project and stack name and
+// receive events for every stack in the organization.
+type WebhookRecord struct {
+	ID             string `gorm:"type:uuid;default:gen_random_uuid();uniqueIndex"`
+	OrganizationID string `gorm:"primaryKey;type:uuid"`
+	ProjectName    string `gorm:"primaryKey"`
+	StackName      string `gorm:"primaryKey"`
+	Name           string `gorm:"primaryKey"`
+	DisplayName    string
+	PayloadURL     string
+	// Secret signs payloads, empty sends them unsigned
+	Secret string
+	Active bool
+	Format string
+	// Filters limits the events sent, empty sends every event
+	Filters      []string            `gorm:"type:jsonb;serializer:json"`
+	Organization *OrganizationRecord `gorm:"foreignKey:OrganizationID;references:ID;constraint:OnDelete:CASCADE"`
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// WebhookDeliveryRecord is an event queued for a webhook, kept as delivery history once sent.
+type WebhookDeliveryRecord struct {
+	ID        string         `json:"id" gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	WebhookID string         `json:"-" gorm:"type:uuid;index"`
+	Webhook   *WebhookRecord `json:"-" gorm:"foreignKey:WebhookID;references:ID;constraint:OnDelete:CASCADE"`
+	Event     string         `json:"event"`
+	Kind      string         `json:"kind"`
+	Payload   string         `json:"payload"`
+	State     string         `json:"state" gorm:"index"`
+	Attempts  int            `json:"attempts"`
+	// NextAttemptAt is when a pending delivery is next sent
+	NextAttemptAt time.Time `json:"-" gorm:"index"`
+	ResponseCode  int       `json:"responseCode,omitempty"`
+	Error         string    `json:"error,omitempty"`
+	// Duration of the last attempt in milliseconds
+	Duration  int64     `json:"duration"`
+	CreatedAt time.Time `json:"timestamp"`
+	UpdatedAt time.Time `json:"updated"`
+}
@@ -0,0 +1,102 @@
+package audit
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/tinkerborg/open-pulumi-service/internal/model"
+	"github.com/tinkerborg/open-pulumi-service/internal/store"
+)
+
+// ActorSystem marks events recorded by services rather than on behalf of a request.
+const ActorSystem = "system"
+
+// Service writes and queries the audit log. Events are only ever inserted.
+type Service struct {
+	store *store.Postgres
+}
+
+func New(store *store.Postgres) *Service {
+	store.RegisterModels(model.AuditEventRecord{})
+
+	return &Service{store}
+}
+
+// Record appends an event to the audit log. Failing to record is logged rather than failing the
+// operation being audited, and cancelling the operation doesn't cancel recording it. A nil Service
+// records nothing.
+func (l *Service) Record(ctx context.Context, event *model.AuditEventRecord) {
+	if l == nil {
+		return
+	}
+
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+
+	if event.Outcome == "" {
+		event.Outcome = model.AuditOutcomeSuccess
+	}
+
+	if err := l.store.Create(context.WithoutCancel(ctx), event); err != nil {
+		slog.ErrorContext(ctx, "failed to record audit event", "action", event.Action, "error", err)
+	}
+}
+
+// RecordSystem appends an event for an operation performed by a service.
+func (l *Service) RecordSystem(ctx context.Context, action string, organization string, stack string, target string) {
+	l.Record(ctx, &model.AuditEventRecord{
+		ActorType:    ActorSystem,
+		Actor:        ActorSystem,
+		Action:       action,
+		Organization: organization,
+		Stack:        stack,
+		Target:       target,
+	})
+}
+
+type ListOptions struct {
+	Organization string
+	Start        time.Time
+	End          time.Time
+	Actor        string
+	Stack        string
+	// BeforeID continues a listing after the event with this ID
+	BeforeID uint
+	Limit    int
+}
+
+// List returns events newest first.
+func (l *Service) List(ctx context.Context, opts ListOptions) ([]model.AuditEventRecord, error) {
+	conditions := []store.DBOption{
+		store.Where(model.AuditEventRecord{
+			Organization: opts.Organization,
+			Actor:        opts.Actor,
+			Stack:        opts.Stack,
+		}),
+		store.OrderBy("id"),
+		store.Descending(),
+		store.Limit(opts.Limit),
+	}
+
+	if !opts.Start.IsZero() {
+		conditions = append(conditions, store.Where("timestamp >= ?", opts.Start))
+	}
+
+	if !opts.End.IsZero() {
+		conditions = append(conditions, store.Where("timestamp < ?", opts.End))
+	}
+
+	if opts.BeforeID > 0 {
+		conditions = append(conditions, store.Where("id < ?", opts.BeforeID))
+	}
+
+	events := []model.AuditEventRecord{}
+
+	if err := l.store.List(ctx, &events, conditions...); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
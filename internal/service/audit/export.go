@@ -0,0 +1,90 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/tinkerborg/open-pulumi-service/internal/model"
+)
+
+const (
+	FormatJSONLines = "jsonl"
+	FormatCEF       = "cef"
+)
+
+func ParseFormat(format string) (string, error) {
+	switch format {
+	case "", FormatJSONLines:
+		return FormatJSONLines, nil
+	case FormatCEF:
+		return FormatCEF, nil
+	}
+	return "", fmt.Errorf("invalid export format '%s'", format)
+}
+
+// Write writes events in an export format, one event per line.
+func Write(w io.Writer, format string, events []model.AuditEventRecord) error {
+	for _, event := range events {
+		var err error
+
+		switch format {
+		case FormatCEF:
+			_, err = fmt.Fprintln(w, formatCEF(&event))
+		default:
+			err = json.NewEncoder(w).Encode(event)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func formatCEF(event *model.AuditEventRecord) string {
+	severity := 3
+	if event.Outcome != model.AuditOutcomeSuccess {
+		severity = 6
+	}
+
+	extensions := []string{
+		"rt=" + fmt.Sprint(event.Timestamp.UnixMilli()),
+		"suser=" + cefValue(event.Actor),
+		"cs1Label=actorType",
+		"cs1=" + cefValue(event.ActorType),
+		"outcome=" + cefValue(event.Outcome),
+	}
+
+	optional := []struct{ key, value string }{
+		{"src", event.SourceIP},
+		{"cs2Label=organization cs2", event.Organization},
+		{"cs3Label=stack cs3", event.Stack},
+		{"cs4Label=tokenId cs4", event.TokenID},
+		{"msg", event.Target},
+	}
+
+	for _, extension := range optional {
+		if extension.value != "" {
+			extensions = append(extensions, extension.key+"="+cefValue(extension.value))
+		}
+	}
+
+	return fmt.Sprintf("CEF:0|open-pulumi-service|open-pulumi-service|1|%s|%s|%d|%s",
+		cefHeader(event.Action), cefHeader(event.Action), severity, strings.Join(extensions, " "))
+}
+
+var (
+	cefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ")
+	cefValueEscaper  = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+)
+
+func cefHeader(value string) string {
+	return cefHeaderEscaper.Replace(value)
+}
+
+func cefValue(value string) string {
+	return cefValueEscaper.Replace(value)
+}
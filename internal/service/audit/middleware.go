@@ -0,0 +1,139 @@
+package audit
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"github.com/tinkerborg/open-pulumi-service/internal/model"
+	"github.com/tinkerborg/open-pulumi-service/pkg/router"
+)
+
+type entryKey struct{}
+
+// Target is what an audited request acts on.
+type Target struct {
+	Organization string
+	// Stack is project/stack within the organization
+	Stack string
+	Name  string
+}
+
+// Actor is who an audited request acts on behalf of.
+type Actor struct {
+	Type    string
+	ID      string
+	Name    string
+	TokenID string
+}
+
+type entry struct {
+	action string
+	target Target
+	actor  Actor
+}
+
+// Action marks a route as audited. The target is resolved before the handler runs, so it may read
+// path values and values set by prefix middleware.
+func Action(action string, target func(r *http.Request) Target) router.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			e := &entry{action: action}
+			if target != nil {
+				e.target = target(r)
+			}
+
+			ctx := context.WithValue(r.Context(), entryKey{}, e)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// OrganizationTarget targets the organization in the "org" path value.
+func OrganizationTarget(name string) func(r *http.Request) Target {
+	return func(r *http.Request) Target {
+		target := Target{Organization: r.PathValue("org")}
+		if name != "" {
+			target.Name = r.PathValue(name)
+		}
+		return target
+	}
+}
+
+// Audited reports whether the request is to a route marked with Action.
+func Audited(r *http.Request) bool {
+	_, ok := r.Context().Value(entryKey{}).(*entry)
+	return ok
+}
+
+// SetActor records who an audited request acts on behalf of, once the request is authenticated.
+func SetActor(r *http.Request, actor Actor) {
+	if e, ok := r.Context().Value(entryKey{}).(*entry); ok {
+		e.actor = actor
+	}
+}
+
+// SetAction replaces the action recorded for an audited request, for routes that serve more than
+// one action.
+func SetAction(r *http.Request, action string) {
+	if e, ok := r.Context().Value(entryKey{}).(*entry); ok {
+		e.action = action
+	}
+}
+
+// SetTarget records what an audited request acts on, for targets only known once the handler runs.
+func SetTarget(r *http.Request, target Target) {
+	if e, ok := r.Context().Value(entryKey{}).(*entry); ok {
+		e.target = target
+	}
+}
+
+// Middleware records an event for every request to a route marked with Action, including requests
+// rejected before reaching the handler.
+func (l *Service) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e, ok := r.Context().Value(entryKey{}).(*entry)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		recorder := router.NewStatusRecorder(w)
+
+		next.ServeHTTP(recorder, r)
+
+		l.Record(r.Context(), &model.AuditEventRecord{
+			ActorType:    e.actor.Type,
+			ActorID:      e.actor.ID,
+			Actor:        e.actor.Name,
+			TokenID:      e.actor.TokenID,
+			Action:       e.action,
+			Organization: e.target.Organization,
+			Stack:        e.target.Stack,
+			Target:       e.target.Name,
+			SourceIP:     SourceIP(r),
+			Outcome:      outcome(recorder.StatusCode()),
+			Status:       recorder.StatusCode(),
+		})
+	})
+}
+
+// SourceIP returns the address of the client. Forwarding headers are only used by
+// middleware.TrustedProxies, for requests from a trusted proxy, since any client can set them.
+func SourceIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func outcome(statusCode int) string {
+	switch {
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		return model.AuditOutcomeDenied
+	case statusCode >= http.StatusBadRequest:
+		return model.AuditOutcomeFailure
+	}
+	return model.AuditOutcomeSuccess
+}
@@ -0,0 +1,52 @@
+package audit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAudited(t *testing.T) {
+	tests := []struct {
+		name    string
+		audited bool
+	}{
+		{name: "route marked with Action", audited: true},
+		{name: "route without Action"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var audited bool
+
+			var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				audited = Audited(r)
+			})
+
+			if test.audited {
+				handler = Action("test.action", nil)(handler)
+			}
+
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if audited != test.audited {
+				t.Errorf("got %t, want %t", audited, test.audited)
+			}
+		})
+	}
+}
+
+func TestSetAction(t *testing.T) {
+	var e *entry
+
+	handler := Action("user.login", nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetAction(r, "user.identity.link")
+		e, _ = r.Context().Value(entryKey{}).(*entry)
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if e == nil || e.action != "user.identity.link" {
+		t.Errorf("got entry %+v, want action user.identity.link", e)
+	}
+}
@@ -2,52 +2,55 @@ package auth
 
 import (
 	"crypto/rand"
-	"crypto/rsa"
-	"errors"
+	"time"
 
 	"github.com/tinkerborg/open-pulumi-service/internal/model"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/audit"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/crypto"
 	"github.com/tinkerborg/open-pulumi-service/internal/store"
+	"github.com/tinkerborg/open-pulumi-service/internal/util"
 )
 
 type Service struct {
-	store *store.Postgres
-	key   *rsa.PrivateKey
+	store         *store.Postgres
+	keys          *keyRing
+	keySets       *keySets
+	sessionSecret []byte
+	audit         *audit.Service
 }
 
-func New(store *store.Postgres) (*Service, error) {
-	store.RegisterModels(model.AuthToken{}, model.RSAKey{})
+type Options struct {
+	// SessionSecret signs login state. A random secret is used when empty, which invalidates logins
+	// in progress on restart.
+	SessionSecret []byte
+	// KeyRotation is how often a new token signing key is created, zero never rotates
+	KeyRotation time.Duration
+	// KeyRetention is how long tokens signed by a key are accepted after it is rotated out, which
+	// also applies to tokens without an expiry
+	KeyRetention time.Duration
+	// Audit records issued tokens and key rotations, nil records nothing
+	Audit *audit.Service
+}
 
-	key, err := getRSAKey(store)
+func New(store *store.Postgres, c crypto.Service, opts ...Options) (*Service, error) {
+	o, err := util.Merge(Options{}, opts)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Service{store, key}, nil
-}
-
-const keyName = "auth-root"
-
-func getRSAKey(s *store.Postgres) (*rsa.PrivateKey, error) {
-	rsaKey := &model.RSAKey{Name: keyName}
+	store.RegisterModels(model.AuthToken{}, model.RSAKey{}, model.SigningKeyRecord{}, model.LoginStateRecord{})
 
-	err := s.Read(rsaKey)
-
-	if errors.Is(err, store.ErrNotFound) {
-		privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
-		if err != nil {
-			return nil, err
-		}
-
-		rsaKey.Value = privateKey
+	keys, err := newKeyRing(store, c, o.Audit, o.KeyRotation, o.KeyRetention)
+	if err != nil {
+		return nil, err
+	}
 
-		if err := s.Create(rsaKey); err != nil {
+	if len(o.SessionSecret) == 0 {
+		o.SessionSecret = make([]byte, 32)
+		if _, err := rand.Read(o.SessionSecret); err != nil {
 			return nil, err
 		}
-	} else if err != nil {
-		return nil, err
 	}
 
-	rsaKey.Value.Precompute()
-
-	return rsaKey.Value, nil
+	return &Service{store, keys, newKeySets(), o.SessionSecret, o.Audit}, nil
 }
@@ -0,0 +1,183 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/tinkerborg/open-pulumi-service/internal/errs"
+	"github.com/tinkerborg/open-pulumi-service/internal/util"
+	"golang.org/x/sync/singleflight"
+)
+
+// keySetTTL is how long an issuer's signing keys are cached before being fetched again. Unknown key
+// IDs refetch sooner so key rotation at the issuer is picked up.
+const (
+	keySetTTL        = time.Hour
+	keySetMinRefresh = time.Minute
+)
+
+// ExternalClaims are the claims of a token issued by a trusted external OIDC issuer.
+type ExternalClaims = jwt.MapClaims
+
+type keySet struct {
+	keys      jose.JSONWebKeySet
+	fetchedAt time.Time
+}
+
+// keySets caches the signing keys of external issuers by issuer URL. Keys are fetched without
+// holding the lock, so a slow issuer doesn't hold up tokens from the others, and concurrent fetches
+// for one issuer share a single request.
+type keySets struct {
+	mutex    sync.Mutex
+	sets     map[string]*keySet
+	fetches  singleflight.Group
+	fetchSet func(ctx context.Context, issuer string) (*keySet, error)
+}
+
+func newKeySets() *keySets {
+	// issuers are configured by organization admins, so their keys are only fetched from public
+	// addresses
+	client := util.NewRestrictedClient(10*time.Second, util.PublicAddress)
+
+	return &keySets{sets: map[string]*keySet{}, fetchSet: func(ctx context.Context, issuer string) (*keySet, error) {
+		return fetchKeySet(ctx, client, issuer)
+	}}
+}
+
+// UnverifiedIssuer returns the issuer a token claims to come from, without verifying it, so the
+// caller can decide whether the issuer is trusted at all.
+func UnverifiedIssuer(token string) (string, error) {
+	claims := jwt.MapClaims{}
+
+	if _, _, err := jwt.NewParser().ParseUnverified(token, claims); err != nil {
+		return "", errs.Errorf(errs.ErrForbidden, "invalid token: %s", err)
+	}
+
+	return claims.GetIssuer()
+}
+
+// VerifyExternalToken verifies a token issued by an external OIDC issuer against the issuer's
+// published keys and checks that it is meant for the audience.
+func (s *Service) VerifyExternalToken(ctx context.Context, issuer string, token string, audience string) (ExternalClaims, error) {
+	claims := jwt.MapClaims{}
+
+	parsed, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		keyID, _ := t.Header["kid"].(string)
+		return s.keySets.key(ctx, issuer, keyID)
+	},
+		jwt.WithIssuer(issuer),
+		jwt.WithAudience(audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}),
+	)
+	if err != nil {
+		return nil, tokenError(err)
+	}
+
+	if !parsed.Valid {
+		return nil, errs.New(errs.ErrForbidden, "invalid token")
+	}
+
+	return claims, nil
+}
+
+func (k *keySets) key(ctx context.Context, issuer string, keyID string) (interface{}, error) {
+	set, stale := k.cached(issuer, keyID)
+
+	if stale {
+		fetched, err, _ := k.fetches.Do(issuer, func() (any, error) {
+			// the fetch is shared, so one caller giving up mustn't fail it for the others
+			fetched, err := k.fetchSet(context.WithoutCancel(ctx), issuer)
+			if err != nil {
+				return nil, err
+			}
+
+			k.mutex.Lock()
+			k.sets[issuer] = fetched
+			k.mutex.Unlock()
+
+			return fetched, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		set = fetched.(*keySet)
+	}
+
+	for _, key := range set.keys.Keys {
+		if keyID == "" || key.KeyID == keyID {
+			return key.Key, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown signing key '%s'", keyID)
+}
+
+// cached returns the cached keys of an issuer, and whether they need fetching again.
+func (k *keySets) cached(issuer string, keyID string) (*keySet, bool) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	set, ok := k.sets[issuer]
+
+	stale := !ok || time.Since(set.fetchedAt) > keySetTTL
+	if ok && !stale && len(set.keys.Key(keyID)) == 0 {
+		stale = time.Since(set.fetchedAt) > keySetMinRefresh
+	}
+
+	return set, stale
+}
+
+func fetchKeySet(ctx context.Context, client *http.Client, issuer string) (*keySet, error) {
+	discovery := struct {
+		JWKSURI string `json:"jwks_uri"`
+	}{}
+
+	if err := getJSON(ctx, client, strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, err
+	}
+
+	if discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("issuer '%s' has no jwks_uri", issuer)
+	}
+
+	set := &keySet{fetchedAt: time.Now()}
+
+	if err := getJSON(ctx, client, discovery.JWKSURI, &set.keys); err != nil {
+		return nil, err
+	}
+
+	return set, nil
+}
+
+// getJSON fetches an https URL. Redirects aren't followed, so only a 200 response succeeds.
+func getJSON(ctx context.Context, client *http.Client, url string, value any) error {
+	if parsed, err := neturl.Parse(url); err != nil || parsed.Scheme != "https" {
+		return errs.Errorf(errs.ErrForbidden, "url '%s' is not https", url)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return errs.With(err, errs.ErrUnavailable)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return errs.Errorf(errs.ErrUnavailable, "request %s failed: %s", url, response.Status)
+	}
+
+	return json.NewDecoder(response.Body).Decode(value)
+}
@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/tinkerborg/open-pulumi-service/internal/util"
+)
+
+func TestKeySetsKey(t *testing.T) {
+	tests := []struct {
+		name      string
+		cached    *keySet
+		keyID     string
+		wantFetch bool
+		wantErr   bool
+	}{
+		{name: "not cached", keyID: "a", wantFetch: true},
+		{name: "cached", cached: testKeySet(time.Now(), "a"), keyID: "a"},
+		{name: "expired", cached: testKeySet(time.Now().Add(-2*keySetTTL), "a"), keyID: "a", wantFetch: true},
+		{name: "unknown key, fetched recently", cached: testKeySet(time.Now(), "b"), keyID: "a", wantErr: true},
+		{name: "unknown key, fetched a while ago", cached: testKeySet(time.Now().Add(-2*keySetMinRefresh), "b"), keyID: "a", wantFetch: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sets := newKeySets()
+			if test.cached != nil {
+				sets.sets["https://issuer"] = test.cached
+			}
+
+			fetched := false
+			sets.fetchSet = func(ctx context.Context, issuer string) (*keySet, error) {
+				fetched = true
+				return testKeySet(time.Now(), "a"), nil
+			}
+
+			_, err := sets.key(context.Background(), "https://issuer", test.keyID)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %t", err, test.wantErr)
+			}
+
+			if fetched != test.wantFetch {
+				t.Errorf("fetched %t, want %t", fetched, test.wantFetch)
+			}
+		})
+	}
+}
+
+func TestKeySetsFetchOutsideLock(t *testing.T) {
+	sets := newKeySets()
+	sets.sets["https://fast"] = testKeySet(time.Now(), "a")
+
+	release := make(chan struct{})
+	var fetches atomic.Int32
+
+	sets.fetchSet = func(ctx context.Context, issuer string) (*keySet, error) {
+		fetches.Add(1)
+		<-release
+		return testKeySet(time.Now(), "a"), nil
+	}
+
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := sets.key(context.Background(), "https://slow", "a"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	for fetches.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// a cached issuer is served while the slow one is being fetched
+	done := make(chan error)
+	go func() {
+		_, err := sets.key(context.Background(), "https://fast", "a")
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("cached issuer waited for another issuer's fetch")
+	}
+
+	close(release)
+	wg.Wait()
+
+	if got := fetches.Load(); got < 1 || got > 5 {
+		t.Errorf("got %d fetches", got)
+	}
+}
+
+func TestFetchKeySet(t *testing.T) {
+	var server *httptest.Server
+	jwksURI := ""
+
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			fmt.Fprintf(w, `{"jwks_uri": %q}`, jwksURI)
+		case "/jwks":
+			fmt.Fprint(w, `{"keys": []}`)
+		case "/moved":
+			http.Redirect(w, r, server.URL+"/jwks", http.StatusFound)
+		}
+	}))
+	defer server.Close()
+
+	// the client keys are fetched with, allowed to reach the test server
+	loopback := util.NewRestrictedClient(time.Second, func(netip.Addr) bool { return true })
+	loopback.Transport.(*http.Transport).TLSClientConfig = server.Client().Transport.(*http.Transport).TLSClientConfig
+
+	tests := []struct {
+		name    string
+		issuer  string
+		jwksURI string
+		client  *http.Client
+		wantErr bool
+	}{
+		{name: "fetches the keys", issuer: server.URL, jwksURI: server.URL + "/jwks", client: loopback},
+		{name: "http issuer", issuer: strings.Replace(server.URL, "https:", "http:", 1), jwksURI: server.URL + "/jwks", client: loopback, wantErr: true},
+		{name: "http keys", issuer: server.URL, jwksURI: strings.Replace(server.URL, "https:", "http:", 1) + "/jwks", client: loopback, wantErr: true},
+		{name: "redirect", issuer: server.URL, jwksURI: server.URL + "/moved", client: loopback, wantErr: true},
+		{name: "non-public address", issuer: server.URL, jwksURI: server.URL + "/jwks", client: util.NewRestrictedClient(time.Second, util.PublicAddress), wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			jwksURI = test.jwksURI
+
+			_, err := fetchKeySet(context.Background(), test.client, test.issuer)
+			if (err != nil) != test.wantErr {
+				t.Errorf("got error %v, want error %t", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func testKeySet(fetchedAt time.Time, keyIDs ...string) *keySet {
+	set := &keySet{fetchedAt: fetchedAt}
+	for _, keyID := range keyIDs {
+		set.keys.Keys = append(set.keys.Keys, jose.JSONWebKey{KeyID: keyID, Key: []byte("key")})
+	}
+	return set
+}
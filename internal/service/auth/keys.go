@@ -0,0 +1,374 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/tinkerborg/open-pulumi-service/internal/model"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/audit"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/crypto"
+	"github.com/tinkerborg/open-pulumi-service/internal/store"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	keyBits = 2048
+	// keyReloadInterval is how often keys are reloaded, to pick up rotations by other replicas
+	keyReloadInterval = time.Minute
+	// legacyKeyName is the key tokens without a key ID were signed with
+	legacyKeyName = "auth-root"
+	// keyRotationLock is the advisory lock replicas take to rotate, so only one creates a key
+	keyRotationLock = 0x6b657973
+)
+
+type signingKey struct {
+	id        string
+	key       *rsa.PrivateKey
+	createdAt time.Time
+	expiresAt time.Time
+}
+
+// keyRing holds the signing keys. The newest key signs tokens; older keys keep verifying tokens
+// until they expire. Keys are loaded without holding the lock, so tokens keep being verified with
+// the loaded keys while the database or KMS is slow, and concurrent loads share a single one.
+type keyRing struct {
+	store     *store.Postgres
+	crypto    crypto.Service
+	audit     *audit.Service
+	rotation  time.Duration
+	retention time.Duration
+
+	mutex    sync.Mutex
+	keys     []*signingKey
+	loadedAt time.Time
+	loads    singleflight.Group
+	loadKeys func(ctx context.Context, current []*signingKey) ([]*signingKey, error)
+}
+
+func newKeyRing(s *store.Postgres, c crypto.Service, a *audit.Service, rotation time.Duration, retention time.Duration) (*keyRing, error) {
+	k := &keyRing{store: s, crypto: c, audit: a, rotation: rotation, retention: retention}
+	k.loadKeys = k.load
+
+	ctx := context.Background()
+
+	if err := k.importLegacyKey(ctx); err != nil {
+		return nil, err
+	}
+
+	if _, err := k.reload(ctx); err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// current returns the key to sign new tokens with, rotating it when it is due.
+func (k *keyRing) current(ctx context.Context) (*signingKey, error) {
+	keys, loadedAt := k.loaded()
+
+	if time.Since(loadedAt) > keyReloadInterval {
+		reloaded, err := k.reload(ctx)
+		if err != nil {
+			return nil, err
+		}
+		keys = reloaded
+	}
+
+	return keys[0], nil
+}
+
+// verificationKey returns the key a token was signed with, if it is still accepted.
+func (k *keyRing) verificationKey(ctx context.Context, id string) (*rsa.PublicKey, error) {
+	if id == "" {
+		id = legacyKeyName
+	}
+
+	keys, loadedAt := k.loaded()
+
+	key := findKey(keys, id)
+	if key == nil && time.Since(loadedAt) > keyReloadInterval/6 {
+		reloaded, err := k.reload(ctx)
+		if err != nil {
+			return nil, err
+		}
+		key = findKey(reloaded, id)
+	}
+
+	if key == nil || time.Now().After(key.expiresAt) {
+		return nil, fmt.Errorf("unknown signing key '%s'", id)
+	}
+
+	return &key.key.PublicKey, nil
+}
+
+// jwks returns the public keys that tokens are currently accepted from.
+func (k *keyRing) jwks() jose.JSONWebKeySet {
+	keys, _ := k.loaded()
+
+	set := jose.JSONWebKeySet{Keys: []jose.JSONWebKey{}}
+
+	for _, key := range keys {
+		if time.Now().After(key.expiresAt) {
+			continue
+		}
+
+		set.Keys = append(set.Keys, jose.JSONWebKey{
+			Key:       &key.key.PublicKey,
+			KeyID:     key.id,
+			Algorithm: "RS256",
+			Use:       "sig",
+		})
+	}
+
+	return set
+}
+
+// loaded returns the loaded keys and when they were loaded. The slice is replaced rather than
+// modified on reload, so callers can use it without the lock.
+func (k *keyRing) loaded() ([]*signingKey, time.Time) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	return k.keys, k.loadedAt
+}
+
+// reload loads the keys and swaps them in.
+func (k *keyRing) reload(ctx context.Context) ([]*signingKey, error) {
+	keys, err, _ := k.loads.Do("", func() (any, error) {
+		current, _ := k.loaded()
+
+		// the load is shared, so one caller giving up mustn't fail it for the others
+		keys, err := k.loadKeys(context.WithoutCancel(ctx), current)
+		if err != nil {
+			return nil, err
+		}
+
+		k.mutex.Lock()
+		k.keys = keys
+		k.loadedAt = time.Now()
+		k.mutex.Unlock()
+
+		return keys, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return keys.([]*signingKey), nil
+}
+
+func findKey(keys []*signingKey, id string) *signingKey {
+	for _, key := range keys {
+		if key.id == id {
+			return key
+		}
+	}
+	return nil
+}
+
+// load reads the keys that are still accepted, newest first, rotating if the newest is due. Keys
+// that are already loaded are reused rather than decrypted again.
+func (k *keyRing) load(ctx context.Context, current []*signingKey) ([]*signingKey, error) {
+	records, err := k.list(ctx, k.store)
+	if err != nil {
+		return nil, err
+	}
+
+	if k.due(records) {
+		if err := k.rotate(ctx); err != nil {
+			return nil, err
+		}
+
+		if records, err = k.list(ctx, k.store); err != nil {
+			return nil, err
+		}
+	}
+
+	keys := []*signingKey{}
+
+	for _, record := range records {
+		if existing := findKey(current, record.ID); existing != nil {
+			keys = append(keys, existing)
+			continue
+		}
+
+		key, err := k.decrypt(ctx, &record)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func (k *keyRing) list(ctx context.Context, s *store.Postgres) ([]model.SigningKeyRecord, error) {
+	records := []model.SigningKeyRecord{}
+
+	err := s.List(ctx, &records,
+		store.Where("expires_at > ?", time.Now()),
+		store.OrderBy("created_at"),
+		store.Descending(),
+	)
+
+	return records, err
+}
+
+// due reports whether a new key is needed, given the accepted keys newest first.
+func (k *keyRing) due(records []model.SigningKeyRecord) bool {
+	return len(records) == 0 || (k.rotation > 0 && time.Since(records[0].CreatedAt) > k.rotation)
+}
+
+// rotate creates a new signing key. Replicas that find rotation due at the same time take turns
+// with an advisory lock, and those that get it after another has rotated find the key is no
+// longer due.
+//
+// Tokens without an expiry stop being accepted once the key that signed them expires, between
+// KeyRetention and KeyRotation+KeyRetention after they were issued, so each rotation is audited.
+func (k *keyRing) rotate(ctx context.Context) error {
+	var created *model.SigningKeyRecord
+
+	err := k.store.Transaction(ctx, func(s *store.Postgres) error {
+		if err := s.Raw(ctx, &[]bool{}, "SELECT true FROM pg_advisory_xact_lock(?)", keyRotationLock); err != nil {
+			return err
+		}
+
+		records, err := k.list(ctx, s)
+		if err != nil {
+			return err
+		}
+
+		if !k.due(records) {
+			return nil
+		}
+
+		created, err = k.create(ctx, s)
+		return err
+	})
+	if err != nil || created == nil {
+		return err
+	}
+
+	slog.InfoContext(ctx, "rotated token signing key", "key", created.ID, "expires", created.ExpiresAt)
+	k.audit.RecordSystem(ctx, "token.signing-key.rotated", "", "", "signing-key:"+created.ID)
+
+	return nil
+}
+
+func (k *keyRing) create(ctx context.Context, s *store.Postgres) (*model.SigningKeyRecord, error) {
+	key, err := rsa.GenerateKey(rand.Reader, keyBits)
+	if err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	record, err := k.encrypt(ctx, hex.EncodeToString(id), key, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.Create(ctx, record); err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+func (k *keyRing) encrypt(ctx context.Context, id string, key *rsa.PrivateKey, createdAt time.Time) (*model.SigningKeyRecord, error) {
+	privateKey, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedKey, err := k.crypto.Encrypt(ctx, privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("can't encrypt signing key: %w", err)
+	}
+
+	return &model.SigningKeyRecord{
+		ID:           id,
+		EncryptedKey: encryptedKey,
+		PublicKey:    publicKey,
+		CreatedAt:    createdAt,
+		ExpiresAt:    createdAt.Add(k.lifetime()),
+	}, nil
+}
+
+func (k *keyRing) decrypt(ctx context.Context, record *model.SigningKeyRecord) (*signingKey, error) {
+	privateKey, err := k.crypto.Decrypt(ctx, record.EncryptedKey)
+	if err != nil {
+		return nil, fmt.Errorf("can't decrypt signing key '%s': %w", record.ID, err)
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key '%s' is not an RSA key", record.ID)
+	}
+
+	key.Precompute()
+
+	return &signingKey{
+		id:        record.ID,
+		key:       key,
+		createdAt: record.CreatedAt,
+		expiresAt: record.ExpiresAt,
+	}, nil
+}
+
+// lifetime is how long a key is accepted: while it is current, and for the retention period after.
+// Without rotation a key is current forever.
+func (k *keyRing) lifetime() time.Duration {
+	if k.rotation <= 0 {
+		return 100 * 365 * 24 * time.Hour
+	}
+	return k.rotation + k.retention
+}
+
+// importLegacyKey encrypts the unencrypted key from before key rotation, so tokens signed with it
+// stay valid, and removes the plaintext copy.
+func (k *keyRing) importLegacyKey(ctx context.Context) error {
+	legacy := &model.RSAKey{Name: legacyKeyName}
+
+	if err := k.store.Read(ctx, legacy); errors.Is(err, store.ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	record, err := k.encrypt(ctx, legacyKeyName, legacy.Value, time.Now())
+	if err != nil {
+		return err
+	}
+
+	return k.store.Transaction(ctx, func(s *store.Postgres) error {
+		if err := s.CreateIgnoreExisting(ctx, record); err != nil {
+			return err
+		}
+
+		return s.Delete(ctx, legacy)
+	})
+}
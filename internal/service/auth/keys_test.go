@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tinkerborg/open-pulumi-service/internal/model"
+	"github.com/tinkerborg/open-pulumi-service/internal/store"
+)
+
+func TestKeyRingLoadOutsideLock(t *testing.T) {
+	key := testSigningKey(t, "a")
+
+	ring := &keyRing{keys: []*signingKey{key}, loadedAt: time.Now().Add(-2 * keyReloadInterval)}
+
+	release := make(chan struct{})
+	var loads atomic.Int32
+
+	ring.loadKeys = func(ctx context.Context, current []*signingKey) ([]*signingKey, error) {
+		loads.Add(1)
+		<-release
+		return current, nil
+	}
+
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := ring.current(context.Background()); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	for loads.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// tokens signed with a loaded key are verified while the keys are being reloaded
+	done := make(chan error)
+	go func() {
+		_, err := ring.verificationKey(context.Background(), "a")
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("verification waited for the keys to reload")
+	}
+
+	close(release)
+	wg.Wait()
+
+	if got := loads.Load(); got < 1 || got > 5 {
+		t.Errorf("got %d loads", got)
+	}
+}
+
+func TestKeyRingRotatesOnce(t *testing.T) {
+	p := store.NewTestPostgres(t)
+	p.RegisterModels(model.RSAKey{}, model.SigningKeyRecord{})
+	ctx := context.Background()
+
+	first, err := newKeyRing(p, plaintextCrypto{}, nil, time.Hour, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Raw(ctx, &[]model.SigningKeyRecord{}, "UPDATE signing_key_record SET created_at = ? RETURNING *", time.Now().Add(-2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	// replicas finding rotation due at the same time create a single key between them
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := newKeyRing(p, plaintextCrypto{}, nil, time.Hour, time.Hour); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	records := []model.SigningKeyRecord{}
+	if err := p.List(ctx, &records); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != 2 {
+		t.Fatalf("got %d keys, want 2", len(records))
+	}
+
+	// the rotated out key still verifies tokens
+	if _, err := first.verificationKey(ctx, first.keys[0].id); err != nil {
+		t.Error(err)
+	}
+}
+
+func testSigningKey(t *testing.T, id string) *signingKey {
+	key, err := rsa.GenerateKey(rand.Reader, keyBits)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &signingKey{id: id, key: key, createdAt: time.Now(), expiresAt: time.Now().Add(time.Hour)}
+}
+
+// plaintextCrypto "encrypts" to the plaintext itself.
+type plaintextCrypto struct{}
+
+func (plaintextCrypto) Encrypt(ctx context.Context, plaintext []byte) ([]byte, error) {
+	return plaintext, nil
+}
+
+func (plaintextCrypto) Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	return ciphertext, nil
+}
@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/tinkerborg/open-pulumi-service/internal/errs"
+	"github.com/tinkerborg/open-pulumi-service/internal/model"
+	"github.com/tinkerborg/open-pulumi-service/internal/store"
+	"golang.org/x/oauth2"
+)
+
+const loginStateTTL = 10 * time.Minute
+
+var ErrInvalidLoginState = errs.New(errs.ErrInvalidArgument, "invalid or expired login state")
+
+// CreateLoginState persists a login in progress, with a PKCE verifier for providers that use PKCE, and returns the state parameter to send to the
+// login provider, signed with the session secret.
+func (s *Service) CreateLoginState(ctx context.Context, login *model.LoginStateRecord) (string, error) {
+	id := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
+	login.ID = base64.RawURLEncoding.EncodeToString(id)
+	login.Verifier = oauth2.GenerateVerifier()
+	login.ExpiresAt = time.Now().Add(loginStateTTL)
+
+	if err := s.store.Create(ctx, login); err != nil {
+		return "", err
+	}
+
+	return login.ID + "." + s.signLoginState(login.ID), nil
+}
+
+// consumeLoginState deletes a login state and returns it in one statement, so that of two requests
+// racing with the same state only one gets it back.
+const consumeLoginState = `DELETE FROM login_state_record WHERE id = ? RETURNING *`
+
+// ConsumeLoginState returns the login a state parameter belongs to and removes it, so the state
+// can't be replayed. Expired states are cleaned up along the way.
+func (s *Service) ConsumeLoginState(ctx context.Context, provider string, state string) (*model.LoginStateRecord, error) {
+	id, ok := s.verifyLoginState(state)
+	if !ok {
+		return nil, ErrInvalidLoginState
+	}
+
+	if err := s.store.Delete(ctx, &model.LoginStateRecord{}, store.Where("expires_at < ?", time.Now())); err != nil {
+		return nil, err
+	}
+
+	logins := []model.LoginStateRecord{}
+	if err := s.store.Raw(ctx, &logins, consumeLoginState, id); err != nil {
+		return nil, err
+	}
+
+	if len(logins) == 0 {
+		return nil, ErrInvalidLoginState
+	}
+
+	login := &logins[0]
+
+	if login.Provider != provider || time.Now().After(login.ExpiresAt) {
+		return nil, ErrInvalidLoginState
+	}
+
+	return login, nil
+}
+
+// ReadLoginState returns the login a state parameter belongs to without consuming it, for a login
+// started outside the browser, such as linking an identity through the API.
+func (s *Service) ReadLoginState(ctx context.Context, provider string, state string) (*model.LoginStateRecord, error) {
+	id, ok := s.verifyLoginState(state)
+	if !ok {
+		return nil, ErrInvalidLoginState
+	}
+
+	login := &model.LoginStateRecord{ID: id}
+
+	if err := s.store.Read(ctx, login); errors.Is(err, store.ErrNotFound) {
+		return nil, ErrInvalidLoginState
+	} else if err != nil {
+		return nil, err
+	}
+
+	if login.Provider != provider || time.Now().After(login.ExpiresAt) {
+		return nil, ErrInvalidLoginState
+	}
+
+	return login, nil
+}
+
+// LoginStateID returns the ID part of a state parameter, which browsers are bound to by cookie.
+func LoginStateID(state string) string {
+	id, _, _ := strings.Cut(state, ".")
+	return id
+}
+
+// verifyLoginState returns the ID of a state parameter if it carries the ID's signature.
+func (s *Service) verifyLoginState(state string) (string, bool) {
+	id, signature, ok := strings.Cut(state, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(s.signLoginState(id))) {
+		return "", false
+	}
+	return id, true
+}
+
+func (s *Service) signLoginState(id string) string {
+	mac := hmac.New(sha256.New, s.sessionSecret)
+	mac.Write([]byte(id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
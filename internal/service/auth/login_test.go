@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tinkerborg/open-pulumi-service/internal/model"
+	"github.com/tinkerborg/open-pulumi-service/internal/store"
+)
+
+func TestVerifyLoginState(t *testing.T) {
+	s := &Service{sessionSecret: []byte("secret")}
+	other := &Service{sessionSecret: []byte("other")}
+
+	tests := []struct {
+		name  string
+		state string
+		ok    bool
+	}{
+		{name: "signed", state: "abc." + s.signLoginState("abc"), ok: true},
+		{name: "signed with another secret", state: "abc." + other.signLoginState("abc")},
+		{name: "signature of another ID", state: "abd." + s.signLoginState("abc")},
+		{name: "unsigned", state: "abc"},
+		{name: "empty", state: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			id, ok := s.verifyLoginState(test.state)
+			if ok != test.ok {
+				t.Fatalf("got %t, want %t", ok, test.ok)
+			}
+
+			if ok && id != LoginStateID(test.state) {
+				t.Errorf("got ID %s, want %s", id, LoginStateID(test.state))
+			}
+		})
+	}
+}
+
+func TestConsumeLoginState(t *testing.T) {
+	p := store.NewTestPostgres(t)
+	p.RegisterModels(model.LoginStateRecord{})
+
+	s := &Service{store: p, sessionSecret: []byte("secret")}
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		provider string
+		expired  bool
+		wantErr  bool
+	}{
+		{name: "valid", provider: "github"},
+		{name: "other provider", provider: "gitlab", wantErr: true},
+		{name: "expired", provider: "github", expired: true, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			login := &model.LoginStateRecord{Provider: "github", Port: "1234", Nonce: "nonce"}
+
+			state, err := s.CreateLoginState(ctx, login)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if test.expired {
+				login.ExpiresAt = time.Now().Add(-time.Second)
+				if err := p.Update(ctx, login); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := s.ConsumeLoginState(ctx, test.provider, state)
+			if test.wantErr {
+				if !errors.Is(err, ErrInvalidLoginState) {
+					t.Fatalf("got error %v, want %v", err, ErrInvalidLoginState)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if got.Port != login.Port || got.Nonce != login.Nonce || got.Verifier == "" {
+				t.Errorf("got %+v, want %+v", got, login)
+			}
+
+			if _, err := s.ConsumeLoginState(ctx, test.provider, state); !errors.Is(err, ErrInvalidLoginState) {
+				t.Errorf("consumed twice, got error %v", err)
+			}
+		})
+	}
+}
+
+func TestConsumeLoginStateOnce(t *testing.T) {
+	p := store.NewTestPostgres(t)
+	p.RegisterModels(model.LoginStateRecord{})
+
+	s := &Service{store: p, sessionSecret: []byte("secret")}
+	ctx := context.Background()
+
+	state, err := s.CreateLoginState(ctx, &model.LoginStateRecord{Provider: "github"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var consumed atomic.Int32
+	var wg sync.WaitGroup
+
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.ConsumeLoginState(ctx, "github", state); err == nil {
+				consumed.Add(1)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if got := consumed.Load(); got != 1 {
+		t.Errorf("state consumed %d times", got)
+	}
+}
@@ -3,12 +3,17 @@ package auth
 import (
 	"context"
 	"errors"
+	"log/slog"
 	"net/http"
 	"strings"
 
+	"github.com/tinkerborg/open-pulumi-service/internal/errs"
 	"github.com/tinkerborg/open-pulumi-service/pkg/router"
+	"github.com/tinkerborg/open-pulumi-service/pkg/router/middleware"
 )
 
+var errUnauthorized = errors.New("Unauthorized")
+
 type claimsKey struct{}
 type tokenTypeKey struct{}
 
@@ -17,20 +22,23 @@ func (s *Service) Middleware(next http.Handler) http.Handler {
 		header := strings.Split(r.Header.Get("Authorization"), " ")
 
 		if len(header) < 2 {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			router.WriteError(w, r, http.StatusUnauthorized, errUnauthorized)
 			return
 		}
 
 		token := header[1]
 
 		if token == "" {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			router.WriteError(w, r, http.StatusUnauthorized, errUnauthorized)
 			return
 		}
 
-		claims, err := s.GetUserClaims(token)
-		if err != nil {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		claims, err := s.GetUserClaims(r.Context(), token)
+		if errors.Is(err, errs.ErrUnavailable) {
+			router.WriteError(w, r, router.ErrorStatus(err), err)
+			return
+		} else if err != nil {
+			router.WriteError(w, r, http.StatusUnauthorized, errUnauthorized)
 			return
 		}
 
@@ -38,15 +46,17 @@ func (s *Service) Middleware(next http.Handler) http.Handler {
 
 		if handler != nil {
 			if handler.tokenType != claims.Type {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				router.WriteError(w, r, http.StatusUnauthorized, errUnauthorized)
 				return
 			}
 			if !handler.checkClaims(r, claims) {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				router.WriteError(w, r, http.StatusUnauthorized, errUnauthorized)
 				return
 			}
 		}
 
+		middleware.AddLogAttrs(r.Context(), logAttrs(claims)...)
+
 		ctx := context.WithValue(r.Context(), claimsKey{}, claims)
 
 		next.ServeHTTP(w, r.WithContext(ctx))
@@ -80,6 +90,21 @@ func (s *Service) GetRequestClaims(r *http.Request) (*UserClaims, error) {
 	return value.(*UserClaims), nil
 }
 
+// logAttrs identify the caller in log lines by the user, team or organization its token is for.
+// Update tokens are left out, as their routes log the update.
+func logAttrs(claims *UserClaims) []slog.Attr {
+	switch claims.Type {
+	case UpdateToken:
+		return nil
+	case TeamToken:
+		return []slog.Attr{slog.String("team_id", claims.ID)}
+	case OrganizationToken:
+		return []slog.Attr{slog.String("organization_id", claims.ID)}
+	default:
+		return []slog.Attr{slog.String("user_id", claims.ID)}
+	}
+}
+
 func (s *Service) getTokenTypeHandler(r *http.Request) *tokenTypeHandler {
 	value := r.Context().Value(tokenTypeKey{})
 	if value == nil {
@@ -1,35 +1,77 @@
 package auth
 
 import (
+	"context"
+	"crypto/rand"
 	"errors"
 	"fmt"
 	"time"
 
+	"github.com/go-jose/go-jose/v3"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/tinkerborg/open-pulumi-service/internal/errs"
 	"github.com/tinkerborg/open-pulumi-service/internal/model"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/audit"
+	"github.com/tinkerborg/open-pulumi-service/internal/store"
+	"github.com/tinkerborg/open-pulumi-service/internal/util"
 )
 
 // TODO token expiry
 
+const (
+	UserToken   = "token"
+	UpdateToken = "update-token"
+	// TeamToken claims carry a team ID instead of a user ID
+	TeamToken = "team-token"
+	// OrganizationToken claims carry an organization ID instead of a user ID
+	OrganizationToken = "org-token"
+)
+
 type UserClaims struct {
 	ID   string `json:"id"`
 	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
 	jwt.RegisteredClaims
 }
 
-// TODO - options array for token type, expiry etc
-func (s *Service) CreateToken(id string, tokenType string) (string, error) {
+type TokenOptions struct {
+	// Name is reported back to the CLI as the token's name
+	Name string
+	// Expiration limits how long the token is valid, zero never expires
+	Expiration time.Duration
+}
+
+// TODO - expiry
+func (s *Service) CreateToken(ctx context.Context, id string, tokenType string, opts ...TokenOptions) (string, error) {
+	o, err := util.Merge(TokenOptions{}, opts)
+	if err != nil {
+		return "", err
+	}
+
 	claims := &UserClaims{
 		ID:   id,
 		Type: tokenType,
+		Name: o.Name,
 		RegisteredClaims: jwt.RegisteredClaims{
-			// TODO
-			// ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour * 24)),
+			// the token ID attributes audit events to the token used
+			ID:       rand.Text(),
 			IssuedAt: jwt.NewNumericDate(time.Now()),
 		},
 	}
 
-	value, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(s.key)
+	if o.Expiration > 0 {
+		claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(o.Expiration))
+	}
+
+	key, err := s.keys.current(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	signed := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	signed.Header["kid"] = key.id
+
+	value, err := signed.SignedString(key.key)
 	if err != nil {
 		return "", err
 	}
@@ -37,30 +79,101 @@ func (s *Service) CreateToken(id string, tokenType string) (string, error) {
 	token := &model.AuthToken{
 		UserID: id,
 		Value:  value,
+		Name:   o.Name,
 	}
 
-	if err := s.store.Create(token); err != nil {
+	if err := s.store.Create(ctx, token); err != nil {
 		return "", err
 	}
 
+	// update tokens are issued for every update and are covered by the update's own events
+	if tokenType != UpdateToken {
+		s.audit.Record(ctx, &model.AuditEventRecord{
+			ActorType: audit.ActorSystem,
+			Actor:     audit.ActorSystem,
+			TokenID:   claims.RegisteredClaims.ID,
+			Action:    "token.issued",
+			Target:    tokenType + ":" + id,
+		})
+	}
+
 	return value, nil
 }
 
-func (s *Service) GetUserClaims(token string) (*UserClaims, error) {
+func (s *Service) GetUserClaims(ctx context.Context, token string) (*UserClaims, error) {
 	claims := UserClaims{}
 
-	parsed, err := jwt.ParseWithClaims(token, &claims, s.publicKey)
+	parsed, err := jwt.ParseWithClaims(token, &claims, func(token *jwt.Token) (interface{}, error) {
+		keyID, _ := token.Header["kid"].(string)
+		return s.keys.verificationKey(ctx, keyID)
+	}, jwt.WithValidMethods([]string{"RS256"}))
 	if err != nil {
-		return nil, fmt.Errorf("invalid token: %s", err)
+		return nil, tokenError(err)
 	}
 
 	if !parsed.Valid {
-		return nil, errors.New("invalid token")
+		return nil, errs.New(errs.ErrForbidden, "invalid token")
+	}
+
+	// update tokens live only as long as their update and are never revoked
+	if claims.Type != UpdateToken {
+		count, err := s.store.Count(ctx, model.AuthToken{Value: token})
+		if err != nil {
+			return nil, fmt.Errorf("can't verify token: %w", err)
+		}
+
+		if count == 0 {
+			return nil, errs.New(errs.ErrForbidden, "token has been revoked")
+		}
 	}
 
 	return &claims, nil
 }
 
-func (s *Service) publicKey(token *jwt.Token) (interface{}, error) {
-	return s.key.Public(), nil
+// ListTokens returns the tokens issued for a user, team or organization, oldest first.
+func (s *Service) ListTokens(ctx context.Context, id string) ([]model.AuthToken, error) {
+	tokens := []model.AuthToken{}
+
+	if err := s.store.List(ctx, &tokens, store.Where("user_id = ?", id), store.OrderBy("id")); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// RevokeToken revokes one of the tokens issued for a user, team or organization.
+func (s *Service) RevokeToken(ctx context.Context, id string, tokenID int) error {
+	return s.store.Transaction(ctx, func(p *store.Postgres) error {
+		count, err := p.Count(ctx, model.AuthToken{ID: tokenID, UserID: id})
+		if err != nil {
+			return err
+		}
+
+		if count == 0 {
+			return errs.Errorf(errs.ErrNotFound, "token %d not found", tokenID)
+		}
+
+		return p.Delete(ctx, &model.AuthToken{ID: tokenID})
+	})
+}
+
+// RevokeTokens revokes every token issued for a user, team or organization, such as when it is
+// deleted.
+func (s *Service) RevokeTokens(ctx context.Context, id string) error {
+	return s.store.Delete(ctx, &model.AuthToken{}, store.Where("user_id = ?", id))
+}
+
+// JWKS returns the public keys tokens issued by the service can be verified with.
+func (s *Service) JWKS() jose.JSONWebKeySet {
+	return s.keys.jwks()
+}
+
+// tokenError reports a token that failed to verify as forbidden, unless verification failed
+// because its keys couldn't be loaded, which is no fault of the token.
+func tokenError(err error) error {
+	if errors.Is(err, errs.ErrUnavailable) {
+		return fmt.Errorf("can't verify token: %w", err)
+	}
+
+	return errs.Errorf(errs.ErrForbidden, "invalid token: %s", err)
 }
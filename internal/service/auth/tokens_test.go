@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/tinkerborg/open-pulumi-service/internal/errs"
+	"github.com/tinkerborg/open-pulumi-service/internal/model"
+	"github.com/tinkerborg/open-pulumi-service/internal/store"
+)
+
+func TestRevokeToken(t *testing.T) {
+	p := store.NewTestPostgres(t)
+	p.RegisterModels(model.AuthToken{})
+
+	s := &Service{store: p}
+	ctx := context.Background()
+
+	tokens := []*model.AuthToken{
+		{UserID: "account", Value: "a", Name: "first"},
+		{UserID: "account", Value: "b", Name: "second"},
+		{UserID: "other", Value: "c", Name: "other"},
+	}
+
+	for _, token := range tokens {
+		if err := p.Create(ctx, token); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		userID    string
+		tokenID   int
+		wantErr   error
+		remaining []string
+	}{
+		{name: "token of another user", userID: "account", tokenID: tokens[2].ID, wantErr: errs.ErrNotFound, remaining: []string{"first", "second"}},
+		{name: "unknown token", userID: "account", tokenID: -1, wantErr: errs.ErrNotFound, remaining: []string{"first", "second"}},
+		{name: "revokes the token", userID: "account", tokenID: tokens[0].ID, remaining: []string{"second"}},
+		{name: "already revoked", userID: "account", tokenID: tokens[0].ID, wantErr: errs.ErrNotFound, remaining: []string{"second"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := s.RevokeToken(ctx, test.userID, test.tokenID)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("got error %v, want %v", err, test.wantErr)
+			}
+
+			listed, err := s.ListTokens(ctx, test.userID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			names := []string{}
+			for _, token := range listed {
+				names = append(names, token.Name)
+			}
+
+			if len(names) != len(test.remaining) {
+				t.Fatalf("got tokens %v, want %v", names, test.remaining)
+			}
+
+			for i := range names {
+				if names[i] != test.remaining[i] {
+					t.Errorf("got tokens %v, want %v", names, test.remaining)
+				}
+			}
+		})
+	}
+
+	if err := s.RevokeTokens(ctx, "other"); err != nil {
+		t.Fatal(err)
+	}
+
+	if listed, err := s.ListTokens(ctx, "other"); err != nil || len(listed) != 0 {
+		t.Errorf("got tokens %v and error %v after revoking all", listed, err)
+	}
+}
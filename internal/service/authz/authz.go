@@ -0,0 +1,276 @@
+package authz
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/pulumi/pulumi/pkg/v3/backend/httpstate/client"
+	"github.com/tinkerborg/open-pulumi-service/internal/model"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/auth"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/state"
+	"github.com/tinkerborg/open-pulumi-service/internal/store"
+	"github.com/tinkerborg/open-pulumi-service/pkg/router"
+)
+
+type Permission = model.StackPermission
+
+const (
+	PermissionNone  = model.StackPermissionNone
+	PermissionRead  = model.StackPermissionRead
+	PermissionWrite = model.StackPermissionWrite
+	PermissionAdmin = model.StackPermissionAdmin
+)
+
+// Service decides what callers may do with stacks.
+type Service struct {
+	auth  *auth.Service
+	state *state.Service
+}
+
+func New(a *auth.Service, s *state.Service) *Service {
+	return &Service{a, s}
+}
+
+// organizationPermissions are a caller's permissions on the stacks of one organization, resolved
+// once for any number of its stacks.
+type organizationPermissions struct {
+	// base applies to every stack, through ownership, organization role or default permission
+	base   Permission
+	grants []model.TeamStackGrantRecord
+}
+
+// permission returns the permission on a stack, the highest of the base permission and the grants
+// on the stack or its project.
+func (o organizationPermissions) permission(identifier client.StackIdentifier) Permission {
+	permission := o.base
+
+	for _, grant := range o.grants {
+		if grant.Project == identifier.Project && (grant.Stack == model.AllStacks || grant.Stack == identifier.Stack.String()) {
+			permission = max(permission, grant.Permission)
+		}
+	}
+
+	return permission
+}
+
+// userPermissions returns the permissions a user has on an organization's stacks, through
+// ownership, organization role or team grants. Service accounts own no stacks, as their logins
+// are only unique within their organization.
+func (z *Service) userPermissions(ctx context.Context, user *model.ServiceUser, owner string) (organizationPermissions, error) {
+	if owner == user.GitHubLogin && !user.IsServiceAccount() {
+		return organizationPermissions{base: PermissionAdmin}, nil
+	}
+
+	role, err := z.state.GetOrganizationRole(ctx, owner, user.ID)
+	if errors.Is(err, store.ErrNotFound) {
+		return organizationPermissions{}, nil
+	} else if err != nil {
+		return organizationPermissions{}, err
+	}
+
+	if role == model.OrganizationAdmin {
+		return organizationPermissions{base: PermissionAdmin}, nil
+	}
+
+	organization, err := z.state.GetOrganization(ctx, owner)
+	if err != nil {
+		return organizationPermissions{}, err
+	}
+
+	teams, err := z.state.ListUserTeams(ctx, user.ID, owner)
+	if err != nil {
+		return organizationPermissions{}, err
+	}
+
+	teamIDs := []string{}
+	for _, team := range teams {
+		teamIDs = append(teamIDs, team.ID)
+	}
+
+	grants, err := z.state.ListTeamsStackGrants(ctx, teamIDs)
+	if err != nil {
+		return organizationPermissions{}, err
+	}
+
+	return organizationPermissions{base: organization.DefaultStackPermission, grants: grants}, nil
+}
+
+// Stacks returns an authorizer for routes whose target stack is resolved by identifier.
+func (z *Service) Stacks(identifier func(r *http.Request) client.StackIdentifier) *StackAuthorizer {
+	return &StackAuthorizer{z, identifier}
+}
+
+type StackAuthorizer struct {
+	z          *Service
+	identifier func(r *http.Request) client.StackIdentifier
+}
+
+// Require wraps a handler so that it only runs when the caller has at least the given permission
+// on the target stack, responding 403 otherwise.
+func (s *StackAuthorizer) Require(permission Permission, handler router.RouterHandler) router.RouterHandler {
+	return func(w *router.ResponseWriter, r *http.Request) error {
+		granted, err := s.z.RequestStackPermission(r, s.identifier(r))
+		if err != nil {
+			return w.Error(err)
+		}
+
+		if granted < permission {
+			return w.WithStatus(http.StatusForbidden).Errorf("%s permission required", permission)
+		}
+
+		return handler(w, r)
+	}
+}
+
+// RequestStackPermission returns the permission of the caller behind a request on a stack. The
+// stack name may be empty to ask about creating stacks in a project.
+func (z *Service) RequestStackPermission(r *http.Request, identifier client.StackIdentifier) (Permission, error) {
+	permissions, err := z.RequestStackPermissions(r, []client.StackIdentifier{identifier})
+	if err != nil {
+		return PermissionNone, err
+	}
+
+	return permissions[0], nil
+}
+
+// RequestStackPermissions returns the permissions of the caller behind a request on each of the
+// stacks. The caller and their permissions are looked up once per organization, not per stack.
+func (z *Service) RequestStackPermissions(r *http.Request, identifiers []client.StackIdentifier) ([]Permission, error) {
+	resolve, err := z.requestPermissions(r)
+	if err != nil {
+		return nil, err
+	}
+
+	resolved := map[string]organizationPermissions{}
+	permissions := make([]Permission, len(identifiers))
+
+	for i, identifier := range identifiers {
+		organization, ok := resolved[identifier.Owner]
+		if !ok {
+			if organization, err = resolve(identifier.Owner); err != nil {
+				return nil, err
+			}
+			resolved[identifier.Owner] = organization
+		}
+
+		permissions[i] = organization.permission(identifier)
+	}
+
+	return permissions, nil
+}
+
+// requestPermissions returns how to resolve the permissions of the caller behind a request on an
+// organization's stacks. Team tokens have only their team's grants. Organization tokens
+// administer their organization's stacks. Update tokens are scoped to their update and never pass
+// stack authorization.
+func (z *Service) requestPermissions(r *http.Request) (func(owner string) (organizationPermissions, error), error) {
+	claims, err := z.auth.GetRequestClaims(r)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := r.Context()
+
+	switch claims.Type {
+	case auth.UserToken:
+		user, err := z.state.GetUser(ctx, claims.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		return func(owner string) (organizationPermissions, error) {
+			return z.userPermissions(ctx, user, owner)
+		}, nil
+
+	case auth.TeamToken:
+		team, err := z.state.GetTeamByID(ctx, claims.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		return func(owner string) (organizationPermissions, error) {
+			if team.Organization == nil || team.Organization.Name != owner {
+				return organizationPermissions{}, nil
+			}
+
+			grants, err := z.state.ListTeamsStackGrants(ctx, []string{team.ID})
+			return organizationPermissions{grants: grants}, err
+		}, nil
+
+	case auth.OrganizationToken:
+		organization, err := z.state.GetOrganizationByID(ctx, claims.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		return func(owner string) (organizationPermissions, error) {
+			if organization.Name != owner {
+				return organizationPermissions{}, nil
+			}
+			return organizationPermissions{base: PermissionAdmin}, nil
+		}, nil
+	}
+
+	return func(owner string) (organizationPermissions, error) {
+		return organizationPermissions{}, nil
+	}, nil
+}
+
+// RequestUser returns the caller behind a request as the user the CLI sees it as. Team and
+// organization tokens are described by their team or organization and have no user ID.
+func (z *Service) RequestUser(r *http.Request) (*model.ServiceUser, error) {
+	claims, err := z.auth.GetRequestClaims(r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch claims.Type {
+	case auth.TeamToken:
+		team, err := z.state.GetTeamByID(r.Context(), claims.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		return teamUser(team, claims.Name), nil
+
+	case auth.OrganizationToken:
+		organization, err := z.state.GetOrganizationByID(r.Context(), claims.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		return organizationUser(organization, claims.Name), nil
+	}
+
+	return z.state.GetUser(r.Context(), claims.ID)
+}
+
+// teamUser describes a team token as the user the CLI sees it as.
+func teamUser(team *model.TeamRecord, tokenName string) *model.ServiceUser {
+	return &model.ServiceUser{
+		GitHubLogin:   team.Name,
+		Name:          team.DisplayName,
+		Organizations: []model.ServiceUserInfo{team.Organization.Info()},
+		Identities:    []string{},
+		TokenInfo: &model.ServiceTokenInfo{
+			Name:         tokenName,
+			Organization: team.Organization.Name,
+			Team:         team.Name,
+		},
+	}
+}
+
+// organizationUser describes an organization token as the user the CLI sees it as.
+func organizationUser(organization *model.OrganizationRecord, tokenName string) *model.ServiceUser {
+	return &model.ServiceUser{
+		GitHubLogin:   organization.Name,
+		Name:          organization.DisplayName,
+		Organizations: []model.ServiceUserInfo{organization.Info()},
+		Identities:    []string{},
+		TokenInfo: &model.ServiceTokenInfo{
+			Name:         tokenName,
+			Organization: organization.Name,
+		},
+	}
+}
@@ -0,0 +1,47 @@
+package authz
+
+import (
+	"testing"
+
+	"github.com/pulumi/pulumi/pkg/v3/backend/httpstate/client"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/tokens"
+	"github.com/tinkerborg/open-pulumi-service/internal/model"
+)
+
+func TestOrganizationPermissions(t *testing.T) {
+	grants := []model.TeamStackGrantRecord{
+		{TeamID: "a", Project: "web", Stack: "prod", Permission: PermissionRead},
+		{TeamID: "b", Project: "web", Stack: "prod", Permission: PermissionWrite},
+		{TeamID: "a", Project: "api", Stack: model.AllStacks, Permission: PermissionAdmin},
+	}
+
+	tests := []struct {
+		name        string
+		permissions organizationPermissions
+		project     string
+		stack       string
+		want        Permission
+	}{
+		{name: "no grants", permissions: organizationPermissions{}, project: "web", stack: "prod", want: PermissionNone},
+		{name: "base permission", permissions: organizationPermissions{base: PermissionRead}, project: "web", stack: "dev", want: PermissionRead},
+		{name: "highest stack grant", permissions: organizationPermissions{grants: grants}, project: "web", stack: "prod", want: PermissionWrite},
+		{name: "project grant", permissions: organizationPermissions{grants: grants}, project: "api", stack: "dev", want: PermissionAdmin},
+		{name: "other stack", permissions: organizationPermissions{grants: grants}, project: "web", stack: "dev", want: PermissionNone},
+		{name: "base above grants", permissions: organizationPermissions{base: PermissionAdmin, grants: grants}, project: "web", stack: "prod", want: PermissionAdmin},
+		{name: "grant above base", permissions: organizationPermissions{base: PermissionRead, grants: grants}, project: "web", stack: "prod", want: PermissionWrite},
+		{name: "creating in project", permissions: organizationPermissions{grants: grants}, project: "api", want: PermissionAdmin},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			identifier := client.StackIdentifier{Owner: "acme", Project: test.project}
+			if test.stack != "" {
+				identifier.Stack = tokens.MustParseStackName(test.stack)
+			}
+
+			if got := test.permissions.permission(identifier); got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
@@ -1,8 +1,33 @@
 package crypto
 
-import "context"
+import (
+	"bytes"
+	"context"
+	"errors"
+)
 
 type Service interface {
 	Encrypt(ctx context.Context, plaintext []byte) ([]byte, error)
 	Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error)
 }
+
+// Check verifies that a provider is usable by encrypting and decrypting a probe value.
+func Check(ctx context.Context, service Service) error {
+	probe := []byte("open-pulumi-service readiness probe")
+
+	ciphertext, err := service.Encrypt(ctx, probe)
+	if err != nil {
+		return err
+	}
+
+	plaintext, err := service.Decrypt(ctx, ciphertext)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(plaintext, probe) {
+		return errors.New("decrypted probe does not match")
+	}
+
+	return nil
+}
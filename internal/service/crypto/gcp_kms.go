@@ -9,6 +9,9 @@ import (
 
 	wrapping "github.com/hashicorp/go-kms-wrapping"
 	"github.com/hashicorp/go-kms-wrapping/wrappers/gcpckms"
+	"github.com/tinkerborg/open-pulumi-service/internal/errs"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type GoogleKmsCryptoService struct {
@@ -36,7 +39,7 @@ func NewGoogleKmsCryptoService(keyID string) (*GoogleKmsCryptoService, error) {
 func (g GoogleKmsCryptoService) Encrypt(ctx context.Context, plaintext []byte) ([]byte, error) {
 	blob, err := g.wrapper.Encrypt(ctx, plaintext, nil)
 	if err != nil {
-		return nil, err
+		return nil, kmsError(err)
 	}
 
 	ciphertext, err := json.Marshal(blob)
@@ -62,12 +65,29 @@ func (g GoogleKmsCryptoService) Decrypt(ctx context.Context, ciphertext []byte)
 
 	plaintext, err := g.wrapper.Decrypt(ctx, blob, nil)
 	if err != nil {
-		return nil, err
+		return nil, kmsError(err)
 	}
 
 	return plaintext, nil
 }
 
+// kmsError classifies failures that may succeed when retried, such as timeouts and rate limits, as
+// unavailable.
+func kmsError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return errs.With(err, errs.ErrUnavailable)
+	}
+
+	if s, ok := status.FromError(err); ok {
+		switch s.Code() {
+		case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted:
+			return errs.With(err, errs.ErrUnavailable)
+		}
+	}
+
+	return err
+}
+
 func parseKeyID(keyID string) (map[string]string, error) {
 	parts := strings.Split(keyID, "/")
 	if len(parts) != 8 || parts[0] != "projects" || parts[2] != "locations" || parts[4] != "keyRings" || parts[6] != "cryptoKeys" {
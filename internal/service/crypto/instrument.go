@@ -0,0 +1,58 @@
+package crypto
+
+import (
+	"context"
+	"time"
+
+	"github.com/tinkerborg/open-pulumi-service/internal/metrics"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+const tracerName = "github.com/tinkerborg/open-pulumi-service/internal/service/crypto"
+
+// instrumented records the latency and errors of a crypto provider's calls.
+type instrumented struct {
+	service Service
+}
+
+var _ Service = instrumented{}
+
+// Instrument wraps a crypto provider to export metrics and trace spans for its calls.
+func Instrument(service Service) Service {
+	return instrumented{service}
+}
+
+func (i instrumented) Encrypt(ctx context.Context, plaintext []byte) ([]byte, error) {
+	ctx, done := observe(ctx, "encrypt", len(plaintext))
+	ciphertext, err := i.service.Encrypt(ctx, plaintext)
+	done(err)
+	return ciphertext, err
+}
+
+func (i instrumented) Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	ctx, done := observe(ctx, "decrypt", len(ciphertext))
+	plaintext, err := i.service.Decrypt(ctx, ciphertext)
+	done(err)
+	return plaintext, err
+}
+
+// observe starts a span for a crypto call, and returns a function ending it and recording its
+// latency.
+func observe(ctx context.Context, operation string, size int) (context.Context, func(error)) {
+	start := time.Now()
+
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "crypto."+operation)
+	span.SetAttributes(attribute.Int("crypto.input_size", size))
+
+	return ctx, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+
+		metrics.ObserveCrypto(operation, start, err)
+	}
+}
@@ -0,0 +1,72 @@
+package ratelimit
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/tinkerborg/open-pulumi-service/internal/model"
+	"github.com/tinkerborg/open-pulumi-service/internal/store"
+	"github.com/tinkerborg/open-pulumi-service/pkg/router/middleware"
+)
+
+// countRequest counts a request in a single statement, so replicas counting the same caller at
+// once don't lose counts. A window later than the stored one starts the count over. An earlier
+// one, from a replica whose clock is behind, is counted in the stored window.
+const countRequest = `
+INSERT INTO rate_limit_record (key, window_start, count) VALUES (?, ?, 1)
+ON CONFLICT (key) DO UPDATE SET
+	count = CASE WHEN excluded.window_start > rate_limit_record.window_start THEN 1 ELSE rate_limit_record.count + 1 END,
+	window_start = GREATEST(excluded.window_start, rate_limit_record.window_start)
+RETURNING key, window_start, count`
+
+// PostgresLimiter counts requests in the database, so that replicas share their counts.
+type PostgresLimiter struct {
+	store *store.Postgres
+	mutex sync.Mutex
+	swept time.Time
+}
+
+var _ middleware.Limiter = &PostgresLimiter{}
+
+func NewPostgresLimiter(store *store.Postgres) *PostgresLimiter {
+	store.RegisterModels(model.RateLimitRecord{})
+
+	return &PostgresLimiter{store: store, swept: time.Now()}
+}
+
+func (l *PostgresLimiter) Allow(ctx context.Context, key string, limit middleware.Limit) (time.Duration, error) {
+	now := time.Now()
+	l.sweep(ctx, now, limit.Window)
+
+	record := model.RateLimitRecord{}
+	if err := l.store.Raw(ctx, &record, countRequest, key, now.Truncate(limit.Window)); err != nil {
+		return 0, err
+	}
+
+	if record.Count <= limit.Requests {
+		return 0, nil
+	}
+
+	return max(record.WindowStart.Add(limit.Window).Sub(now), time.Second), nil
+}
+
+// sweep deletes the counts of ended windows in the background, at most once per window.
+func (l *PostgresLimiter) sweep(ctx context.Context, now time.Time, interval time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if now.Sub(l.swept) < interval {
+		return
+	}
+
+	l.swept = now
+
+	go func() {
+		cutoff := now.Truncate(interval)
+		if err := l.store.Delete(context.WithoutCancel(ctx), &model.RateLimitRecord{}, store.Where("window_start < ?", cutoff)); err != nil {
+			slog.WarnContext(ctx, "failed to delete expired rate limit counts", "error", err)
+		}
+	}()
+}
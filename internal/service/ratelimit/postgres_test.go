@@ -0,0 +1,89 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tinkerborg/open-pulumi-service/internal/model"
+	"github.com/tinkerborg/open-pulumi-service/internal/store"
+	"github.com/tinkerborg/open-pulumi-service/pkg/router/middleware"
+)
+
+func TestPostgresLimiter(t *testing.T) {
+	l := NewPostgresLimiter(store.NewTestPostgres(t))
+	limit := middleware.Limit{Requests: 2, Window: time.Hour}
+
+	tests := []struct {
+		name    string
+		key     string
+		limited bool
+	}{
+		{name: "first request", key: "a", limited: false},
+		{name: "second request", key: "a", limited: false},
+		{name: "over limit", key: "a", limited: true},
+		{name: "other key", key: "b", limited: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			retryAfter, err := l.Allow(context.Background(), test.key, limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if limited := retryAfter > 0; limited != test.limited {
+				t.Fatalf("want limited %v, got retry after %s", test.limited, retryAfter)
+			}
+			if retryAfter > limit.Window {
+				t.Fatalf("retry after %s is past the window", retryAfter)
+			}
+		})
+	}
+}
+
+func TestPostgresLimiterConcurrent(t *testing.T) {
+	p := store.NewTestPostgres(t)
+	l := NewPostgresLimiter(p)
+	limit := middleware.Limit{Requests: 100, Window: time.Hour}
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := l.Allow(context.Background(), "a", limit); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	record := model.RateLimitRecord{Key: "a"}
+	if err := p.Read(context.Background(), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Count != 20 {
+		t.Fatalf("want 20 requests counted, got %d", record.Count)
+	}
+}
+
+func TestPostgresLimiterEarlierWindow(t *testing.T) {
+	p := store.NewTestPostgres(t)
+	l := NewPostgresLimiter(p)
+	ctx := context.Background()
+
+	// a replica whose clock is behind counts in the stored window rather than starting over
+	future := time.Now().Add(time.Hour).Truncate(time.Minute)
+	if err := p.Create(ctx, &model.RateLimitRecord{Key: "a", WindowStart: future, Count: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	retryAfter, err := l.Allow(ctx, "a", middleware.Limit{Requests: 1, Window: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retryAfter <= 0 {
+		t.Fatal("request counted in a new window")
+	}
+}
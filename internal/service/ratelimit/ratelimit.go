@@ -0,0 +1,112 @@
+// Package ratelimit limits how many requests each caller makes to the API, so that a misbehaving
+// client can't exhaust the KMS quota or the database for everyone else.
+package ratelimit
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/tinkerborg/open-pulumi-service/internal/service/audit"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/auth"
+	"github.com/tinkerborg/open-pulumi-service/internal/store"
+	"github.com/tinkerborg/open-pulumi-service/pkg/router"
+	"github.com/tinkerborg/open-pulumi-service/pkg/router/middleware"
+)
+
+const (
+	StoreMemory   = "memory"
+	StorePostgres = "postgres"
+)
+
+type Config struct {
+	// Store keeps counts in memory, per replica, or in postgres, shared by all replicas
+	Store  string        `env:"RATE_LIMIT_STORE" envDefault:"memory"`
+	Window time.Duration `env:"RATE_LIMIT_WINDOW" envDefault:"1m"`
+	// Requests is how many requests a caller may make per window, and the others how many of
+	// those may be to the expensive routes. Zero is unlimited.
+	Requests   int `env:"RATE_LIMIT_REQUESTS" envDefault:"2400"`
+	Decrypt    int `env:"RATE_LIMIT_DECRYPT" envDefault:"120"`
+	Export     int `env:"RATE_LIMIT_EXPORT" envDefault:"60"`
+	Checkpoint int `env:"RATE_LIMIT_CHECKPOINT" envDefault:"1200"`
+	// Address is how many requests may come from one address per window, counted before
+	// authentication. Callers behind a shared address, such as CI runners, share it.
+	Address int `env:"RATE_LIMIT_ADDRESS" envDefault:"6000"`
+}
+
+// Service limits requests per caller. Its Middleware must run after authentication, and its
+// budgets are route options for the routes that are expensive to serve.
+type Service struct {
+	*middleware.RateLimit
+	// Address limits requests per address. It runs before authentication, so that requests with
+	// bad tokens and routes without authentication are limited too.
+	Address router.Middleware
+	// Decrypt limits encrypting and decrypting secrets, which call KMS
+	Decrypt router.Middleware
+	// Export limits reading whole checkpoints. Handlers spend it once they know the checkpoint
+	// will be read, so that requests answered with 304 Not Modified don't.
+	Export func(w http.ResponseWriter, r *http.Request) bool
+	// Checkpoint limits writing checkpoints
+	Checkpoint router.Middleware
+}
+
+func New(s *store.Postgres, a *auth.Service, config Config) (*Service, error) {
+	if config.Window <= 0 {
+		return nil, fmt.Errorf("invalid rate limit window '%s'", config.Window)
+	}
+
+	var limiter middleware.Limiter
+
+	switch config.Store {
+	case StoreMemory:
+		limiter = middleware.NewMemoryLimiter()
+	case StorePostgres:
+		limiter = NewPostgresLimiter(s)
+	default:
+		return nil, fmt.Errorf("invalid rate limit store '%s'", config.Store)
+	}
+
+	limit := func(requests int) middleware.Limit {
+		return middleware.Limit{Requests: requests, Window: config.Window}
+	}
+
+	rateLimit := middleware.NewRateLimit(limiter, callerKey(a), limit(config.Requests))
+	addressLimit := middleware.NewRateLimit(limiter, addressKey, limit(config.Address))
+
+	return &Service{
+		RateLimit: rateLimit,
+		Address:   addressLimit.Middleware,
+		Decrypt:   rateLimit.Budget("decrypt", limit(config.Decrypt)),
+		Export: func(w http.ResponseWriter, r *http.Request) bool {
+			return rateLimit.Spend(w, r, "export", limit(config.Export))
+		},
+		Checkpoint: rateLimit.Budget("checkpoint", limit(config.Checkpoint)),
+	}, nil
+}
+
+func addressKey(r *http.Request) string {
+	return "ip:" + audit.SourceIP(r)
+}
+
+// callerKey identifies callers by the user, team or organization their token is for, so that
+// all of a user's tokens share a limit. Updates are limited per update token, so that one
+// update can't starve the others.
+func callerKey(a *auth.Service) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		claims, err := a.GetRequestClaims(r)
+		if err != nil {
+			return addressKey(r)
+		}
+
+		switch claims.Type {
+		case auth.UpdateToken:
+			return "token:" + claims.RegisteredClaims.ID
+		case auth.TeamToken:
+			return "team:" + claims.ID
+		case auth.OrganizationToken:
+			return "organization:" + claims.ID
+		default:
+			return "user:" + claims.ID
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{name: "memory", config: Config{Store: StoreMemory, Window: time.Minute}},
+		{name: "unknown store", config: Config{Store: "redis", Window: time.Minute}, wantErr: true},
+		{name: "no window", config: Config{Store: StoreMemory}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := New(nil, nil, test.config)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("want error %v, got %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestAddress(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr []string
+		status     int
+	}{
+		{name: "within limit", remoteAddr: []string{"192.0.2.1:1000", "192.0.2.1:1001"}, status: http.StatusOK},
+		{name: "over limit on other ports", remoteAddr: []string{"192.0.2.1:1000", "192.0.2.1:1001", "192.0.2.1:1002"}, status: http.StatusTooManyRequests},
+		{name: "addresses counted apart", remoteAddr: []string{"192.0.2.1:1000", "192.0.2.1:1001", "192.0.2.2:1000"}, status: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			limits, err := New(nil, nil, Config{Store: StoreMemory, Window: time.Hour, Address: 2, Decrypt: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// route budgets are the caller limit's, so the address limit doesn't count them
+			handler := limits.Decrypt(limits.Address(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+			var response *httptest.ResponseRecorder
+			for _, remoteAddr := range test.remoteAddr {
+				request := httptest.NewRequest(http.MethodPost, "/", nil)
+				request.RemoteAddr = remoteAddr
+
+				response = httptest.NewRecorder()
+				handler.ServeHTTP(response, request)
+			}
+
+			if response.Code != test.status {
+				t.Fatalf("want status %d, got %d", test.status, response.Code)
+			}
+		})
+	}
+}
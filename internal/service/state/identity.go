@@ -0,0 +1,169 @@
+package state
+
+import (
+	"context"
+	"errors"
+
+	"github.com/tinkerborg/open-pulumi-service/internal/errs"
+	"github.com/tinkerborg/open-pulumi-service/internal/model"
+	"github.com/tinkerborg/open-pulumi-service/internal/store"
+)
+
+// IdentityOptions controls how a provider identity is found.
+type IdentityOptions struct {
+	// PreviousSubject is a subject the identity was stored under before, which is replaced with
+	// the current subject when the identity logs in
+	PreviousSubject string
+}
+
+// LoginIdentity returns the user linked to a provider identity, or creates a new user for an
+// identity seen for the first time. Identities are never matched to existing users by login or
+// email, which providers let users change; an existing user adds an identity with LinkIdentity.
+func (p *Service) LoginIdentity(ctx context.Context, provider string, subject string, providerUser *model.ServiceUser, opts ...IdentityOptions) (*model.ServiceUser, error) {
+	options := IdentityOptions{}
+	if len(opts) > 0 {
+		options = opts[len(opts)-1]
+	}
+
+	if subject == "" {
+		return nil, errs.Errorf(errs.ErrInvalidArgument, "%s identity has no subject", provider)
+	}
+
+	var userID string
+
+	err := p.store.Transaction(ctx, func(s *store.Postgres) error {
+		identity := &model.IdentityRecord{Provider: provider, Subject: subject}
+
+		if err := s.Read(ctx, identity); err == nil {
+			userID = identity.UserID
+			return nil
+		} else if !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+
+		if options.PreviousSubject != "" {
+			previous := &model.IdentityRecord{Provider: provider, Subject: options.PreviousSubject}
+
+			if err := s.Read(ctx, previous); err == nil {
+				if err := s.Delete(ctx, previous); err != nil {
+					return err
+				}
+
+				userID = previous.UserID
+				previous.Subject = subject
+
+				return s.Create(ctx, previous)
+			} else if !errors.Is(err, store.ErrNotFound) {
+				return err
+			}
+		}
+
+		if err := ensureNoOrganization(ctx, s, providerUser.GitHubLogin); err != nil {
+			return err
+		}
+
+		if err := s.Create(ctx, providerUser); errors.Is(err, store.ErrExist) {
+			return errs.Errorf(errs.ErrConflict, "a user with login '%s' or the same email already exists, log in as that user to link this %s identity to it", providerUser.GitHubLogin, provider)
+		} else if err != nil {
+			return err
+		}
+
+		userID = providerUser.ID
+
+		return s.Create(ctx, &model.IdentityRecord{
+			Provider: provider,
+			Subject:  subject,
+			UserID:   providerUser.ID,
+			Login:    providerUser.GitHubLogin,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return p.GetUser(ctx, userID)
+}
+
+// LinkIdentity adds a provider identity to an existing user, who started linking it while logged
+// in. An identity belongs to one user, and a user has one identity at each provider.
+func (p *Service) LinkIdentity(ctx context.Context, userID string, provider string, subject string, login string) (*model.ServiceUser, error) {
+	if subject == "" {
+		return nil, errs.Errorf(errs.ErrInvalidArgument, "%s identity has no subject", provider)
+	}
+
+	err := p.store.Transaction(ctx, func(s *store.Postgres) error {
+		identity := &model.IdentityRecord{Provider: provider, Subject: subject}
+
+		if err := s.Read(ctx, identity); err == nil {
+			if identity.UserID == userID {
+				return nil
+			}
+			return errs.Errorf(errs.ErrConflict, "%s identity '%s' is linked to another user", provider, login)
+		} else if !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+
+		count, err := s.Count(ctx, model.IdentityRecord{UserID: userID, Provider: provider})
+		if err != nil {
+			return err
+		}
+
+		if count > 0 {
+			return errs.Errorf(errs.ErrConflict, "user already has a %s identity", provider)
+		}
+
+		return s.Create(ctx, &model.IdentityRecord{
+			Provider: provider,
+			Subject:  subject,
+			UserID:   userID,
+			Login:    login,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return p.GetUser(ctx, userID)
+}
+
+func (p *Service) GetIdentity(ctx context.Context, provider string, subject string) (*model.IdentityRecord, error) {
+	identity := &model.IdentityRecord{Provider: provider, Subject: subject}
+
+	if err := p.store.Read(ctx, identity); err != nil {
+		return nil, err
+	}
+
+	return identity, nil
+}
+
+func (p *Service) ListUserIdentities(ctx context.Context, userID string) ([]model.IdentityRecord, error) {
+	identities := []model.IdentityRecord{}
+
+	if err := p.store.List(ctx, &identities, store.Where(model.IdentityRecord{UserID: userID})); err != nil {
+		return nil, err
+	}
+
+	return identities, nil
+}
+
+// UnlinkIdentity removes a user's identity at a provider. The last identity can't be removed,
+// since the user could no longer log in.
+func (p *Service) UnlinkIdentity(ctx context.Context, userID string, provider string) error {
+	return p.store.Transaction(ctx, func(s *store.Postgres) error {
+		count, err := s.Count(ctx, model.IdentityRecord{UserID: userID})
+		if err != nil {
+			return err
+		}
+
+		identity := &model.IdentityRecord{UserID: userID, Provider: provider}
+		if err := s.Read(ctx, identity); err != nil {
+			return err
+		}
+
+		if count < 2 {
+			return errs.New(errs.ErrPreconditionFailed, "can't unlink the last identity")
+		}
+
+		return s.Delete(ctx, identity)
+	})
+}
@@ -0,0 +1,160 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/tinkerborg/open-pulumi-service/internal/errs"
+	"github.com/tinkerborg/open-pulumi-service/internal/model"
+	"github.com/tinkerborg/open-pulumi-service/internal/store"
+)
+
+func TestLoginIdentityPreviousSubject(t *testing.T) {
+	s := store.NewTestPostgres(t)
+	ctx := context.Background()
+
+	p, err := New(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := p.LoginIdentity(ctx, "oidc", "1234", &model.ServiceUser{GitHubLogin: "jdoe", Email: "j@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		subject  string
+		previous string
+		login    string
+		same     bool
+	}{
+		{name: "moves the previous subject", subject: "https://a.example.com#1234", previous: "1234", login: "a", same: true},
+		{name: "finds the moved subject", subject: "https://a.example.com#1234", previous: "1234", login: "a", same: true},
+		{name: "same subject at another issuer", subject: "https://b.example.com#1234", previous: "1234", login: "b", same: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			providerUser := &model.ServiceUser{GitHubLogin: test.login, Email: test.login + "@example.com"}
+
+			got, err := p.LoginIdentity(ctx, "oidc", test.subject, providerUser, IdentityOptions{PreviousSubject: test.previous})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if (got.ID == user.ID) != test.same {
+				t.Errorf("got user %s, first user was %s", got.ID, user.ID)
+			}
+		})
+	}
+}
+
+func TestLoginIdentityMatchesSubjectOnly(t *testing.T) {
+	s := store.NewTestPostgres(t)
+	ctx := context.Background()
+
+	p, err := New(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := p.LoginIdentity(ctx, "github", "1", &model.ServiceUser{GitHubLogin: "jdoe", Email: "j@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		provider string
+		subject  string
+		login    string
+		email    string
+		same     bool
+		conflict bool
+	}{
+		{name: "same subject", provider: "github", subject: "1", login: "renamed", email: "renamed@example.com", same: true},
+		{name: "same email at another provider", provider: "gitlab", subject: "1", login: "other", email: "j@example.com", conflict: true},
+		{name: "same login at another provider", provider: "gitlab", subject: "2", login: "jdoe", email: "other@example.com", conflict: true},
+		{name: "new identity", provider: "gitlab", subject: "3", login: "someone", email: "someone@example.com"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			providerUser := &model.ServiceUser{GitHubLogin: test.login, Email: test.email}
+
+			got, err := p.LoginIdentity(ctx, test.provider, test.subject, providerUser)
+			if test.conflict {
+				if !errors.Is(err, errs.ErrConflict) {
+					t.Fatalf("got error %v, want a conflict", err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if (got.ID == user.ID) != test.same {
+				t.Errorf("got user %s, first user was %s", got.ID, user.ID)
+			}
+		})
+	}
+}
+
+func TestLinkIdentity(t *testing.T) {
+	s := store.NewTestPostgres(t)
+	ctx := context.Background()
+
+	p, err := New(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := p.LoginIdentity(ctx, "github", "1", &model.ServiceUser{GitHubLogin: "jdoe", Email: "j@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := p.LoginIdentity(ctx, "github", "2", &model.ServiceUser{GitHubLogin: "other", Email: "other@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		userID   string
+		provider string
+		subject  string
+		conflict bool
+	}{
+		{name: "links a new identity", userID: user.ID, provider: "gitlab", subject: "10"},
+		{name: "links it again", userID: user.ID, provider: "gitlab", subject: "10"},
+		{name: "identity of another user", userID: user.ID, provider: "github", subject: "2", conflict: true},
+		{name: "second identity at a provider", userID: user.ID, provider: "gitlab", subject: "11", conflict: true},
+		{name: "identity linked to another user", userID: other.ID, provider: "gitlab", subject: "10", conflict: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := p.LinkIdentity(ctx, test.userID, test.provider, test.subject, "login")
+			if test.conflict {
+				if !errors.Is(err, errs.ErrConflict) {
+					t.Fatalf("got error %v, want a conflict", err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := p.LoginIdentity(ctx, test.provider, test.subject, &model.ServiceUser{GitHubLogin: "unused"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got.ID != test.userID {
+				t.Errorf("identity logs in as %s, want %s", got.ID, test.userID)
+			}
+		})
+	}
+}
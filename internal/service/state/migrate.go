@@ -0,0 +1,56 @@
+package state
+
+import "github.com/tinkerborg/open-pulumi-service/internal/store"
+
+// migrations backfill the columns added to existing tables. Append new ones at the end, as they
+// run in order and are recorded by name.
+var migrations = []store.Migration{
+	{
+		// events stored before their type, URN and severity had columns of their own; the type is
+		// the name of the event's only field, less its "Event" suffix, as in model.EngineEventType
+		Name: "engine-event-columns",
+		SQL: `
+UPDATE engine_event_record SET
+	type = COALESCE((
+		SELECT regexp_replace(key, 'Event$', '') FROM jsonb_object_keys(engine_event) AS key
+		WHERE key LIKE '%Event' LIMIT 1
+	), ''),
+	urn = COALESCE(
+		engine_event #>> '{diagnosticEvent,urn}',
+		engine_event #>> '{resourcePreEvent,metadata,urn}',
+		engine_event #>> '{resOutputsEvent,metadata,urn}',
+		engine_event #>> '{resOpFailedEvent,metadata,urn}',
+		engine_event #>> '{policyEvent,resourceUrn}',
+		engine_event #>> '{policyRemediationEvent,resourceUrn}',
+		''
+	),
+	severity = COALESCE(
+		engine_event #>> '{diagnosticEvent,severity}',
+		engine_event #>> '{policyEvent,enforcementLevel}',
+		''
+	)
+WHERE type IS NULL AND jsonb_typeof(engine_event) = 'object'`,
+	},
+	{
+		// organizations created before they had a default stack permission, which is write
+		Name: "organization-default-stack-permission",
+		SQL:  `UPDATE organization_record SET default_stack_permission = 102 WHERE default_stack_permission IS NULL`,
+	},
+	{
+		// service accounts of organizations deleted before deleting an organization deleted them
+		Name: "orphaned-service-accounts",
+		SQL: `DELETE FROM service_user WHERE service_account_org_id IS NOT NULL
+AND service_account_org_id NOT IN (SELECT id FROM organization_record)`,
+	},
+	{
+		// deliveries kept part of the receiver's response, which could expose internal hosts
+		Name: "drop-webhook-delivery-response-body",
+		SQL:  `ALTER TABLE webhook_delivery_record DROP COLUMN IF EXISTS response_body`,
+	},
+	{
+		// logins were unique across people and service accounts, which now have a namespace per
+		// organization
+		Name: "service-account-login-namespace",
+		SQL:  `DROP INDEX IF EXISTS idx_service_user_git_hub_login`,
+	},
+}
@@ -0,0 +1,72 @@
+package state
+
+import "sync"
+
+// notifier fans out wake-ups to listeners keyed by update ID. Notifications carry no
+// payload - listeners are expected to re-read whatever they are interested in.
+type notifier struct {
+	mu        sync.Mutex
+	listeners map[string]map[chan struct{}]struct{}
+	closed    bool
+}
+
+func newNotifier() *notifier {
+	return &notifier{
+		listeners: map[string]map[chan struct{}]struct{}{},
+	}
+}
+
+func (n *notifier) subscribe(key string) (<-chan struct{}, func()) {
+	// buffer of one so that notifications arriving while the listener is busy are coalesced
+	ch := make(chan struct{}, 1)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.closed {
+		close(ch)
+		return ch, func() {}
+	}
+
+	if n.listeners[key] == nil {
+		n.listeners[key] = map[chan struct{}]struct{}{}
+	}
+	n.listeners[key][ch] = struct{}{}
+
+	return ch, func() {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+
+		delete(n.listeners[key], ch)
+		if len(n.listeners[key]) == 0 {
+			delete(n.listeners, key)
+		}
+	}
+}
+
+func (n *notifier) notify(key string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for ch := range n.listeners[key] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// close closes every listener's channel, now and on later subscriptions, so listeners stop waiting.
+func (n *notifier) close() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.closed = true
+
+	for key, listeners := range n.listeners {
+		for ch := range listeners {
+			close(ch)
+		}
+		delete(n.listeners, key)
+	}
+}
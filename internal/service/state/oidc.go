@@ -0,0 +1,138 @@
+package state
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/tinkerborg/open-pulumi-service/internal/errs"
+	"github.com/tinkerborg/open-pulumi-service/internal/model"
+	"github.com/tinkerborg/open-pulumi-service/internal/store"
+)
+
+func (p *Service) CreateOIDCIssuer(ctx context.Context, organizationName string, issuer *model.OIDCIssuerRecord) error {
+	if err := validateOIDCIssuer(issuer); err != nil {
+		return err
+	}
+
+	organization, err := readOrganizationRecord(ctx, p.store, organizationName)
+	if err != nil {
+		return err
+	}
+
+	issuer.OrganizationID = organization.ID
+
+	return p.store.Create(ctx, issuer)
+}
+
+func (p *Service) GetOIDCIssuer(ctx context.Context, organizationName string, name string) (*model.OIDCIssuerRecord, error) {
+	return readOIDCIssuerRecord(ctx, p.store, organizationName, name)
+}
+
+func (p *Service) ListOIDCIssuers(ctx context.Context, organizationName string) ([]model.OIDCIssuerRecord, error) {
+	organization, err := readOrganizationRecord(ctx, p.store, organizationName)
+	if err != nil {
+		return nil, err
+	}
+
+	issuers := []model.OIDCIssuerRecord{}
+
+	if err := p.store.List(ctx, &issuers, store.Where(model.OIDCIssuerRecord{OrganizationID: organization.ID})); err != nil {
+		return nil, err
+	}
+
+	return issuers, nil
+}
+
+// UpdateOIDCIssuer replaces the URL, expiration limit and policies of an issuer.
+func (p *Service) UpdateOIDCIssuer(ctx context.Context, organizationName string, issuer *model.OIDCIssuerRecord) error {
+	if err := validateOIDCIssuer(issuer); err != nil {
+		return err
+	}
+
+	return p.store.Transaction(ctx, func(s *store.Postgres) error {
+		existing, err := readOIDCIssuerRecord(ctx, s, organizationName, issuer.Name)
+		if err != nil {
+			return err
+		}
+
+		existing.URL = issuer.URL
+		existing.MaxExpiration = issuer.MaxExpiration
+		existing.Policies = issuer.Policies
+
+		if err := s.Update(ctx, existing); err != nil {
+			return err
+		}
+
+		*issuer = *existing
+
+		return nil
+	})
+}
+
+func (p *Service) DeleteOIDCIssuer(ctx context.Context, organizationName string, name string) error {
+	issuer, err := readOIDCIssuerRecord(ctx, p.store, organizationName, name)
+	if err != nil {
+		return err
+	}
+
+	return p.store.Delete(ctx, issuer)
+}
+
+// FindOIDCIssuers returns the issuers an organization trusts with the given issuer URL.
+func (p *Service) FindOIDCIssuers(ctx context.Context, organizationName string, issuerURL string) ([]model.OIDCIssuerRecord, error) {
+	organization, err := readOrganizationRecord(ctx, p.store, organizationName)
+	if err != nil {
+		return nil, err
+	}
+
+	issuers := []model.OIDCIssuerRecord{}
+
+	if err := p.store.List(ctx, &issuers, store.Where(model.OIDCIssuerRecord{
+		OrganizationID: organization.ID,
+		URL:            issuerURL,
+	})); err != nil {
+		return nil, err
+	}
+
+	return issuers, nil
+}
+
+func validateOIDCIssuer(issuer *model.OIDCIssuerRecord) error {
+	if !validName.MatchString(issuer.Name) {
+		return errs.Errorf(errs.ErrInvalidArgument, "invalid issuer name '%s'", issuer.Name)
+	}
+
+	if parsed, err := url.Parse(issuer.URL); err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+		return errs.New(errs.ErrInvalidArgument, "issuer url must be an https url")
+	}
+
+	if issuer.MaxExpiration < 0 {
+		return errs.Errorf(errs.ErrInvalidArgument, "invalid max expiration %d", issuer.MaxExpiration)
+	}
+
+	for _, policy := range issuer.Policies {
+		if err := policy.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readOIDCIssuerRecord(ctx context.Context, s *store.Postgres, organizationName string, name string) (*model.OIDCIssuerRecord, error) {
+	organization, err := readOrganizationRecord(ctx, s, organizationName)
+	if err != nil {
+		return nil, err
+	}
+
+	issuer := &model.OIDCIssuerRecord{
+		OrganizationID: organization.ID,
+		Name:           name,
+	}
+
+	if err := s.Read(ctx, issuer); err != nil {
+		return nil, err
+	}
+
+	return issuer, nil
+}
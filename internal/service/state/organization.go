@@ -0,0 +1,340 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"regexp"
+
+	"github.com/tinkerborg/open-pulumi-service/internal/errs"
+	"github.com/tinkerborg/open-pulumi-service/internal/model"
+	"github.com/tinkerborg/open-pulumi-service/internal/store"
+)
+
+var validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]{0,39}$`)
+
+var ErrLastAdmin = errs.New(errs.ErrPreconditionFailed, "organization must have at least one admin")
+
+// CreateOrganization creates an organization with the given user as its first admin. Organizations
+// and users share a namespace since both can own stacks. An unset default stack permission is the
+// column's default of write.
+func (p *Service) CreateOrganization(ctx context.Context, organization *model.OrganizationRecord, admin *model.ServiceUser) error {
+	if !validName.MatchString(organization.Name) {
+		return errs.Errorf(errs.ErrInvalidArgument, "invalid organization name '%s'", organization.Name)
+	}
+
+	return p.store.Transaction(ctx, func(s *store.Postgres) error {
+		if _, err := readUserByLogin(ctx, s, organization.Name); err == nil {
+			return store.ErrExist
+		} else if !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+
+		if err := s.Create(ctx, organization); err != nil {
+			return err
+		}
+
+		return s.Create(ctx, &model.OrganizationMemberRecord{
+			OrganizationID: organization.ID,
+			UserID:         admin.ID,
+			Role:           model.OrganizationAdmin,
+		})
+	})
+}
+
+func (p *Service) GetOrganization(ctx context.Context, name string) (*model.OrganizationRecord, error) {
+	return readOrganizationRecord(ctx, p.store, name)
+}
+
+func (p *Service) GetOrganizationByID(ctx context.Context, id string) (*model.OrganizationRecord, error) {
+	organizations := []model.OrganizationRecord{}
+
+	if err := p.store.List(ctx, &organizations, store.Where(model.OrganizationRecord{ID: id})); err != nil {
+		return nil, err
+	}
+
+	if len(organizations) == 0 {
+		return nil, store.ErrNotFound
+	}
+
+	return &organizations[0], nil
+}
+
+func (p *Service) DeleteOrganization(ctx context.Context, name string) error {
+	organization, err := readOrganizationRecord(ctx, p.store, name)
+	if err != nil {
+		return err
+	}
+
+	count, err := p.store.Count(ctx, model.StackRecord{Owner: organization.Name})
+	if err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return errs.Errorf(errs.ErrPreconditionFailed, "organization '%s' still owns %d stacks", name, count)
+	}
+
+	// service accounts belong to the organization and go with it
+	err = p.store.Transaction(ctx, func(s *store.Postgres) error {
+		if err := s.Delete(ctx, &model.ServiceUser{}, store.Where("service_account_org_id = ?", organization.ID)); err != nil {
+			return err
+		}
+
+		return s.Delete(ctx, organization)
+	})
+	if err != nil {
+		return err
+	}
+
+	p.audit.RecordSystem(ctx, "organization.deleted", organization.Name, "", "")
+
+	return nil
+}
+
+func (p *Service) ListOrganizationMembers(ctx context.Context, name string) ([]model.OrganizationMemberRecord, error) {
+	organization, err := readOrganizationRecord(ctx, p.store, name)
+	if err != nil {
+		return nil, err
+	}
+
+	members := []model.OrganizationMemberRecord{}
+
+	if err := p.store.List(ctx, &members,
+		store.Join(model.ServiceUserInfo{}),
+		store.Where(model.OrganizationMemberRecord{OrganizationID: organization.ID}),
+	); err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+func (p *Service) AddOrganizationMember(ctx context.Context, name string, userLogin string, role model.OrganizationRole) error {
+	organization, err := readOrganizationRecord(ctx, p.store, name)
+	if err != nil {
+		return err
+	}
+
+	user, err := readOrganizationUser(ctx, p.store, organization, userLogin)
+	if err != nil {
+		return err
+	}
+
+	return p.store.Create(ctx, &model.OrganizationMemberRecord{
+		OrganizationID: organization.ID,
+		UserID:         user.ID,
+		Role:           role,
+	})
+}
+
+func (p *Service) UpdateOrganizationMember(ctx context.Context, name string, userLogin string, role model.OrganizationRole) error {
+	return p.store.Transaction(ctx, func(s *store.Postgres) error {
+		member, err := readOrganizationMember(ctx, s, name, userLogin)
+		if err != nil {
+			return err
+		}
+
+		if member.Role == model.OrganizationAdmin && role != model.OrganizationAdmin {
+			if err := ensureOtherAdmin(ctx, s, member); err != nil {
+				return err
+			}
+		}
+
+		member.Role = role
+
+		return s.Update(ctx, member)
+	})
+}
+
+func (p *Service) RemoveOrganizationMember(ctx context.Context, name string, userLogin string) error {
+	return p.store.Transaction(ctx, func(s *store.Postgres) error {
+		member, err := readOrganizationMember(ctx, s, name, userLogin)
+		if err != nil {
+			return err
+		}
+
+		if member.Role == model.OrganizationAdmin {
+			if err := ensureOtherAdmin(ctx, s, member); err != nil {
+				return err
+			}
+		}
+
+		if err := s.Delete(ctx, &model.TeamMemberRecord{},
+			store.Where("user_id = ? AND team_id IN (SELECT id FROM team_record WHERE organization_id = ?)",
+				member.UserID, member.OrganizationID),
+		); err != nil {
+			return err
+		}
+
+		return s.Delete(ctx, member)
+	})
+}
+
+// GetOrganizationRole returns the role of a user in an organization, or store.ErrNotFound if the
+// user is not a member.
+func (p *Service) GetOrganizationRole(ctx context.Context, name string, userID string) (model.OrganizationRole, error) {
+	organization, err := readOrganizationRecord(ctx, p.store, name)
+	if err != nil {
+		return "", err
+	}
+
+	member := &model.OrganizationMemberRecord{
+		OrganizationID: organization.ID,
+		UserID:         userID,
+	}
+
+	if err := p.store.Read(ctx, member); err != nil {
+		return "", err
+	}
+
+	return member.Role, nil
+}
+
+func (p *Service) ListUserOrganizations(ctx context.Context, userID string) ([]model.OrganizationRecord, error) {
+	members := []model.OrganizationMemberRecord{}
+
+	if err := p.store.List(ctx, &members,
+		store.Join(model.OrganizationRecord{}),
+		store.Where(model.OrganizationMemberRecord{UserID: userID}),
+	); err != nil {
+		return nil, err
+	}
+
+	organizations := []model.OrganizationRecord{}
+
+	for _, member := range members {
+		organizations = append(organizations, *member.Organization)
+	}
+
+	return organizations, nil
+}
+
+// GetDefaultOrganization returns the organization new stacks are created in when the CLI isn't
+// given one: the user's chosen default if they are still a member, otherwise their own account.
+// Service accounts default to the organization that owns them.
+func (p *Service) GetDefaultOrganization(ctx context.Context, user *model.ServiceUser) (string, error) {
+	if user.DefaultOrg == "" && user.IsServiceAccount() {
+		organization, err := p.GetOrganizationByID(ctx, *user.ServiceAccountOrgID)
+		if err != nil {
+			return "", err
+		}
+		return organization.Name, nil
+	}
+
+	if user.DefaultOrg == "" {
+		return user.GitHubLogin, nil
+	}
+
+	if _, err := p.GetOrganizationRole(ctx, user.DefaultOrg, user.ID); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return user.GitHubLogin, nil
+		}
+		return "", err
+	}
+
+	return user.DefaultOrg, nil
+}
+
+func (p *Service) SetDefaultOrganization(ctx context.Context, user *model.ServiceUser, name string) error {
+	if name != user.GitHubLogin || user.IsServiceAccount() {
+		if _, err := p.GetOrganizationRole(ctx, name, user.ID); err != nil {
+			return err
+		}
+	}
+
+	user.DefaultOrg = name
+
+	return p.store.Update(ctx, user)
+}
+
+// ownerExists reports whether a stack owner refers to an existing organization or user.
+func ownerExists(ctx context.Context, s *store.Postgres, owner string) (bool, error) {
+	if _, err := readOrganizationRecord(ctx, s, owner); err == nil {
+		return true, nil
+	} else if !errors.Is(err, store.ErrNotFound) {
+		return false, err
+	}
+
+	if _, err := readUserByLogin(ctx, s, owner); err == nil {
+		return true, nil
+	} else if !errors.Is(err, store.ErrNotFound) {
+		return false, err
+	}
+
+	return false, nil
+}
+
+func ensureOtherAdmin(ctx context.Context, s *store.Postgres, member *model.OrganizationMemberRecord) error {
+	count, err := s.Count(ctx, model.OrganizationMemberRecord{
+		OrganizationID: member.OrganizationID,
+		Role:           model.OrganizationAdmin,
+	})
+	if err != nil {
+		return err
+	}
+
+	if count < 2 {
+		return ErrLastAdmin
+	}
+
+	return nil
+}
+
+// ensureNoOrganization fails with a conflict when an organization has the login, since users and
+// organizations share a namespace.
+func ensureNoOrganization(ctx context.Context, s *store.Postgres, login string) error {
+	if _, err := readOrganizationRecord(ctx, s, login); err == nil {
+		return errs.Errorf(errs.ErrConflict, "login '%s' is taken by an organization", login)
+	} else if !errors.Is(err, store.ErrNotFound) {
+		return err
+	}
+
+	return nil
+}
+
+func readOrganizationRecord(ctx context.Context, s *store.Postgres, name string) (*model.OrganizationRecord, error) {
+	organization := &model.OrganizationRecord{Name: name}
+
+	if err := s.Read(ctx, organization); err != nil {
+		return nil, err
+	}
+
+	return organization, nil
+}
+
+// readOrganizationUser reads a user by login as an organization names its members: one of its
+// service accounts, or else a person.
+func readOrganizationUser(ctx context.Context, s *store.Postgres, organization *model.OrganizationRecord, login string) (*model.ServiceUser, error) {
+	account := &model.ServiceUser{GitHubLogin: login, ServiceAccountOrgID: &organization.ID}
+
+	if err := s.Read(ctx, account); err == nil {
+		return account, nil
+	} else if !errors.Is(err, store.ErrNotFound) {
+		return nil, err
+	}
+
+	return readUserByLogin(ctx, s, login)
+}
+
+func readOrganizationMember(ctx context.Context, s *store.Postgres, name string, userLogin string) (*model.OrganizationMemberRecord, error) {
+	organization, err := readOrganizationRecord(ctx, s, name)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := readOrganizationUser(ctx, s, organization, userLogin)
+	if err != nil {
+		return nil, err
+	}
+
+	member := &model.OrganizationMemberRecord{
+		OrganizationID: organization.ID,
+		UserID:         user.ID,
+	}
+
+	if err := s.Read(ctx, member); err != nil {
+		return nil, err
+	}
+
+	return member, nil
+}
@@ -0,0 +1,220 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/tinkerborg/open-pulumi-service/internal/errs"
+	"github.com/tinkerborg/open-pulumi-service/internal/model"
+	"github.com/tinkerborg/open-pulumi-service/internal/store"
+)
+
+func TestCreateOrganizationDefaultStackPermission(t *testing.T) {
+	s := store.NewTestPostgres(t)
+	ctx := context.Background()
+
+	p, err := New(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	admin := &model.ServiceUser{GitHubLogin: "admin", Email: "admin@example.com"}
+	if err := p.CreateUser(ctx, admin); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		permission model.StackPermission
+		want       model.StackPermission
+	}{
+		{name: "unset", permission: model.StackPermissionNone, want: model.StackPermissionWrite},
+		{name: "read", permission: model.StackPermissionRead, want: model.StackPermissionRead},
+		{name: "admin", permission: model.StackPermissionAdmin, want: model.StackPermissionAdmin},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			organization := &model.OrganizationRecord{Name: "org-" + test.name, DefaultStackPermission: test.permission}
+			if err := p.CreateOrganization(ctx, organization, admin); err != nil {
+				t.Fatal(err)
+			}
+
+			stored, err := p.GetOrganization(ctx, organization.Name)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if stored.DefaultStackPermission != test.want {
+				t.Errorf("got %s, want %s", stored.DefaultStackPermission, test.want)
+			}
+		})
+	}
+}
+
+func TestDeleteOrganizationServiceAccounts(t *testing.T) {
+	s := store.NewTestPostgres(t)
+	ctx := context.Background()
+
+	p, err := New(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	admin := &model.ServiceUser{GitHubLogin: "admin", Email: "admin@example.com"}
+	if err := p.CreateUser(ctx, admin); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"deleted", "kept"} {
+		if err := p.CreateOrganization(ctx, &model.OrganizationRecord{Name: name}, admin); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := p.CreateServiceAccount(ctx, name, &model.ServiceUser{GitHubLogin: name + "-bot"}, model.OrganizationMember); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := p.DeleteOrganization(ctx, "deleted"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		login  string
+		exists bool
+	}{
+		{login: "deleted-bot", exists: false},
+		{login: "kept-bot", exists: true},
+		{login: "admin", exists: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.login, func(t *testing.T) {
+			count, err := s.Count(ctx, model.ServiceUser{GitHubLogin: test.login})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if (count > 0) != test.exists {
+				t.Errorf("user exists %t, want %t", count > 0, test.exists)
+			}
+		})
+	}
+}
+
+func TestOrganizationUserNamespace(t *testing.T) {
+	s := store.NewTestPostgres(t)
+	ctx := context.Background()
+
+	p, err := New(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	admin := &model.ServiceUser{GitHubLogin: "admin", Email: "admin@example.com"}
+	if err := p.CreateUser(ctx, admin); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.CreateOrganization(ctx, &model.OrganizationRecord{Name: "acme"}, admin); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		create  func() error
+		wantErr error
+	}{
+		{name: "organization named after a user", wantErr: store.ErrExist, create: func() error {
+			return p.CreateOrganization(ctx, &model.OrganizationRecord{Name: "admin"}, admin)
+		}},
+		{name: "user named after an organization", wantErr: errs.ErrConflict, create: func() error {
+			return p.CreateUser(ctx, &model.ServiceUser{GitHubLogin: "acme", Email: "acme@example.com"})
+		}},
+		{name: "login named after an organization", wantErr: errs.ErrConflict, create: func() error {
+			_, err := p.LoginIdentity(ctx, "github", "1", &model.ServiceUser{GitHubLogin: "acme", Email: "acme@example.com"})
+			return err
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.create(); !errors.Is(err, test.wantErr) {
+				t.Fatalf("want %v, got %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestServiceAccountNamespace(t *testing.T) {
+	s := store.NewTestPostgres(t)
+	ctx := context.Background()
+
+	p, err := New(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	admin := &model.ServiceUser{GitHubLogin: "admin", Email: "admin@example.com"}
+	if err := p.CreateUser(ctx, admin); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"acme", "other"} {
+		if err := p.CreateOrganization(ctx, &model.OrganizationRecord{Name: name}, admin); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		create  func() error
+		wantErr error
+	}{
+		{name: "service account", create: func() error {
+			return p.CreateServiceAccount(ctx, "acme", &model.ServiceUser{GitHubLogin: "ci"}, model.OrganizationMember)
+		}},
+		{name: "same name in another organization", create: func() error {
+			return p.CreateServiceAccount(ctx, "other", &model.ServiceUser{GitHubLogin: "ci"}, model.OrganizationMember)
+		}},
+		{name: "same name in the same organization", wantErr: errs.ErrConflict, create: func() error {
+			return p.CreateServiceAccount(ctx, "acme", &model.ServiceUser{GitHubLogin: "ci"}, model.OrganizationMember)
+		}},
+		{name: "person named after a service account", create: func() error {
+			return p.CreateUser(ctx, &model.ServiceUser{GitHubLogin: "ci", Email: "ci@example.com"})
+		}},
+		{name: "login named after a service account", create: func() error {
+			_, err := p.LoginIdentity(ctx, "github", "1", &model.ServiceUser{GitHubLogin: "deploy", Email: "deploy@example.com"})
+			return err
+		}},
+		{name: "service account named after a person", create: func() error {
+			return p.CreateServiceAccount(ctx, "acme", &model.ServiceUser{GitHubLogin: "deploy"}, model.OrganizationMember)
+		}},
+		{name: "service account named after a member", wantErr: errs.ErrConflict, create: func() error {
+			return p.CreateServiceAccount(ctx, "acme", &model.ServiceUser{GitHubLogin: "admin"}, model.OrganizationMember)
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.create(); !errors.Is(err, test.wantErr) {
+				t.Fatalf("want %v, got %v", test.wantErr, err)
+			}
+		})
+	}
+
+	if user, err := p.GetUserByName(ctx, "ci"); err != nil || user.IsServiceAccount() {
+		t.Errorf("got %+v and error %v for the person named ci", user, err)
+	}
+
+	other, err := readOrganizationRecord(ctx, s, "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if account, err := p.GetServiceAccount(ctx, "other", "ci"); err != nil || *account.ServiceAccountOrgID != other.ID {
+		t.Errorf("got %+v and error %v for other's service account", account, err)
+	}
+}
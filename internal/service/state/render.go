@@ -0,0 +1,140 @@
+package state
+
+import (
+	"context"
+	"io"
+
+	"github.com/pulumi/pulumi/pkg/v3/backend/display"
+	"github.com/pulumi/pulumi/pkg/v3/backend/httpstate/client"
+	"github.com/pulumi/pulumi/pkg/v3/engine"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/diag/colors"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/tokens"
+	"github.com/tinkerborg/open-pulumi-service/internal/util"
+)
+
+const renderPageSize = 1000
+
+type RenderOptions struct {
+	// Color enables ANSI escape codes in the output
+	Color bool
+	// Diff renders the detailed diff view instead of the progress view
+	Diff bool
+}
+
+// actionLabels are the headings the CLI prints above an update's progress, by update kind, for
+// previews and for updates that make changes.
+var actionLabels = map[apitype.UpdateKind]struct{ preview, update string }{
+	apitype.PreviewUpdate:        {"Previewing update", "Previewing"},
+	apitype.UpdateUpdate:         {"Previewing update", "Updating"},
+	apitype.RefreshUpdate:        {"Previewing refresh", "Refreshing"},
+	apitype.DestroyUpdate:        {"Previewing destroy", "Destroying"},
+	apitype.StackImportUpdate:    {"Previewing stack import", "Importing"},
+	apitype.ResourceImportUpdate: {"Previewing import", "Importing"},
+	apitype.RenameUpdate:         {"Previewing rename", "Renaming"},
+}
+
+// actionLabel returns the heading the CLI prints for an update of kind, falling back to the kind
+// itself for kinds from newer CLIs.
+func actionLabel(kind apitype.UpdateKind, isPreview bool) string {
+	label, ok := actionLabels[kind]
+	if !ok {
+		return string(kind)
+	}
+
+	if isPreview {
+		return label.preview
+	}
+
+	return label.update
+}
+
+// RenderUpdateLog writes an update's engine events to w the way the CLI displays them, reading
+// them a page at a time so that the log is never held in memory whole. Nothing is written before
+// the update has been found.
+func (p *Service) RenderUpdateLog(ctx context.Context, identifier client.UpdateIdentifier, w io.Writer, opts ...RenderOptions) error {
+	o, err := util.Merge(RenderOptions{}, opts)
+	if err != nil {
+		return err
+	}
+
+	updateRecord, err := readUpdateRecord(ctx, p.store, identifier)
+	if err != nil {
+		return err
+	}
+
+	isPreview := updateRecord.DryRun != nil && *updateRecord.DryRun
+
+	action := actionLabel(updateRecord.Kind, isPreview)
+
+	displayOptions := display.Options{
+		Color:               colors.Never,
+		Stdout:              w,
+		Stderr:              w,
+		SuppressPermalink:   true,
+		SuppressProgress:    true,
+		DeterministicOutput: true,
+		ShowResourceChanges: o.Diff,
+		Type:                display.DisplayProgress,
+	}
+
+	if o.Color {
+		displayOptions.Color = colors.Always
+	}
+
+	events := make(chan engine.Event)
+	done := make(chan bool)
+
+	go func() {
+		if o.Diff {
+			display.ShowDiffEvents(action, events, done, displayOptions)
+		} else {
+			project := tokens.PackageName(identifier.Project)
+			display.ShowProgressEvents(action, updateRecord.Kind, identifier.Stack, project, "", events, done, displayOptions, isPreview)
+		}
+	}()
+
+	// the display stops reading once it sees a cancel event, so sends must not outlive it
+	send := func(event engine.Event) bool {
+		select {
+		case events <- event:
+			return true
+		case <-done:
+			return false
+		}
+	}
+
+	listOptions := ListEngineEventOptions{PageSize: renderPageSize}
+
+	for {
+		page, err := p.ListEngineEvents(ctx, identifier, listOptions)
+		if err != nil {
+			close(events)
+			<-done
+			return err
+		}
+
+		for _, apiEvent := range page {
+			listOptions.AfterSequence = &apiEvent.Sequence
+
+			event, err := display.ConvertJSONEvent(apiEvent)
+			if err != nil {
+				// events from newer CLIs may not be understood by the display, skip them
+				continue
+			}
+
+			if !send(event) {
+				return nil
+			}
+		}
+
+		if len(page) < renderPageSize {
+			break
+		}
+	}
+
+	close(events)
+	<-done
+
+	return nil
+}
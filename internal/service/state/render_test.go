@@ -0,0 +1,31 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+)
+
+func TestActionLabel(t *testing.T) {
+	tests := []struct {
+		kind      apitype.UpdateKind
+		isPreview bool
+		want      string
+	}{
+		{kind: apitype.UpdateUpdate, want: "Updating"},
+		{kind: apitype.UpdateUpdate, isPreview: true, want: "Previewing update"},
+		{kind: apitype.PreviewUpdate, isPreview: true, want: "Previewing update"},
+		{kind: apitype.RefreshUpdate, want: "Refreshing"},
+		{kind: apitype.DestroyUpdate, isPreview: true, want: "Previewing destroy"},
+		{kind: apitype.StackImportUpdate, want: "Importing"},
+		{kind: apitype.ResourceImportUpdate, isPreview: true, want: "Previewing import"},
+		{kind: apitype.RenameUpdate, want: "Renaming"},
+		{kind: "unknown", want: "unknown"},
+	}
+
+	for _, test := range tests {
+		if got := actionLabel(test.kind, test.isPreview); got != test.want {
+			t.Errorf("actionLabel(%s, %t) = '%s', want '%s'", test.kind, test.isPreview, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/tinkerborg/open-pulumi-service/internal/errs"
+	"github.com/tinkerborg/open-pulumi-service/internal/model"
+	"github.com/tinkerborg/open-pulumi-service/internal/store"
+)
+
+// CreateServiceAccount creates a machine user owned by an organization and adds it as a member.
+// Service accounts are named within their organization, apart from people and other
+// organizations, but can't take the login of a person who is a member. They get an email under the
+// reserved .invalid domain so they never match a provider identity.
+func (p *Service) CreateServiceAccount(ctx context.Context, organizationName string, account *model.ServiceUser, role model.OrganizationRole) error {
+	if !validName.MatchString(account.GitHubLogin) {
+		return errs.Errorf(errs.ErrInvalidArgument, "invalid service account name '%s'", account.GitHubLogin)
+	}
+
+	return p.store.Transaction(ctx, func(s *store.Postgres) error {
+		organization, err := readOrganizationRecord(ctx, s, organizationName)
+		if err != nil {
+			return err
+		}
+
+		if _, err := readOrganizationMember(ctx, s, organization.Name, account.GitHubLogin); err == nil {
+			return errs.Errorf(errs.ErrConflict, "organization '%s' already has a member named '%s'", organization.Name, account.GitHubLogin)
+		} else if !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+
+		account.ServiceAccountOrgID = &organization.ID
+		account.Email = fmt.Sprintf("%s@%s.serviceaccount.invalid", account.GitHubLogin, organization.Name)
+
+		if err := s.Create(ctx, account); err != nil {
+			return err
+		}
+
+		return s.Create(ctx, &model.OrganizationMemberRecord{
+			OrganizationID: organization.ID,
+			UserID:         account.ID,
+			Role:           role,
+		})
+	})
+}
+
+func (p *Service) GetServiceAccount(ctx context.Context, organizationName string, name string) (*model.ServiceUser, error) {
+	return readServiceAccount(ctx, p.store, organizationName, name)
+}
+
+func (p *Service) ListServiceAccounts(ctx context.Context, organizationName string) ([]model.ServiceUser, error) {
+	organization, err := readOrganizationRecord(ctx, p.store, organizationName)
+	if err != nil {
+		return nil, err
+	}
+
+	accounts := []model.ServiceUser{}
+
+	if err := p.store.List(ctx, &accounts, store.Where("service_account_org_id = ?", organization.ID)); err != nil {
+		return nil, err
+	}
+
+	return accounts, nil
+}
+
+func (p *Service) DeleteServiceAccount(ctx context.Context, organizationName string, name string) error {
+	account, err := readServiceAccount(ctx, p.store, organizationName, name)
+	if err != nil {
+		return err
+	}
+
+	return p.store.Delete(ctx, account)
+}
+
+func readServiceAccount(ctx context.Context, s *store.Postgres, organizationName string, name string) (*model.ServiceUser, error) {
+	organization, err := readOrganizationRecord(ctx, s, organizationName)
+	if err != nil {
+		return nil, err
+	}
+
+	account := &model.ServiceUser{GitHubLogin: name, ServiceAccountOrgID: &organization.ID}
+
+	if err := s.Read(ctx, account); err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
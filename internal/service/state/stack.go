@@ -1,7 +1,9 @@
 package state
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"strconv"
 	"time"
 
@@ -9,18 +11,28 @@ import (
 	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/tokens"
 	"github.com/tinkerborg/open-pulumi-service/internal/model"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/webhook"
 	"github.com/tinkerborg/open-pulumi-service/internal/store"
 	"github.com/tinkerborg/open-pulumi-service/internal/util"
 )
 
 // TODO - clean up handling of version strings (parse to int / latest)
 
-func (p *Service) CreateStack(stack *apitype.Stack) error {
+func (p *Service) CreateStack(ctx context.Context, stack *apitype.Stack) error {
 	stackName, err := tokens.ParseStackName(stack.StackName.String())
 	if err != nil {
 		return err
 	}
 
+	exists, err := ownerExists(ctx, p.store, stack.OrgName)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return fmt.Errorf("stack owner '%s' %w", stack.OrgName, store.ErrNotFound)
+	}
+
 	record := model.StackRecord{
 		Owner:   stack.OrgName,
 		Project: stack.ProjectName,
@@ -28,15 +40,24 @@ func (p *Service) CreateStack(stack *apitype.Stack) error {
 		Stack:   stack,
 	}
 
-	if err := p.store.Create(&record); err != nil {
+	if err := p.store.Create(ctx, &record); err != nil {
 		return err
 	}
 
+	p.audit.RecordSystem(ctx, "stack.created", record.Owner, record.Project+"/"+record.Name, "")
+
+	p.notifyWebhooks(ctx, client.StackIdentifier{Owner: record.Owner, Project: record.Project, Stack: stackName}, webhook.StackEvent(webhook.EventStackCreated, &webhook.StackPayload{
+		Organization: webhook.Organization{GitHubLogin: record.Owner},
+		Action:       "created",
+		ProjectName:  record.Project,
+		StackName:    record.Name,
+	}))
+
 	return nil
 }
 
-func (p *Service) GetStack(identifier client.StackIdentifier) (*apitype.Stack, error) {
-	stackRecord, err := readStackRecord(p.store, identifier)
+func (p *Service) GetStack(ctx context.Context, identifier client.StackIdentifier) (*apitype.Stack, error) {
+	stackRecord, err := readStackRecord(ctx, p.store, identifier)
 	if err != nil {
 		return nil, err
 	}
@@ -44,13 +65,31 @@ func (p *Service) GetStack(identifier client.StackIdentifier) (*apitype.Stack, e
 	return stackRecord.Stack, nil
 }
 
-func (p *Service) DeleteStack(identifier client.StackIdentifier) error {
-	return p.store.Delete(StackRecord(identifier))
+func (p *Service) DeleteStack(ctx context.Context, identifier client.StackIdentifier) error {
+	if err := p.store.Delete(ctx, StackRecord(identifier)); err != nil {
+		return err
+	}
+
+	// a stack's own webhooks go with it, so only organization webhooks see it deleted
+	if err := p.deleteStackWebhooks(ctx, identifier); err != nil {
+		return err
+	}
+
+	p.audit.RecordSystem(ctx, "stack.deleted", identifier.Owner, identifier.Project+"/"+identifier.Stack.String(), "")
+
+	p.notifyWebhooks(ctx, identifier, webhook.StackEvent(webhook.EventStackDeleted, &webhook.StackPayload{
+		Organization: webhook.Organization{GitHubLogin: identifier.Owner},
+		Action:       "deleted",
+		ProjectName:  identifier.Project,
+		StackName:    identifier.Stack.String(),
+	}))
+
+	return nil
 }
 
 // TODO support latest
-func (p *Service) GetStackUpdate(identifier client.StackIdentifier, version string) (*model.StackUpdate, error) {
-	stackRecord, err := readStackRecord(p.store, identifier)
+func (p *Service) GetStackUpdate(ctx context.Context, identifier client.StackIdentifier, version string) (*model.StackUpdate, error) {
+	stackRecord, err := readStackRecord(ctx, p.store, identifier)
 	if err != nil {
 		return nil, err
 	}
@@ -66,21 +105,17 @@ func (p *Service) GetStackUpdate(identifier client.StackIdentifier, version stri
 		DryRun:  util.Ptr(false),
 	}
 
-	if err := p.store.Read(updateRecord, store.Join(model.ServiceUserInfo{})); err != nil {
+	if err := p.store.Read(ctx, updateRecord, store.Join(model.ServiceUserInfo{})); err != nil {
 		return nil, err
 	}
 
 	return createStackUpdate(stackRecord, updateRecord), nil
 }
 
-func (p *Service) GetStackDeployment(identifier client.StackIdentifier) (*apitype.UntypedDeployment, error) {
-	// TODO nested preloads
-	stackRecord, err := readStackRecord(p.store, identifier)
-	if err != nil {
-		return nil, err
-	}
-
-	if stackRecord.Stack.Version == 0 {
+// GetStackDeployment returns the checkpoint of a stack's active update, for a stack already read
+// with GetStack.
+func (p *Service) GetStackDeployment(ctx context.Context, stack *apitype.Stack) (*apitype.UntypedDeployment, error) {
+	if stack.Version == 0 {
 		deployment, _ := json.Marshal(&apitype.DeploymentV1{
 			Manifest: apitype.ManifestV1{
 				Time:    time.Date(0001, time.January, 1, 0, 0, 0, 0, time.UTC),
@@ -96,10 +131,10 @@ func (p *Service) GetStackDeployment(identifier client.StackIdentifier) (*apityp
 	}
 
 	checkpointRecord := &model.CheckpointRecord{
-		UpdateID: stackRecord.Stack.ActiveUpdate,
+		UpdateID: stack.ActiveUpdate,
 	}
 
-	if err := p.store.Read(checkpointRecord); err != nil {
+	if err := p.store.Read(ctx, checkpointRecord); err != nil {
 		return nil, err
 	}
 
@@ -113,12 +148,12 @@ func (p *Service) GetStackDeployment(identifier client.StackIdentifier) (*apityp
 
 }
 
-func (p *Service) ListStackResources(identifier client.UpdateIdentifier) ([]apitype.ResourceV3, error) {
+func (p *Service) ListStackResources(ctx context.Context, identifier client.UpdateIdentifier) ([]apitype.ResourceV3, error) {
 	checkpointRecord := &model.CheckpointRecord{
 		UpdateID: identifier.UpdateID,
 	}
 
-	if err := p.store.Read(checkpointRecord); err != nil {
+	if err := p.store.Read(ctx, checkpointRecord); err != nil {
 		return nil, err
 	}
 
@@ -148,10 +183,10 @@ func (p *Service) ParseStackVersion(stack *apitype.Stack, version string) (int,
 	return versionNumber, nil
 }
 
-func readStackRecord(s *store.Postgres, identifier client.StackIdentifier, opts ...store.DBOption) (*model.StackRecord, error) {
+func readStackRecord(ctx context.Context, s *store.Postgres, identifier client.StackIdentifier, opts ...store.DBOption) (*model.StackRecord, error) {
 	stackRecord := StackRecord(identifier)
 
-	err := s.Read(stackRecord, opts...)
+	err := s.Read(ctx, stackRecord, opts...)
 	if err != nil {
 		return nil, err
 	}
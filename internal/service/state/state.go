@@ -1,17 +1,35 @@
 package state
 
 import (
-	"errors"
+	"context"
 
 	"github.com/tinkerborg/open-pulumi-service/internal/model"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/audit"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/webhook"
 	"github.com/tinkerborg/open-pulumi-service/internal/store"
+	"github.com/tinkerborg/open-pulumi-service/internal/util"
 )
 
 type Service struct {
-	store *store.Postgres
+	store    *store.Postgres
+	events   *notifier
+	audit    *audit.Service
+	webhooks *webhook.Service
 }
 
-func New(store *store.Postgres) *Service {
+type Options struct {
+	// Audit records stack and organization lifecycle events, nil records nothing
+	Audit *audit.Service
+	// Webhooks delivers stack and update events, nil delivers nothing
+	Webhooks *webhook.Service
+}
+
+func New(store *store.Postgres, opts ...Options) (*Service, error) {
+	o, err := util.Merge(Options{}, opts)
+	if err != nil {
+		return nil, err
+	}
+
 	store.RegisterModels(
 		model.StackRecord{},
 		model.UpdateRecord{},
@@ -19,13 +37,20 @@ func New(store *store.Postgres) *Service {
 		model.EngineEventRecord{},
 		model.StackVersionRecord{},
 		model.ServiceUser{},
+		model.IdentityRecord{},
+		model.OrganizationRecord{},
+		model.OrganizationMemberRecord{},
+		model.TeamRecord{},
+		model.TeamMemberRecord{},
+		model.TeamStackGrantRecord{},
+		model.OIDCIssuerRecord{},
+		model.WebhookRecord{},
+		model.WebhookDeliveryRecord{},
 	)
 
-	return &Service{store}
-}
+	if err := store.Migrate(context.Background(), migrations...); err != nil {
+		return nil, err
+	}
 
-// TODO - these should be used to abstract the db error types
-var (
-	ErrExist    = errors.New("resource already exists")
-	ErrNotExist = errors.New("resource does not exist")
-)
+	return &Service{store, newNotifier(), o.Audit, o.Webhooks}, nil
+}
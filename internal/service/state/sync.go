@@ -0,0 +1,112 @@
+package state
+
+import (
+	"context"
+	"errors"
+
+	"github.com/tinkerborg/open-pulumi-service/internal/model"
+	"github.com/tinkerborg/open-pulumi-service/internal/store"
+)
+
+// ExternalOrganization is an organization a user belongs to at an identity provider, with the
+// names of the teams they belong to within it.
+type ExternalOrganization struct {
+	Name  string
+	Teams []string
+}
+
+// SyncMemberships makes the organization and team memberships a source manages for a user match
+// what the identity provider reports. Organizations and teams that don't exist in the service are
+// skipped, and memberships added through the API are left alone.
+func (p *Service) SyncMemberships(ctx context.Context, userID string, source string, organizations []ExternalOrganization) error {
+	return p.store.Transaction(ctx, func(s *store.Postgres) error {
+		organizationIDs := map[string]bool{}
+		teamIDs := map[string]bool{}
+
+		for _, external := range organizations {
+			organization, err := readOrganizationRecord(ctx, s, external.Name)
+			if errors.Is(err, store.ErrNotFound) {
+				continue
+			} else if err != nil {
+				return err
+			}
+
+			organizationIDs[organization.ID] = true
+
+			if err := s.CreateIgnoreExisting(ctx, &model.OrganizationMemberRecord{
+				OrganizationID: organization.ID,
+				UserID:         userID,
+				Role:           model.OrganizationMember,
+				Source:         source,
+			}); err != nil {
+				return err
+			}
+
+			for _, teamName := range external.Teams {
+				team := &model.TeamRecord{OrganizationID: organization.ID, Name: teamName}
+
+				if err := s.Read(ctx, team); errors.Is(err, store.ErrNotFound) {
+					continue
+				} else if err != nil {
+					return err
+				}
+
+				teamIDs[team.ID] = true
+
+				if err := s.CreateIgnoreExisting(ctx, &model.TeamMemberRecord{
+					TeamID: team.ID,
+					UserID: userID,
+					Source: source,
+				}); err != nil {
+					return err
+				}
+			}
+		}
+
+		teamMembers := []model.TeamMemberRecord{}
+		if err := s.List(ctx, &teamMembers, store.Where(model.TeamMemberRecord{UserID: userID, Source: source})); err != nil {
+			return err
+		}
+
+		for _, member := range teamMembers {
+			if !teamIDs[member.TeamID] {
+				if err := s.Delete(ctx, &member); err != nil {
+					return err
+				}
+			}
+		}
+
+		members := []model.OrganizationMemberRecord{}
+		if err := s.List(ctx, &members, store.Where(model.OrganizationMemberRecord{UserID: userID, Source: source})); err != nil {
+			return err
+		}
+
+		for _, member := range members {
+			if organizationIDs[member.OrganizationID] {
+				continue
+			}
+
+			// an organization's last admin stays, even if the provider no longer lists them
+			if member.Role == model.OrganizationAdmin {
+				if err := ensureOtherAdmin(ctx, s, &member); errors.Is(err, ErrLastAdmin) {
+					continue
+				} else if err != nil {
+					return err
+				}
+			}
+
+			if err := s.Delete(ctx, &model.TeamMemberRecord{},
+				store.Where("user_id = ? AND team_id IN (SELECT id FROM team_record WHERE organization_id = ?)",
+					member.UserID, member.OrganizationID),
+			); err != nil {
+				return err
+			}
+
+			if err := s.Delete(ctx, &member); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,245 @@
+package state
+
+import (
+	"context"
+
+	"github.com/tinkerborg/open-pulumi-service/internal/errs"
+	"github.com/tinkerborg/open-pulumi-service/internal/model"
+	"github.com/tinkerborg/open-pulumi-service/internal/store"
+)
+
+func (p *Service) CreateTeam(ctx context.Context, organizationName string, team *model.TeamRecord) error {
+	if !validName.MatchString(team.Name) {
+		return errs.Errorf(errs.ErrInvalidArgument, "invalid team name '%s'", team.Name)
+	}
+
+	organization, err := readOrganizationRecord(ctx, p.store, organizationName)
+	if err != nil {
+		return err
+	}
+
+	team.OrganizationID = organization.ID
+
+	return p.store.Create(ctx, team)
+}
+
+func (p *Service) GetTeam(ctx context.Context, organizationName string, teamName string) (*model.TeamRecord, error) {
+	return readTeamRecord(ctx, p.store, organizationName, teamName)
+}
+
+func (p *Service) GetTeamByID(ctx context.Context, id string) (*model.TeamRecord, error) {
+	team := &model.TeamRecord{ID: id}
+
+	if err := p.store.Read(ctx, team, store.Join(model.OrganizationRecord{})); err != nil {
+		return nil, err
+	}
+
+	return team, nil
+}
+
+func (p *Service) ListTeams(ctx context.Context, organizationName string) ([]model.TeamRecord, error) {
+	organization, err := readOrganizationRecord(ctx, p.store, organizationName)
+	if err != nil {
+		return nil, err
+	}
+
+	teams := []model.TeamRecord{}
+
+	if err := p.store.List(ctx, &teams, store.Where(model.TeamRecord{OrganizationID: organization.ID})); err != nil {
+		return nil, err
+	}
+
+	return teams, nil
+}
+
+func (p *Service) UpdateTeam(ctx context.Context, organizationName string, teamName string, displayName, description *string) error {
+	return p.store.Transaction(ctx, func(s *store.Postgres) error {
+		team, err := readTeamRecord(ctx, s, organizationName, teamName)
+		if err != nil {
+			return err
+		}
+
+		if displayName != nil {
+			team.DisplayName = *displayName
+		}
+
+		if description != nil {
+			team.Description = *description
+		}
+
+		return s.Update(ctx, team)
+	})
+}
+
+func (p *Service) DeleteTeam(ctx context.Context, organizationName string, teamName string) error {
+	team, err := readTeamRecord(ctx, p.store, organizationName, teamName)
+	if err != nil {
+		return err
+	}
+
+	return p.store.Delete(ctx, team)
+}
+
+func (p *Service) ListTeamMembers(ctx context.Context, organizationName string, teamName string) ([]model.TeamMemberRecord, error) {
+	team, err := readTeamRecord(ctx, p.store, organizationName, teamName)
+	if err != nil {
+		return nil, err
+	}
+
+	members := []model.TeamMemberRecord{}
+
+	if err := p.store.List(ctx, &members,
+		store.Join(model.ServiceUserInfo{}),
+		store.Where(model.TeamMemberRecord{TeamID: team.ID}),
+	); err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+// AddTeamMember adds a user to a team. Only members of the team's organization can join it.
+func (p *Service) AddTeamMember(ctx context.Context, organizationName string, teamName string, userLogin string) error {
+	team, err := readTeamRecord(ctx, p.store, organizationName, teamName)
+	if err != nil {
+		return err
+	}
+
+	member, err := readOrganizationMember(ctx, p.store, organizationName, userLogin)
+	if err != nil {
+		return err
+	}
+
+	return p.store.Create(ctx, &model.TeamMemberRecord{
+		TeamID: team.ID,
+		UserID: member.UserID,
+	})
+}
+
+func (p *Service) RemoveTeamMember(ctx context.Context, organizationName string, teamName string, userLogin string) error {
+	team, err := readTeamRecord(ctx, p.store, organizationName, teamName)
+	if err != nil {
+		return err
+	}
+
+	member, err := readOrganizationMember(ctx, p.store, organizationName, userLogin)
+	if err != nil {
+		return err
+	}
+
+	return p.store.Delete(ctx, &model.TeamMemberRecord{
+		TeamID: team.ID,
+		UserID: member.UserID,
+	})
+}
+
+func (p *Service) ListTeamStackGrants(ctx context.Context, organizationName string, teamName string) ([]model.TeamStackGrantRecord, error) {
+	team, err := readTeamRecord(ctx, p.store, organizationName, teamName)
+	if err != nil {
+		return nil, err
+	}
+
+	grants := []model.TeamStackGrantRecord{}
+
+	if err := p.store.List(ctx, &grants, store.Where(model.TeamStackGrantRecord{TeamID: team.ID})); err != nil {
+		return nil, err
+	}
+
+	return grants, nil
+}
+
+// GrantTeamStackPermission gives a team a permission on a stack, replacing any previous grant. An
+// empty stack name grants the permission on the whole project.
+func (p *Service) GrantTeamStackPermission(ctx context.Context, organizationName string, teamName string, project string, stack string, permission model.StackPermission) error {
+	team, err := readTeamRecord(ctx, p.store, organizationName, teamName)
+	if err != nil {
+		return err
+	}
+
+	return p.store.Upsert(ctx, &model.TeamStackGrantRecord{
+		TeamID:     team.ID,
+		Project:    project,
+		Stack:      grantStackName(stack),
+		Permission: permission,
+	})
+}
+
+func (p *Service) RevokeTeamStackPermission(ctx context.Context, organizationName string, teamName string, project string, stack string) error {
+	team, err := readTeamRecord(ctx, p.store, organizationName, teamName)
+	if err != nil {
+		return err
+	}
+
+	return p.store.Delete(ctx, &model.TeamStackGrantRecord{
+		TeamID:  team.ID,
+		Project: project,
+		Stack:   grantStackName(stack),
+	})
+}
+
+// ListTeamsStackGrants returns the stack permissions granted to any of the teams, by team ID.
+func (p *Service) ListTeamsStackGrants(ctx context.Context, teamIDs []string) ([]model.TeamStackGrantRecord, error) {
+	grants := []model.TeamStackGrantRecord{}
+
+	if len(teamIDs) == 0 {
+		return grants, nil
+	}
+
+	if err := p.store.List(ctx, &grants, store.Where("team_id IN ?", teamIDs)); err != nil {
+		return nil, err
+	}
+
+	return grants, nil
+}
+
+// ListUserTeams returns the teams a user belongs to within an organization.
+func (p *Service) ListUserTeams(ctx context.Context, userID string, organizationName string) ([]model.TeamRecord, error) {
+	organization, err := readOrganizationRecord(ctx, p.store, organizationName)
+	if err != nil {
+		return nil, err
+	}
+
+	members := []model.TeamMemberRecord{}
+
+	if err := p.store.List(ctx, &members,
+		store.Join(model.TeamRecord{}),
+		store.Where(model.TeamMemberRecord{UserID: userID}),
+	); err != nil {
+		return nil, err
+	}
+
+	teams := []model.TeamRecord{}
+
+	for _, member := range members {
+		if member.Team.OrganizationID == organization.ID {
+			teams = append(teams, *member.Team)
+		}
+	}
+
+	return teams, nil
+}
+
+func grantStackName(stack string) string {
+	if stack == "" {
+		return model.AllStacks
+	}
+	return stack
+}
+
+func readTeamRecord(ctx context.Context, s *store.Postgres, organizationName string, teamName string) (*model.TeamRecord, error) {
+	organization, err := readOrganizationRecord(ctx, s, organizationName)
+	if err != nil {
+		return nil, err
+	}
+
+	team := &model.TeamRecord{
+		OrganizationID: organization.ID,
+		Name:           teamName,
+	}
+
+	if err := s.Read(ctx, team); err != nil {
+		return nil, err
+	}
+
+	return team, nil
+}
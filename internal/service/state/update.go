@@ -1,11 +1,19 @@
 package state
 
 import (
+	"cmp"
+	"context"
+	"encoding/base64"
+	"slices"
+	"strconv"
 	"time"
 
 	"github.com/pulumi/pulumi/pkg/v3/backend/httpstate/client"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+	"github.com/tinkerborg/open-pulumi-service/internal/errs"
+	"github.com/tinkerborg/open-pulumi-service/internal/metrics"
 	"github.com/tinkerborg/open-pulumi-service/internal/model"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/webhook"
 	"github.com/tinkerborg/open-pulumi-service/internal/store"
 	"github.com/tinkerborg/open-pulumi-service/internal/util"
 )
@@ -13,15 +21,19 @@ import (
 // TODO constrain update kind
 // TODO - not use UpdateProgramRequest
 // func (p *PulumiStateService) CreateUpdate(owner, project, name, kind string, update *apitype.UpdateProgram, options *apitype.UpdateOptions) (*string, error) {
+//
+// CreateUpdate records who requested the update: a user by reference, or a team or organization
+// token, which has no user ID, as it is now. Imports have no requester.
 func (p *Service) CreateUpdate(
+	ctx context.Context,
 	identifier client.UpdateIdentifier,
 	update *apitype.UpdateProgram,
 	options *apitype.UpdateOptions,
 	config map[string]apitype.ConfigValue,
 	metadata *apitype.UpdateMetadata,
-	user *model.ServiceUser,
+	requester *model.ServiceUser,
 ) (*string, error) {
-	stackRecord, err := readStackRecord(p.store, identifier.StackIdentifier)
+	stackRecord, err := readStackRecord(ctx, p.store, identifier.StackIdentifier)
 	if err != nil {
 		return nil, err
 	}
@@ -59,24 +71,31 @@ func (p *Service) CreateUpdate(
 			// TODO
 			// ContinuationToken:
 		},
-		// TODO should accept serviceuserinfo directly
-		RequestedBy: &model.ServiceUserInfo{
-			ID: user.ID,
-		},
 	}
 
-	if err := p.store.Create(&updateRecord); err != nil {
+	if requester != nil && requester.ID != "" {
+		updateRecord.UserID = &requester.ID
+	} else if requester != nil {
+		updateRecord.Requester = &model.ServiceUserInfo{
+			Name:        requester.Name,
+			GitHubLogin: requester.GitHubLogin,
+			AvatarURL:   requester.AvatarURL,
+		}
+	}
+
+	if err := p.store.Create(ctx, &updateRecord); err != nil {
 		return nil, err
 	}
 
 	return &updateRecord.ID, nil
 }
 
-func (p *Service) StartUpdate(identifier client.UpdateIdentifier) (int, error) {
+func (p *Service) StartUpdate(ctx context.Context, identifier client.UpdateIdentifier) (int, error) {
 	var version int
+	var started *model.UpdateRecord
 
-	if err := p.store.Transaction(func(s *store.Postgres) error {
-		updateRecord, err := readUpdateRecord(s, identifier.UpdateID)
+	if err := p.store.Transaction(ctx, func(s *store.Postgres) error {
+		updateRecord, err := readUpdateRecord(ctx, s, identifier)
 		if err != nil {
 			return err
 		}
@@ -84,26 +103,31 @@ func (p *Service) StartUpdate(identifier client.UpdateIdentifier) (int, error) {
 		updateRecord.StartTime = time.Now()
 		updateRecord.Results.Status = apitype.StatusRunning
 
-		if err := s.Update(updateRecord); err != nil {
+		if err := s.Update(ctx, updateRecord); err != nil {
 			return err
 		}
 
 		version = updateRecord.Version
+		started = updateRecord
 
 		return nil
 	}); err != nil {
 		return version, err
 	}
 
+	metrics.UpdateStarted(string(started.Kind))
+	p.notifyUpdateWebhooks(ctx, identifier, started, webhook.ResultStarted)
+
 	return version, nil
 }
 
-func (p *Service) CompleteUpdate(identifier client.UpdateIdentifier, status apitype.UpdateStatus) (*int, error) {
+func (p *Service) CompleteUpdate(ctx context.Context, identifier client.UpdateIdentifier, status apitype.UpdateStatus) (*int, error) {
 	var version int
+	var completed *model.UpdateRecord
 
-	if err := p.store.Transaction(func(s *store.Postgres) error {
+	if err := p.store.Transaction(ctx, func(s *store.Postgres) error {
 		// TODO - transaction
-		updateRecord, err := readUpdateRecord(s, identifier.UpdateID)
+		updateRecord, err := readUpdateRecord(ctx, s, identifier)
 		if err != nil {
 			return err
 		}
@@ -113,7 +137,7 @@ func (p *Service) CompleteUpdate(identifier client.UpdateIdentifier, status apit
 
 		activeUpdateIdentifier := identifier
 		if updateRecord.Options.DryRun {
-			stack, err := p.GetStack(identifier.StackIdentifier)
+			stack, err := p.GetStack(ctx, identifier.StackIdentifier)
 			if err != nil {
 				return err
 			}
@@ -126,14 +150,14 @@ func (p *Service) CompleteUpdate(identifier client.UpdateIdentifier, status apit
 		if activeUpdateIdentifier.UpdateID == "" {
 			updateRecord.ResourceCount = 0
 		} else {
-			resources, err := p.ListStackResources(activeUpdateIdentifier)
+			resources, err := p.ListStackResources(ctx, activeUpdateIdentifier)
 			if err != nil {
 				return err
 			}
 			updateRecord.ResourceCount = len(resources)
 		}
 
-		events, err := p.ListEngineEvents(identifier)
+		events, err := p.ListEngineEvents(ctx, identifier, ListEngineEventOptions{Types: []string{"summary"}})
 		if err != nil {
 			return err
 		}
@@ -144,7 +168,7 @@ func (p *Service) CompleteUpdate(identifier client.UpdateIdentifier, status apit
 			}
 		}
 
-		if err := s.Update(updateRecord); err != nil {
+		if err := s.Update(ctx, updateRecord); err != nil {
 			return err
 		}
 
@@ -152,7 +176,7 @@ func (p *Service) CompleteUpdate(identifier client.UpdateIdentifier, status apit
 			// TODO
 		} else {
 
-			stackRecord, err := readStackRecord(s, identifier.StackIdentifier)
+			stackRecord, err := readStackRecord(ctx, s, identifier.StackIdentifier)
 			if err != nil {
 				return err
 			}
@@ -166,38 +190,43 @@ func (p *Service) CompleteUpdate(identifier client.UpdateIdentifier, status apit
 				UpdateID: updateRecord.ID,
 			}
 
-			if err := s.Update(versionRecord); err != nil {
+			if err := s.Update(ctx, versionRecord); err != nil {
 				return err
 			}
 
-			if err := s.Update(stackRecord); err != nil {
+			if err := s.Update(ctx, stackRecord); err != nil {
 				return err
 			}
 		}
 
 		version = updateRecord.Version
+		completed = updateRecord
 
 		return nil
 	}); err != nil {
 		return nil, err
 	}
 
+	p.events.notify(identifier.UpdateID)
+	metrics.UpdateCompleted(string(completed.Kind), webhook.UpdateResult(status))
+	p.notifyUpdateWebhooks(ctx, identifier, completed, webhook.UpdateResult(status))
+
 	return &version, nil
 }
 
-func (p *Service) GetUpdatesCount(identifier client.StackIdentifier) (int64, error) {
+func (p *Service) GetUpdatesCount(ctx context.Context, identifier client.StackIdentifier) (int64, error) {
 	stack := StackRecord(identifier)
-	if err := p.store.Read(stack); err != nil {
+	if err := p.store.Read(ctx, stack); err != nil {
 		return -1, err
 	}
 
-	return p.store.Count(
+	return p.store.Count(ctx,
 		model.UpdateRecord{StackID: stack.ID, DryRun: util.Ptr(false)},
 	)
 }
 
-func (p *Service) ListUpdates(identifier client.StackIdentifier, opts ...ListUpdateOptions) ([]model.StackUpdate, error) {
-	stack, err := readStackRecord(p.store, identifier)
+func (p *Service) ListUpdates(ctx context.Context, identifier client.StackIdentifier, opts ...ListUpdateOptions) ([]model.StackUpdate, error) {
+	stack, err := readStackRecord(ctx, p.store, identifier)
 	if err != nil {
 		return nil, err
 	}
@@ -209,7 +238,7 @@ func (p *Service) ListUpdates(identifier client.StackIdentifier, opts ...ListUpd
 
 	updateRecords := []model.UpdateRecord{}
 
-	p.store.List(&updateRecords,
+	p.store.List(ctx, &updateRecords,
 		store.Join(model.ServiceUserInfo{}),
 		store.Where(model.UpdateRecord{StackID: stack.ID, DryRun: util.Ptr(false)}),
 		store.Limit(o.PageSize),
@@ -222,7 +251,7 @@ func (p *Service) ListUpdates(identifier client.StackIdentifier, opts ...ListUpd
 	for _, update := range updateRecords {
 		updates = append(updates, model.StackUpdate{
 			Info:        createUpdateInfo(&update),
-			RequestedBy: update.RequestedBy,
+			RequestedBy: requestedBy(&update),
 			GetDeploymentUpdatesUpdateInfo: apitype.GetDeploymentUpdatesUpdateInfo{
 				UpdateID:      update.ID,
 				Version:       update.Version,
@@ -234,58 +263,120 @@ func (p *Service) ListUpdates(identifier client.StackIdentifier, opts ...ListUpd
 	return updates, nil
 }
 
-func (p *Service) GetUpdateResults(identifier client.UpdateIdentifier) (*apitype.UpdateResults, error) {
-	updateRecord, err := readUpdateRecord(p.store, identifier.UpdateID)
+func (p *Service) GetUpdateStatus(ctx context.Context, identifier client.UpdateIdentifier) (apitype.UpdateStatus, error) {
+	updateRecord, err := readUpdateRecord(ctx, p.store, identifier)
+	if err != nil {
+		return "", err
+	}
+
+	return updateRecord.Results.Status, nil
+}
+
+// GetUpdateResults returns the status of an update along with a page of its console output.
+func (p *Service) GetUpdateResults(ctx context.Context, identifier client.UpdateIdentifier, opts ...ListEngineEventOptions) (*apitype.UpdateResults, error) {
+	updateRecord, err := readUpdateRecord(ctx, p.store, identifier)
 	if err != nil {
 		return nil, err
 	}
 
+	// only stdout and diagnostics have an UpdateEvent representation
+	opts = append(opts, ListEngineEventOptions{Types: []string{"stdout", "diagnostic"}})
+
+	events, continuationToken, err := p.listEngineEventsPage(ctx, identifier, updateRecord.Results.Status, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	updateEvents := []apitype.UpdateEvent{}
+
+	for _, event := range events {
+		if updateEvent := createUpdateEvent(event); updateEvent != nil {
+			updateEvents = append(updateEvents, *updateEvent)
+		}
+	}
+
 	return &apitype.UpdateResults{
-		Status: updateRecord.Results.Status,
-		Events: []apitype.UpdateEvent{},
+		Status:            updateRecord.Results.Status,
+		Events:            updateEvents,
+		ContinuationToken: continuationToken,
 	}, nil
 }
 
-func (p *Service) CheckpointUpdate(identifier client.UpdateIdentifier, checkpoint *apitype.VersionedCheckpoint) error {
+func (p *Service) CheckpointUpdate(ctx context.Context, identifier client.UpdateIdentifier, checkpoint *apitype.VersionedCheckpoint) error {
 	checkpointRecord := model.CheckpointRecord{
 		UpdateID:   identifier.UpdateID,
 		Checkpoint: checkpoint,
 	}
 
-	err := p.store.Update(&checkpointRecord)
+	err := p.store.Update(ctx, &checkpointRecord)
 	if err != nil {
 		return err
 	}
 
+	metrics.CheckpointSaved(len(checkpoint.Checkpoint))
+
 	return nil
 }
 
-func (p *Service) AddEngineEvents(identifier client.UpdateIdentifier, events []apitype.EngineEvent) error {
-	if err := p.store.Transaction(func(s *store.Postgres) error {
-		for _, event := range events {
-			eventRecord := model.EngineEventRecord{
-				UpdateID:    identifier.UpdateID,
-				Sequence:    event.Sequence,
-				EngineEvent: &event,
-			}
+// AddEngineEvents stores a batch of engine events. Batches may arrive out of order and may be
+// retried, so events that were already stored are ignored.
+func (p *Service) AddEngineEvents(ctx context.Context, identifier client.UpdateIdentifier, events []apitype.EngineEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
 
-			if err := s.Create(&eventRecord); err != nil {
-				return err
-			}
-		}
+	// sorting gives concurrent batches a consistent lock order and keeps inserts index-friendly
+	sorted := slices.Clone(events)
+	slices.SortFunc(sorted, func(a, b apitype.EngineEvent) int {
+		return cmp.Compare(a.Sequence, b.Sequence)
+	})
 
-		return nil
-	}); err != nil {
+	sorted = slices.CompactFunc(sorted, func(a, b apitype.EngineEvent) bool {
+		return a.Sequence == b.Sequence
+	})
+
+	eventRecords := make([]*model.EngineEventRecord, len(sorted))
+	for i := range sorted {
+		eventRecords[i] = model.NewEngineEventRecord(identifier.UpdateID, &sorted[i])
+	}
+
+	if err := p.store.CreateIgnoreExisting(ctx, eventRecords); err != nil {
 		return err
 	}
 
+	metrics.EngineEventsReceived(len(eventRecords))
+
+	p.events.notify(identifier.UpdateID)
+
 	return nil
 }
 
-func (p *Service) ListEngineEvents(identifier client.UpdateIdentifier) ([]apitype.EngineEvent, error) {
+func (p *Service) ListEngineEvents(ctx context.Context, identifier client.UpdateIdentifier, opts ...ListEngineEventOptions) ([]apitype.EngineEvent, error) {
+	o, err := util.Merge(ListEngineEventOptions{}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	options := []store.DBOption{
+		store.Where(model.EngineEventRecord{UpdateID: identifier.UpdateID, URN: o.URN}),
+		store.Limit(o.PageSize),
+	}
+
+	if o.AfterSequence != nil {
+		options = append(options, store.Where("sequence > ?", *o.AfterSequence))
+	}
+
+	if len(o.Types) > 0 {
+		options = append(options, store.Where("type IN ?", o.Types))
+	}
+
+	if len(o.Severities) > 0 {
+		options = append(options, store.Where("severity IN ?", o.Severities))
+	}
+
 	eventRecords := []model.EngineEventRecord{}
 
-	if err := p.store.List(&eventRecords, store.Where(model.EngineEventRecord{UpdateID: identifier.UpdateID})); err != nil {
+	if err := p.store.List(ctx, &eventRecords, options...); err != nil {
 		return nil, err
 	}
 
@@ -298,17 +389,78 @@ func (p *Service) ListEngineEvents(identifier client.UpdateIdentifier) ([]apityp
 	return events, nil
 }
 
-func (p *Service) CreateImport(identifier client.UpdateIdentifier, deployment *apitype.UntypedDeployment) (string, error) {
+// GetEngineEvents returns a page of engine events for an update. The continuation token is nil
+// once the update is complete and every matching event has been returned.
+func (p *Service) GetEngineEvents(ctx context.Context, identifier client.UpdateIdentifier, opts ...ListEngineEventOptions) (*apitype.GetUpdateEventsResponse, error) {
+	updateRecord, err := readUpdateRecord(ctx, p.store, identifier)
+	if err != nil {
+		return nil, err
+	}
+
+	events, continuationToken, err := p.listEngineEventsPage(ctx, identifier, updateRecord.Results.Status, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &apitype.GetUpdateEventsResponse{
+		Events:            events,
+		ContinuationToken: continuationToken,
+	}, nil
+}
+
+// listEngineEventsPage must be given the update status as read before the events, otherwise
+// events written just before completion could be skipped.
+func (p *Service) listEngineEventsPage(ctx context.Context, identifier client.UpdateIdentifier, status apitype.UpdateStatus, opts []ListEngineEventOptions) ([]apitype.EngineEvent, *string, error) {
+	o, err := util.Merge(ListEngineEventOptions{}, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	events, err := p.ListEngineEvents(ctx, identifier, o)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lastSequence := -1
+	if o.AfterSequence != nil {
+		lastSequence = *o.AfterSequence
+	}
+
+	if len(events) > 0 {
+		lastSequence = events[len(events)-1].Sequence
+	}
+
+	if model.IsUpdateComplete(status) && (o.PageSize == 0 || len(events) < o.PageSize) {
+		return events, nil, nil
+	}
+
+	return events, continuationToken(lastSequence), nil
+}
+
+// SubscribeEngineEvents returns a channel that receives a value whenever new engine events are
+// added to the update or the update completes, and is closed when the service shuts down. The
+// returned function must be called to release the subscription.
+func (p *Service) SubscribeEngineEvents(identifier client.UpdateIdentifier) (<-chan struct{}, func()) {
+	return p.events.subscribe(identifier.UpdateID)
+}
+
+// CloseSubscriptions closes all engine event subscriptions, so that streams end instead of holding
+// up shutdown.
+func (p *Service) CloseSubscriptions() {
+	p.events.close()
+}
+
+func (p *Service) CreateImport(ctx context.Context, identifier client.UpdateIdentifier, deployment *apitype.UntypedDeployment) (string, error) {
 	// TODO - fail update on errors
 	// TODO - get user
-	updateID, err := p.CreateUpdate(identifier, nil, nil, nil, nil, nil)
+	updateID, err := p.CreateUpdate(ctx, identifier, nil, nil, nil, nil, nil)
 	if err != nil {
 		return "", err
 	}
 
 	identifier.UpdateID = *updateID
 
-	if _, err := p.StartUpdate(identifier); err != nil {
+	if _, err := p.StartUpdate(ctx, identifier); err != nil {
 		return "", err
 	}
 
@@ -317,19 +469,19 @@ func (p *Service) CreateImport(identifier client.UpdateIdentifier, deployment *a
 		Checkpoint: deployment.Deployment,
 	}
 
-	if err := p.CheckpointUpdate(identifier, checkpoint); err != nil {
+	if err := p.CheckpointUpdate(ctx, identifier, checkpoint); err != nil {
 		return "", err
 	}
 
-	if _, err := p.CompleteUpdate(identifier, apitype.StatusSucceeded); err != nil {
+	if _, err := p.CompleteUpdate(ctx, identifier, apitype.StatusSucceeded); err != nil {
 		return "", err
 	}
 
 	return *updateID, nil
 }
 
-func (p *Service) GetPreviewsCount(identifier client.StackIdentifier, version string) (int64, error) {
-	stackRecord, err := readStackRecord(p.store, identifier)
+func (p *Service) GetPreviewsCount(ctx context.Context, identifier client.StackIdentifier, version string) (int64, error) {
+	stackRecord, err := readStackRecord(ctx, p.store, identifier)
 	if err != nil {
 		return -1, err
 	}
@@ -339,7 +491,7 @@ func (p *Service) GetPreviewsCount(identifier client.StackIdentifier, version st
 	if version == "latest" && stackRecord.Stack.Version == 0 {
 		versionNumber = 1
 	} else {
-		update, err := p.GetStackUpdate(identifier, version)
+		update, err := p.GetStackUpdate(ctx, identifier, version)
 		if err != nil {
 			return -1, err
 		}
@@ -347,20 +499,20 @@ func (p *Service) GetPreviewsCount(identifier client.StackIdentifier, version st
 		versionNumber = update.Version + 1
 	}
 
-	return p.store.Count(&model.UpdateRecord{
+	return p.store.Count(ctx, &model.UpdateRecord{
 		StackID: stackRecord.ID,
 		Version: versionNumber,
 		DryRun:  util.Ptr(true),
 	})
 }
 
-func (p *Service) ListPreviews(identifier client.StackIdentifier, version string, opts ...ListUpdateOptions) ([]model.StackUpdate, error) {
+func (p *Service) ListPreviews(ctx context.Context, identifier client.StackIdentifier, version string, opts ...ListUpdateOptions) ([]model.StackUpdate, error) {
 	o, err := util.Merge(ListUpdateOptions{}, opts)
 	if err != nil {
 		return nil, err
 	}
 
-	stackRecord, err := readStackRecord(p.store, identifier)
+	stackRecord, err := readStackRecord(ctx, p.store, identifier)
 	if err != nil {
 		return nil, err
 	}
@@ -370,7 +522,7 @@ func (p *Service) ListPreviews(identifier client.StackIdentifier, version string
 	if version == "latest" && stackRecord.Stack.Version == 0 {
 		versionNumber = 1
 	} else {
-		update, err := p.GetStackUpdate(identifier, version)
+		update, err := p.GetStackUpdate(ctx, identifier, version)
 		if err != nil {
 			return nil, err
 		}
@@ -386,7 +538,7 @@ func (p *Service) ListPreviews(identifier client.StackIdentifier, version string
 
 	updateRecords := &[]model.UpdateRecord{{}}
 
-	if err := p.store.List(
+	if err := p.store.List(ctx,
 		updateRecords,
 		store.Join(model.ServiceUserInfo{}),
 		store.Where(condition),
@@ -411,12 +563,24 @@ func (p *Service) ListPreviews(identifier client.StackIdentifier, version string
 	return updates, nil
 }
 
-func readUpdateRecord(s *store.Postgres, id string) (*model.UpdateRecord, error) {
+// readUpdateRecord reads an update of the stack in identifier, so that knowing an update's ID
+// doesn't reach it through another stack's path. Updates of other stacks are not found.
+func readUpdateRecord(ctx context.Context, s *store.Postgres, identifier client.UpdateIdentifier) (*model.UpdateRecord, error) {
+	stackRecord, err := readStackRecord(ctx, s, identifier.StackIdentifier)
+	if err != nil {
+		return nil, err
+	}
+
+	return readStackUpdateRecord(ctx, s, stackRecord.ID, identifier.UpdateID)
+}
+
+func readStackUpdateRecord(ctx context.Context, s *store.Postgres, stackID string, id string) (*model.UpdateRecord, error) {
 	updateRecord := model.UpdateRecord{
-		ID: id,
+		ID:      id,
+		StackID: stackID,
 	}
 
-	err := s.Read(&updateRecord)
+	err := s.Read(ctx, &updateRecord)
 	if err != nil {
 		return nil, err
 	}
@@ -442,7 +606,7 @@ func createUpdateInfo(updateRecord *model.UpdateRecord) apitype.UpdateInfo {
 func createStackUpdate(stackRecord *model.StackRecord, updateRecord *model.UpdateRecord) *model.StackUpdate {
 	return &model.StackUpdate{
 		Info:        createUpdateInfo(updateRecord),
-		RequestedBy: updateRecord.RequestedBy,
+		RequestedBy: requestedBy(updateRecord),
 		GetDeploymentUpdatesUpdateInfo: apitype.GetDeploymentUpdatesUpdateInfo{
 			UpdateID:      updateRecord.ID,
 			Version:       updateRecord.Version,
@@ -451,8 +615,79 @@ func createStackUpdate(stackRecord *model.StackRecord, updateRecord *model.Updat
 	}
 }
 
+// requestedBy returns who requested an update, a user or a team or organization token.
+func requestedBy(updateRecord *model.UpdateRecord) *model.ServiceUserInfo {
+	if updateRecord.RequestedBy != nil {
+		return updateRecord.RequestedBy
+	}
+
+	return updateRecord.Requester
+}
+
 type ListUpdateOptions struct {
 	PageSize   int
 	Page       int
 	Descending bool
 }
+
+type ListEngineEventOptions struct {
+	AfterSequence *int
+	PageSize      int
+	// Types are event types as returned by model.EngineEventType
+	Types []string
+	URN   string
+	// Severities match diagnostic severities and policy enforcement levels
+	Severities []string
+}
+
+// ParseContinuationToken returns the sequence number encoded in a continuation token.
+func ParseContinuationToken(token string) (*int, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, errs.New(errs.ErrInvalidArgument, "invalid continuation token")
+	}
+
+	sequence, err := strconv.Atoi(string(decoded))
+	if err != nil {
+		return nil, errs.New(errs.ErrInvalidArgument, "invalid continuation token")
+	}
+
+	return &sequence, nil
+}
+
+func continuationToken(sequence int) *string {
+	token := base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(sequence)))
+	return &token
+}
+
+func createUpdateEvent(event apitype.EngineEvent) *apitype.UpdateEvent {
+	index := strconv.Itoa(event.Sequence)
+
+	switch {
+	case event.StdoutEvent != nil:
+		return &apitype.UpdateEvent{
+			Index: index,
+			Kind:  apitype.StdoutEvent,
+			Fields: map[string]interface{}{
+				"text":  event.StdoutEvent.Message,
+				"color": event.StdoutEvent.Color,
+			},
+		}
+	case event.DiagnosticEvent != nil:
+		kind := apitype.StdoutEvent
+		if event.DiagnosticEvent.Severity == "error" || event.DiagnosticEvent.Severity == "info#err" {
+			kind = apitype.StderrEvent
+		}
+
+		return &apitype.UpdateEvent{
+			Index: index,
+			Kind:  kind,
+			Fields: map[string]interface{}{
+				"text":  event.DiagnosticEvent.Prefix + event.DiagnosticEvent.Message,
+				"color": event.DiagnosticEvent.Color,
+			},
+		}
+	}
+
+	return nil
+}
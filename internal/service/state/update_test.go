@@ -0,0 +1,159 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/pulumi/pulumi/pkg/v3/backend/httpstate/client"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/tokens"
+	"github.com/tinkerborg/open-pulumi-service/internal/errs"
+	"github.com/tinkerborg/open-pulumi-service/internal/model"
+	"github.com/tinkerborg/open-pulumi-service/internal/store"
+)
+
+func TestParseContinuationToken(t *testing.T) {
+	tests := []struct {
+		name  string
+		token string
+		want  int
+		err   error
+	}{
+		{name: "issued token", token: *continuationToken(42), want: 42},
+		{name: "zero", token: *continuationToken(0), want: 0},
+		{name: "not base64", token: "!!", err: errs.ErrInvalidArgument},
+		{name: "not a number", token: "YWJj", err: errs.ErrInvalidArgument},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sequence, err := ParseContinuationToken(test.token)
+
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("got error %v, want %v", err, test.err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *sequence != test.want {
+				t.Errorf("got %d, want %d", *sequence, test.want)
+			}
+		})
+	}
+}
+
+func TestEngineEventColumnsMigration(t *testing.T) {
+	s := store.NewTestPostgres(t)
+	ctx := context.Background()
+
+	if _, err := New(s); err != nil {
+		t.Fatal(err)
+	}
+
+	events := []apitype.EngineEvent{
+		{Sequence: 1, SummaryEvent: &apitype.SummaryEvent{}},
+		{Sequence: 2, DiagnosticEvent: &apitype.DiagnosticEvent{URN: "urn:a", Severity: "warning"}},
+		{Sequence: 3, ResOutputsEvent: &apitype.ResOutputsEvent{Metadata: apitype.StepEventMetadata{URN: "urn:b"}}},
+	}
+
+	for _, event := range events {
+		record := model.EngineEventRecord{UpdateID: "update", Sequence: event.Sequence, EngineEvent: &event}
+		if err := s.Create(ctx, &record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// rows stored before the columns existed
+	if err := s.Raw(ctx, &[]model.EngineEventRecord{}, "UPDATE engine_event_record SET type = NULL, urn = NULL, severity = NULL RETURNING *"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Migrate(ctx, store.Migration{Name: "test", SQL: migrations[0].SQL}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, event := range events {
+		want := model.NewEngineEventRecord("update", &event)
+
+		record := model.EngineEventRecord{UpdateID: "update", Sequence: event.Sequence}
+		if err := s.Read(ctx, &record); err != nil {
+			t.Fatal(err)
+		}
+
+		if record.Type != want.Type || record.URN != want.URN || record.Severity != want.Severity {
+			t.Errorf("event %d: got (%s, %s, %s), want (%s, %s, %s)", event.Sequence,
+				record.Type, record.URN, record.Severity, want.Type, want.URN, want.Severity)
+		}
+	}
+}
+
+func TestUpdateOfOtherStack(t *testing.T) {
+	s := store.NewTestPostgres(t)
+	ctx := context.Background()
+
+	p, err := New(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := &model.ServiceUser{GitHubLogin: "alice", Email: "alice@example.com"}
+	if err := p.CreateUser(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	stacks := map[string]client.StackIdentifier{}
+	for _, name := range []string{"mine", "theirs"} {
+		if err := p.CreateStack(ctx, &apitype.Stack{OrgName: "alice", ProjectName: "project", StackName: tokens.QName(name)}); err != nil {
+			t.Fatal(err)
+		}
+		stackName, _ := tokens.ParseStackName(name)
+		stacks[name] = client.StackIdentifier{Owner: "alice", Project: "project", Stack: stackName}
+	}
+
+	updateID, err := p.CreateUpdate(ctx, client.UpdateIdentifier{StackIdentifier: stacks["theirs"], UpdateKind: apitype.UpdateUpdate}, nil, nil, nil, nil, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		stack string
+		call  func(identifier client.UpdateIdentifier) error
+	}{
+		{name: "status", call: func(identifier client.UpdateIdentifier) error {
+			_, err := p.GetUpdateStatus(ctx, identifier)
+			return err
+		}},
+		{name: "results", call: func(identifier client.UpdateIdentifier) error {
+			_, err := p.GetUpdateResults(ctx, identifier)
+			return err
+		}},
+		{name: "events", call: func(identifier client.UpdateIdentifier) error {
+			_, err := p.GetEngineEvents(ctx, identifier)
+			return err
+		}},
+		{name: "start", call: func(identifier client.UpdateIdentifier) error {
+			_, err := p.StartUpdate(ctx, identifier)
+			return err
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			other := client.UpdateIdentifier{StackIdentifier: stacks["mine"], UpdateKind: apitype.UpdateUpdate, UpdateID: *updateID}
+			if err := test.call(other); !errors.Is(err, errs.ErrNotFound) {
+				t.Fatalf("through another stack: want ErrNotFound, got %v", err)
+			}
+
+			own := client.UpdateIdentifier{StackIdentifier: stacks["theirs"], UpdateKind: apitype.UpdateUpdate, UpdateID: *updateID}
+			if err := test.call(own); err != nil {
+				t.Fatalf("through its own stack: %v", err)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package state
 
 import (
+	"context"
 	"errors"
 
 	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
@@ -10,15 +11,35 @@ import (
 	"gorm.io/gorm"
 )
 
-func (p *Service) GetUser(userID string) (*model.ServiceUser, error) {
+func (p *Service) GetUser(ctx context.Context, userID string) (*model.ServiceUser, error) {
 	user := &model.ServiceUser{
 		ID: userID,
 	}
 
-	if err := p.store.Read(user); err != nil {
+	if err := p.store.Read(ctx, user); err != nil {
 		return nil, err
 	}
 
+	organizations, err := p.ListUserOrganizations(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Organizations = []model.ServiceUserInfo{}
+	for _, organization := range organizations {
+		user.Organizations = append(user.Organizations, organization.Info())
+	}
+
+	identities, err := p.ListUserIdentities(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Identities = []string{}
+	for _, identity := range identities {
+		user.Identities = append(user.Identities, identity.Provider)
+	}
+
 	return user, nil
 	// return &model.ServiceUser{
 	// 	ID:          "tinkerborg",
@@ -39,42 +60,40 @@ func (p *Service) GetUser(userID string) (*model.ServiceUser, error) {
 	// }, nil
 }
 
-func (p *Service) GetUserByName(username string) (*model.ServiceUser, error) {
-	user := &model.ServiceUser{
-		GitHubLogin: username,
-	}
-
-	if err := p.store.Read(user); err != nil {
-		return nil, err
-	}
-
-	return user, nil
+func (p *Service) GetUserByName(ctx context.Context, username string) (*model.ServiceUser, error) {
+	return readUserByLogin(ctx, p.store, username)
 }
 
-func (p *Service) GetUserByEmail(email string) (*model.ServiceUser, error) {
+func (p *Service) GetUserByEmail(ctx context.Context, email string) (*model.ServiceUser, error) {
 	user := &model.ServiceUser{
 		Email: email,
 	}
 
-	if err := p.store.Read(user); err != nil {
+	if err := p.store.Read(ctx, user); err != nil {
 		return nil, err
 	}
 
 	return user, nil
 }
 
-func (p *Service) CreateUser(user *model.ServiceUser) error {
-	if err := p.store.Create(&user); err != nil {
-		if errors.Is(err, gorm.ErrDuplicatedKey) {
-			return store.ErrExist
+func (p *Service) CreateUser(ctx context.Context, user *model.ServiceUser) error {
+	return p.store.Transaction(ctx, func(s *store.Postgres) error {
+		if err := ensureNoOrganization(ctx, s, user.GitHubLogin); err != nil {
+			return err
 		}
-		return err
-	}
 
-	return nil
+		if err := s.Create(ctx, &user); err != nil {
+			if errors.Is(err, gorm.ErrDuplicatedKey) {
+				return store.ErrExist
+			}
+			return err
+		}
+
+		return nil
+	})
 }
 
-func (p *Service) ListUserStacks(conditions ...model.StackRecord) ([]apitype.StackSummary, error) {
+func (p *Service) ListUserStacks(ctx context.Context, conditions ...model.StackRecord) ([]apitype.StackSummary, error) {
 	condition, err := util.Merge(model.StackRecord{}, conditions)
 	if err != nil {
 		return nil, err
@@ -82,7 +101,7 @@ func (p *Service) ListUserStacks(conditions ...model.StackRecord) ([]apitype.Sta
 
 	stackRecords := []model.StackRecord{}
 
-	if err := p.store.List(&stackRecords, store.Where(condition)); err != nil {
+	if err := p.store.List(ctx, &stackRecords, store.Where(condition)); err != nil {
 		return nil, err
 	}
 
@@ -101,7 +120,7 @@ func (p *Service) ListUserStacks(conditions ...model.StackRecord) ([]apitype.Sta
 
 		if record.ActiveUpdate != "" {
 
-			update, err := readUpdateRecord(p.store, record.ActiveUpdate)
+			update, err := readStackUpdateRecord(ctx, p.store, stackRecord.ID, record.ActiveUpdate)
 			if err != nil && !errors.Is(err, store.ErrNotFound) {
 				return nil, err
 			}
@@ -121,3 +140,17 @@ func (p *Service) ListUserStacks(conditions ...model.StackRecord) ([]apitype.Sta
 
 	return summaries, nil
 }
+
+// readUserByLogin reads a person by login. Service accounts are named within their organization
+// and read with readOrganizationUser.
+func readUserByLogin(ctx context.Context, s *store.Postgres, login string) (*model.ServiceUser, error) {
+	user := &model.ServiceUser{
+		GitHubLogin: login,
+	}
+
+	if err := s.Read(ctx, user, store.Where("service_account_org_id IS NULL")); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
@@ -0,0 +1,257 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/pulumi/pulumi/pkg/v3/backend/httpstate/client"
+	"github.com/tinkerborg/open-pulumi-service/internal/errs"
+	"github.com/tinkerborg/open-pulumi-service/internal/model"
+	"github.com/tinkerborg/open-pulumi-service/internal/service/webhook"
+	"github.com/tinkerborg/open-pulumi-service/internal/store"
+)
+
+// Webhooks are scoped by a stack identifier. Organization webhooks only set the owner.
+
+func (p *Service) CreateWebhook(ctx context.Context, scope client.StackIdentifier, hook *model.WebhookRecord) error {
+	if err := validateWebhook(hook); err != nil {
+		return err
+	}
+
+	organization, err := readOrganizationRecord(ctx, p.store, scope.Owner)
+	if err != nil {
+		return err
+	}
+
+	if scope.Project != "" {
+		if _, err := readStackRecord(ctx, p.store, scope); err != nil {
+			return err
+		}
+	}
+
+	hook.OrganizationID = organization.ID
+	hook.ProjectName = scope.Project
+	hook.StackName = scope.Stack.String()
+
+	return p.store.Create(ctx, hook)
+}
+
+func (p *Service) GetWebhook(ctx context.Context, scope client.StackIdentifier, name string) (*model.WebhookRecord, error) {
+	return readWebhookRecord(ctx, p.store, scope, name)
+}
+
+func (p *Service) ListWebhooks(ctx context.Context, scope client.StackIdentifier) ([]model.WebhookRecord, error) {
+	organization, err := readOrganizationRecord(ctx, p.store, scope.Owner)
+	if err != nil {
+		return nil, err
+	}
+
+	hooks := []model.WebhookRecord{}
+
+	if err := p.store.List(ctx, &hooks, webhookScope(organization, scope)); err != nil {
+		return nil, err
+	}
+
+	return hooks, nil
+}
+
+// UpdateWebhook replaces everything but the name of a webhook. An empty secret keeps the current
+// secret.
+func (p *Service) UpdateWebhook(ctx context.Context, scope client.StackIdentifier, hook *model.WebhookRecord) error {
+	if err := validateWebhook(hook); err != nil {
+		return err
+	}
+
+	return p.store.Transaction(ctx, func(s *store.Postgres) error {
+		existing, err := readWebhookRecord(ctx, s, scope, hook.Name)
+		if err != nil {
+			return err
+		}
+
+		existing.DisplayName = hook.DisplayName
+		existing.PayloadURL = hook.PayloadURL
+		existing.Active = hook.Active
+		existing.Format = hook.Format
+		existing.Filters = hook.Filters
+
+		if hook.Secret != "" {
+			existing.Secret = hook.Secret
+		}
+
+		if err := s.Update(ctx, existing); err != nil {
+			return err
+		}
+
+		*hook = *existing
+
+		return nil
+	})
+}
+
+func (p *Service) DeleteWebhook(ctx context.Context, scope client.StackIdentifier, name string) error {
+	hook, err := readWebhookRecord(ctx, p.store, scope, name)
+	if err != nil {
+		return err
+	}
+
+	return p.store.Delete(ctx, hook)
+}
+
+func (p *Service) deleteStackWebhooks(ctx context.Context, identifier client.StackIdentifier) error {
+	organization, err := readOrganizationRecord(ctx, p.store, identifier.Owner)
+	if errors.Is(err, store.ErrNotFound) {
+		// stacks owned by users have no webhooks
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return p.store.Delete(ctx, &model.WebhookRecord{}, webhookScope(organization, identifier))
+}
+
+// ListWebhookDeliveries returns the most recent deliveries to a webhook, newest first.
+func (p *Service) ListWebhookDeliveries(ctx context.Context, scope client.StackIdentifier, name string, limit int) ([]model.WebhookDeliveryRecord, error) {
+	hook, err := readWebhookRecord(ctx, p.store, scope, name)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := []model.WebhookDeliveryRecord{}
+
+	if err := p.store.List(ctx, &deliveries,
+		store.Where(model.WebhookDeliveryRecord{WebhookID: hook.ID}),
+		store.OrderBy("created_at"),
+		store.Descending(),
+		store.Limit(limit),
+	); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// PingWebhook queues a test delivery to a webhook, whether or not it is active.
+func (p *Service) PingWebhook(ctx context.Context, scope client.StackIdentifier, name string) error {
+	hook, err := readWebhookRecord(ctx, p.store, scope, name)
+	if err != nil {
+		return err
+	}
+
+	return p.webhooks.Enqueue(ctx, []model.WebhookRecord{*hook}, webhook.PingEvent(scope.Owner))
+}
+
+// notifyWebhooks queues an event for the active webhooks of the stack and its organization whose
+// filters match. Failing to queue is logged rather than failing the operation that caused it.
+func (p *Service) notifyWebhooks(ctx context.Context, identifier client.StackIdentifier, event *webhook.Event) {
+	if p.webhooks == nil {
+		return
+	}
+
+	if err := p.queueWebhooks(ctx, identifier, event); err != nil {
+		slog.ErrorContext(ctx, "failed to queue webhook event", "event", event.Name, "error", err)
+	}
+}
+
+// notifyUpdateWebhooks queues an event for an update starting or finishing.
+func (p *Service) notifyUpdateWebhooks(ctx context.Context, identifier client.UpdateIdentifier, updateRecord *model.UpdateRecord, result string) {
+	if p.webhooks == nil {
+		return
+	}
+
+	payload := &webhook.UpdatePayload{
+		Organization: webhook.Organization{GitHubLogin: identifier.Owner},
+		ProjectName:  identifier.Project,
+		StackName:    identifier.Stack.String(),
+		UpdateID:     updateRecord.ID,
+		Version:      updateRecord.Version,
+		Kind:         updateRecord.Kind,
+		Result:       result,
+	}
+
+	if result != webhook.ResultStarted {
+		payload.ResourceChanges = updateRecord.ResourceChanges
+	}
+
+	if updateRecord.UserID != nil {
+		user := &model.ServiceUserInfo{ID: *updateRecord.UserID}
+		if p.store.Read(ctx, user) == nil {
+			payload.User = &webhook.User{GitHubLogin: user.GitHubLogin, Name: user.Name}
+		}
+	} else if requester := updateRecord.Requester; requester != nil {
+		payload.User = &webhook.User{GitHubLogin: requester.GitHubLogin, Name: requester.Name}
+	}
+
+	p.notifyWebhooks(ctx, identifier.StackIdentifier, webhook.UpdateEvent(payload))
+}
+
+func (p *Service) queueWebhooks(ctx context.Context, identifier client.StackIdentifier, event *webhook.Event) error {
+	organization, err := readOrganizationRecord(ctx, p.store, identifier.Owner)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	hooks := []model.WebhookRecord{}
+
+	if err := p.store.List(ctx, &hooks,
+		store.Where(model.WebhookRecord{OrganizationID: organization.ID, Active: true}),
+		store.Where("(project_name = '' AND stack_name = '') OR (project_name = ? AND stack_name = ?)", identifier.Project, identifier.Stack.String()),
+	); err != nil {
+		return err
+	}
+
+	matching := []model.WebhookRecord{}
+	for _, hook := range hooks {
+		if event.Matches(hook.Filters) {
+			matching = append(matching, hook)
+		}
+	}
+
+	return p.webhooks.Enqueue(ctx, matching, event)
+}
+
+func validateWebhook(hook *model.WebhookRecord) error {
+	if !validName.MatchString(hook.Name) {
+		return errs.Errorf(errs.ErrInvalidArgument, "invalid webhook name '%s'", hook.Name)
+	}
+
+	if err := webhook.ValidatePayloadURL(hook.PayloadURL); err != nil {
+		return err
+	}
+
+	if hook.Format == "" {
+		hook.Format = webhook.FormatRaw
+	}
+
+	if err := webhook.ValidateFormat(hook.Format); err != nil {
+		return err
+	}
+
+	return webhook.ValidateFilters(hook.Filters)
+}
+
+// webhookScope selects the webhooks of exactly one scope; struct conditions would ignore the empty
+// project and stack names of organization webhooks.
+func webhookScope(organization *model.OrganizationRecord, scope client.StackIdentifier) store.DBOption {
+	return store.Where("organization_id = ? AND project_name = ? AND stack_name = ?", organization.ID, scope.Project, scope.Stack.String())
+}
+
+func readWebhookRecord(ctx context.Context, s *store.Postgres, scope client.StackIdentifier, name string) (*model.WebhookRecord, error) {
+	organization, err := readOrganizationRecord(ctx, s, scope.Owner)
+	if err != nil {
+		return nil, err
+	}
+
+	hook := &model.WebhookRecord{
+		OrganizationID: organization.ID,
+		Name:           name,
+	}
+
+	if err := s.Read(ctx, hook, webhookScope(organization, scope)); err != nil {
+		return nil, err
+	}
+
+	return hook, nil
+}
@@ -0,0 +1,34 @@
+package webhook
+
+import (
+	"net/netip"
+	"net/url"
+	"strings"
+
+	"github.com/tinkerborg/open-pulumi-service/internal/errs"
+	"github.com/tinkerborg/open-pulumi-service/internal/util"
+)
+
+// maxDiscardedBody is how much of a response is read to let its connection be reused
+const maxDiscardedBody = 64 * 1024
+
+// ValidatePayloadURL checks that a payload URL is an http or https URL. Hosts given as internal
+// addresses are rejected up front; names are checked once resolved, when deliveries are sent.
+func ValidatePayloadURL(payloadURL string) error {
+	parsed, err := url.Parse(payloadURL)
+	if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+		return errs.New(errs.ErrInvalidArgument, "payload url must be an http or https url")
+	}
+
+	host := parsed.Hostname()
+
+	if strings.EqualFold(host, "localhost") || strings.HasSuffix(strings.ToLower(host), ".localhost") {
+		return errs.New(errs.ErrInvalidArgument, "payload url must not point to localhost")
+	}
+
+	if addr, err := netip.ParseAddr(host); err == nil && !util.PublicAddress(addr) {
+		return errs.Errorf(errs.ErrInvalidArgument, "payload url must not point to the non-public address %s", host)
+	}
+
+	return nil
+}
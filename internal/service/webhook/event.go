@@ -0,0 +1,181 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+	"github.com/tinkerborg/open-pulumi-service/internal/errs"
+)
+
+const (
+	FormatRaw     = "raw"
+	FormatSlack   = "slack"
+	FormatMSTeams = "ms_teams"
+)
+
+const (
+	KindStack  = "stack"
+	KindUpdate = "update"
+	KindPing   = "ping"
+)
+
+const (
+	EventStackCreated = "stack_created"
+	EventStackDeleted = "stack_deleted"
+	EventPing         = "ping"
+
+	ResultStarted   = "started"
+	ResultSucceeded = "succeeded"
+	ResultFailed    = "failed"
+)
+
+var updateKinds = []apitype.UpdateKind{
+	apitype.UpdateUpdate,
+	apitype.PreviewUpdate,
+	apitype.RefreshUpdate,
+	apitype.DestroyUpdate,
+	apitype.StackImportUpdate,
+}
+
+// Event is something webhooks are notified of.
+type Event struct {
+	// Name is what webhook filters match, such as "stack_created" or "update_failed"
+	Name    string
+	Kind    string
+	Payload any
+	// Summary is the message sent to chat formats
+	Summary string
+}
+
+type Organization struct {
+	GitHubLogin string `json:"githubLogin"`
+}
+
+type User struct {
+	GitHubLogin string `json:"githubLogin"`
+	Name        string `json:"name,omitempty"`
+}
+
+type StackPayload struct {
+	Organization Organization `json:"organization"`
+	Action       string       `json:"action"`
+	ProjectName  string       `json:"projectName"`
+	StackName    string       `json:"stackName"`
+}
+
+type UpdatePayload struct {
+	Organization    Organization           `json:"organization"`
+	User            *User                  `json:"user,omitempty"`
+	ProjectName     string                 `json:"projectName"`
+	StackName       string                 `json:"stackName"`
+	UpdateID        string                 `json:"updateId"`
+	Version         int                    `json:"version"`
+	Kind            apitype.UpdateKind     `json:"kind"`
+	Result          string                 `json:"result"`
+	ResourceChanges map[apitype.OpType]int `json:"resourceChanges,omitempty"`
+}
+
+type PingPayload struct {
+	Organization Organization `json:"organization"`
+	Message      string       `json:"message"`
+}
+
+func StackEvent(name string, payload *StackPayload) *Event {
+	return &Event{
+		Name:    name,
+		Kind:    KindStack,
+		Payload: payload,
+		Summary: fmt.Sprintf("Stack %s/%s/%s was %s", payload.Organization.GitHubLogin, payload.ProjectName, payload.StackName, payload.Action),
+	}
+}
+
+// UpdateEvent is named after the update kind and result, such as "preview_succeeded".
+func UpdateEvent(payload *UpdatePayload) *Event {
+	summary := fmt.Sprintf("%s of %s/%s/%s %s", payload.Kind, payload.Organization.GitHubLogin, payload.ProjectName, payload.StackName, payload.Result)
+	if payload.User != nil {
+		summary += " (requested by " + payload.User.GitHubLogin + ")"
+	}
+
+	return &Event{
+		Name:    string(payload.Kind) + "_" + payload.Result,
+		Kind:    KindUpdate,
+		Payload: payload,
+		Summary: strings.ToUpper(summary[:1]) + summary[1:],
+	}
+}
+
+func PingEvent(organization string) *Event {
+	return &Event{
+		Name: EventPing,
+		Kind: KindPing,
+		Payload: &PingPayload{
+			Organization: Organization{GitHubLogin: organization},
+			Message:      "Test webhook delivery",
+		},
+		Summary: "Test webhook delivery from " + organization,
+	}
+}
+
+// UpdateResult maps the status an update completes with to the result webhooks see.
+func UpdateResult(status apitype.UpdateStatus) string {
+	if status == apitype.StatusSucceeded {
+		return ResultSucceeded
+	}
+	return ResultFailed
+}
+
+// Events lists the event names webhooks can filter on.
+func Events() []string {
+	events := []string{EventStackCreated, EventStackDeleted}
+
+	for _, kind := range updateKinds {
+		for _, result := range []string{ResultStarted, ResultSucceeded, ResultFailed} {
+			events = append(events, string(kind)+"_"+result)
+		}
+	}
+
+	return events
+}
+
+func ValidateFormat(format string) error {
+	switch format {
+	case FormatRaw, FormatSlack, FormatMSTeams:
+		return nil
+	}
+	return errs.Errorf(errs.ErrInvalidArgument, "invalid webhook format '%s'", format)
+}
+
+func ValidateFilters(filters []string) error {
+	events := Events()
+
+	for _, filter := range filters {
+		if !slices.Contains(events, filter) {
+			return errs.Errorf(errs.ErrInvalidArgument, "invalid webhook filter '%s'", filter)
+		}
+	}
+
+	return nil
+}
+
+// Matches reports whether webhook filters select an event. Pings always match.
+func (e *Event) Matches(filters []string) bool {
+	return e.Name == EventPing || len(filters) == 0 || slices.Contains(filters, e.Name)
+}
+
+func (e *Event) render(format string) ([]byte, error) {
+	switch format {
+	case FormatSlack:
+		return json.Marshal(map[string]string{"text": e.Summary})
+	case FormatMSTeams:
+		return json.Marshal(map[string]string{
+			"@type":    "MessageCard",
+			"@context": "https://schema.org/extensions",
+			"summary":  e.Summary,
+			"text":     e.Summary,
+		})
+	}
+	return json.Marshal(e.Payload)
+}
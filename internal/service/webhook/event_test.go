@@ -0,0 +1,37 @@
+package webhook
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/tinkerborg/open-pulumi-service/internal/errs"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		validate func() error
+		invalid  bool
+	}{
+		{name: "known format", validate: func() error { return ValidateFormat(FormatSlack) }},
+		{name: "unknown format", validate: func() error { return ValidateFormat("discord") }, invalid: true},
+		{name: "known filters", validate: func() error { return ValidateFilters([]string{EventStackCreated}) }},
+		{name: "no filters", validate: func() error { return ValidateFilters(nil) }},
+		{name: "unknown filter", validate: func() error { return ValidateFilters([]string{"stack_renamed"}) }, invalid: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.validate()
+			if !test.invalid {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, errs.ErrInvalidArgument) {
+				t.Fatalf("want ErrInvalidArgument, got %v", err)
+			}
+		})
+	}
+}
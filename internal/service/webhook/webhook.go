@@ -0,0 +1,262 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tinkerborg/open-pulumi-service/internal/model"
+	"github.com/tinkerborg/open-pulumi-service/internal/store"
+	"github.com/tinkerborg/open-pulumi-service/internal/util"
+)
+
+const (
+	HeaderSignature = "Pulumi-Webhook-Signature"
+	HeaderTimestamp = "Pulumi-Webhook-Timestamp"
+	HeaderDelivery  = "Pulumi-Webhook-ID"
+	HeaderKind      = "Pulumi-Webhook-Kind"
+)
+
+// Service delivers queued webhook events in the background. Deliveries are stored before they are
+// sent, so events survive restarts and are retried with exponential backoff until they succeed or
+// run out of attempts.
+type Service struct {
+	store  *store.Postgres
+	client *http.Client
+	opts   Options
+	wake   chan struct{}
+}
+
+type Options struct {
+	// PollInterval is how often the queue is checked for deliveries due for a retry
+	PollInterval time.Duration
+	// Timeout limits each delivery attempt
+	Timeout time.Duration
+	// MaxAttempts is how often a delivery is tried before it fails
+	MaxAttempts int
+	// MinBackoff is the delay before the first retry, doubling with each attempt up to MaxBackoff
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// BatchSize is how many deliveries a worker claims at once
+	BatchSize int
+}
+
+func New(store *store.Postgres, opts ...Options) (*Service, error) {
+	o, err := util.Merge(Options{
+		PollInterval: 5 * time.Second,
+		Timeout:      10 * time.Second,
+		MaxAttempts:  8,
+		MinBackoff:   30 * time.Second,
+		MaxBackoff:   time.Hour,
+		BatchSize:    20,
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Service{
+		store:  store,
+		client: util.NewRestrictedClient(o.Timeout, util.PublicAddress),
+		opts:   o,
+		wake:   make(chan struct{}, 1),
+	}, nil
+}
+
+// Enqueue queues an event for delivery to each webhook, rendered in the webhook's format. The
+// event is queued even if ctx is cancelled, as the change it reports has already happened. A nil
+// Service queues nothing.
+func (w *Service) Enqueue(ctx context.Context, hooks []model.WebhookRecord, event *Event) error {
+	if w == nil || len(hooks) == 0 {
+		return nil
+	}
+
+	deliveries := []model.WebhookDeliveryRecord{}
+
+	for _, hook := range hooks {
+		payload, err := event.render(hook.Format)
+		if err != nil {
+			return err
+		}
+
+		deliveries = append(deliveries, model.WebhookDeliveryRecord{
+			WebhookID:     hook.ID,
+			Event:         event.Name,
+			Kind:          event.Kind,
+			Payload:       string(payload),
+			State:         model.WebhookDeliveryPending,
+			NextAttemptAt: time.Now(),
+		})
+	}
+
+	if err := w.store.Create(context.WithoutCancel(ctx), &deliveries); err != nil {
+		return err
+	}
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// Run delivers queued events until the context is cancelled. Several replicas can run workers
+// against the same database; each delivery is claimed by one of them.
+func (w *Service) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.opts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			delivered, err := w.deliverPending(ctx)
+			if err != nil {
+				slog.ErrorContext(ctx, "webhook delivery failed", "error", err)
+			}
+			if err != nil || delivered < w.opts.BatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-w.wake:
+		}
+	}
+}
+
+// deliverPending claims a batch of due deliveries and sends them, returning how many were claimed.
+func (w *Service) deliverPending(ctx context.Context) (int, error) {
+	deliveries := []model.WebhookDeliveryRecord{}
+
+	err := w.store.Transaction(ctx, func(s *store.Postgres) error {
+		if err := s.List(ctx, &deliveries,
+			store.Where(model.WebhookDeliveryRecord{State: model.WebhookDeliveryPending}),
+			store.Where("next_attempt_at <= ?", time.Now()),
+			store.OrderBy("next_attempt_at"),
+			store.Limit(w.opts.BatchSize),
+			store.Lock(),
+		); err != nil {
+			return err
+		}
+
+		// push claimed deliveries out of reach of other workers while they are sent, one after the
+		// other, so the lease covers every delivery of the batch timing out; a worker that dies
+		// mid-delivery leaves them to be retried once it runs out
+		lease := time.Duration(len(deliveries)+1) * w.opts.Timeout
+
+		for i := range deliveries {
+			deliveries[i].Attempts++
+			deliveries[i].NextAttemptAt = time.Now().Add(lease)
+
+			if err := s.Update(ctx, &deliveries[i]); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, delivery := range deliveries {
+		if err := w.deliver(ctx, &delivery); err != nil {
+			return len(deliveries), err
+		}
+	}
+
+	return len(deliveries), nil
+}
+
+func (w *Service) deliver(ctx context.Context, delivery *model.WebhookDeliveryRecord) error {
+	hook := &model.WebhookRecord{ID: delivery.WebhookID}
+	if err := w.store.Read(ctx, hook); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	code, err := w.send(ctx, hook, delivery)
+	delivery.Duration = time.Since(start).Milliseconds()
+	delivery.ResponseCode = code
+	delivery.Error = ""
+
+	switch {
+	case err == nil:
+		delivery.State = model.WebhookDeliverySucceeded
+	case delivery.Attempts >= w.opts.MaxAttempts:
+		delivery.State = model.WebhookDeliveryFailed
+		delivery.Error = err.Error()
+	default:
+		delivery.NextAttemptAt = time.Now().Add(w.backoff(delivery.Attempts))
+		delivery.Error = err.Error()
+	}
+
+	return w.store.Update(ctx, delivery)
+}
+
+// send posts a delivery to its webhook, returning the receiver's status code. The response body is
+// discarded, so the delivery history can't be used to read from hosts the service can reach.
+func (w *Service) send(ctx context.Context, hook *model.WebhookRecord, delivery *model.WebhookDeliveryRecord) (int, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.PayloadURL, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := time.Now().Unix()
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "open-pulumi-service")
+	request.Header.Set(HeaderDelivery, delivery.ID)
+	request.Header.Set(HeaderKind, delivery.Kind)
+	request.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+
+	if hook.Secret != "" {
+		request.Header.Set(HeaderSignature, Sign(hook.Secret, timestamp, []byte(delivery.Payload)))
+	}
+
+	response, err := w.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	io.Copy(io.Discard, io.LimitReader(response.Body, maxDiscardedBody))
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("receiver responded %s", response.Status)
+	}
+
+	return response.StatusCode, nil
+}
+
+// backoff doubles the retry delay with every attempt, with jitter so failed deliveries to the same
+// receiver spread out.
+func (w *Service) backoff(attempts int) time.Duration {
+	delay := w.opts.MinBackoff << min(attempts-1, 20)
+	if delay <= 0 || delay > w.opts.MaxBackoff {
+		delay = w.opts.MaxBackoff
+	}
+
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of a delivery's timestamp and payload, joined by a
+// period. Receivers compare it against the signature header to verify a delivery came from this
+// service, and reject old timestamps so a captured delivery can't be replayed.
+func Sign(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
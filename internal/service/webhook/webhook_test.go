@@ -0,0 +1,255 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tinkerborg/open-pulumi-service/internal/model"
+	"github.com/tinkerborg/open-pulumi-service/internal/store"
+	"github.com/tinkerborg/open-pulumi-service/internal/util"
+)
+
+func TestSign(t *testing.T) {
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp int64
+		payload   string
+		same      bool
+	}{
+		{name: "same delivery", secret: "secret", timestamp: 100, payload: "{}", same: true},
+		{name: "other timestamp", secret: "secret", timestamp: 101, payload: "{}"},
+		{name: "other payload", secret: "secret", timestamp: 100, payload: "[]"},
+		{name: "other secret", secret: "other", timestamp: 100, payload: "{}"},
+		{name: "timestamp moved into the payload", secret: "secret", timestamp: 10, payload: "0.{}"},
+	}
+
+	want := Sign("secret", 100, []byte("{}"))
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Sign(test.secret, test.timestamp, []byte(test.payload)); (got == want) != test.same {
+				t.Errorf("got %s, signature of the delivery is %s", got, want)
+			}
+		})
+	}
+}
+
+func TestSend(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		location string
+		secret   string
+		wantCode int
+		wantErr  bool
+	}{
+		{name: "success", status: http.StatusOK, wantCode: http.StatusOK},
+		{name: "signed", status: http.StatusNoContent, secret: "secret", wantCode: http.StatusNoContent},
+		{name: "server error", status: http.StatusInternalServerError, wantCode: http.StatusInternalServerError, wantErr: true},
+		{name: "redirect is not followed", status: http.StatusFound, location: "http://169.254.169.254/", wantCode: http.StatusFound, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+
+				timestamp, err := strconv.ParseInt(r.Header.Get(HeaderTimestamp), 10, 64)
+				if err != nil || time.Since(time.Unix(timestamp, 0)) > time.Minute {
+					t.Errorf("invalid timestamp header '%s'", r.Header.Get(HeaderTimestamp))
+				}
+
+				if test.secret != "" {
+					mac := hmac.New(sha256.New, []byte(test.secret))
+					mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "." + string(body)))
+
+					if got := r.Header.Get(HeaderSignature); got != hex.EncodeToString(mac.Sum(nil)) {
+						t.Errorf("signature %s does not verify", got)
+					}
+				} else if r.Header.Get(HeaderSignature) != "" {
+					t.Errorf("unsigned webhook sent a signature")
+				}
+
+				if test.location != "" {
+					w.Header().Set("Location", test.location)
+				}
+
+				w.WriteHeader(test.status)
+				w.Write([]byte("internal details"))
+			}))
+			defer server.Close()
+
+			w := testService(t, nil)
+
+			code, err := w.send(context.Background(), &model.WebhookRecord{PayloadURL: server.URL, Secret: test.secret}, &model.WebhookDeliveryRecord{ID: "1", Kind: "stack", Payload: `{"event":"ping"}`})
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %t", err, test.wantErr)
+			}
+
+			if code != test.wantCode {
+				t.Errorf("got status %d, want %d", code, test.wantCode)
+			}
+		})
+	}
+}
+
+func TestSendRejectsInternalAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("delivery reached a loopback address")
+	}))
+	defer server.Close()
+
+	w := &Service{client: util.NewRestrictedClient(time.Second, util.PublicAddress)}
+
+	if _, err := w.send(context.Background(), &model.WebhookRecord{PayloadURL: server.URL}, &model.WebhookDeliveryRecord{}); err == nil {
+		t.Error("delivery to a loopback address succeeded")
+	}
+}
+
+func TestValidatePayloadURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		wantErr bool
+	}{
+		{url: "https://hooks.example.com/pulumi"},
+		{url: "http://hooks.example.com:8080/pulumi"},
+		{url: "ftp://hooks.example.com/", wantErr: true},
+		{url: "https://", wantErr: true},
+		{url: "http://localhost:8080/", wantErr: true},
+		{url: "http://api.localhost/", wantErr: true},
+		{url: "http://127.0.0.1/", wantErr: true},
+		{url: "http://[::1]/", wantErr: true},
+		{url: "http://169.254.169.254/latest/meta-data/", wantErr: true},
+		{url: "http://93.184.216.34/"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			if err := ValidatePayloadURL(test.url); (err != nil) != test.wantErr {
+				t.Errorf("got error %v, want error %t", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	w := testService(t, nil)
+
+	tests := []struct {
+		attempts int
+		max      time.Duration
+	}{
+		{attempts: 1, max: 30 * time.Second},
+		{attempts: 2, max: time.Minute},
+		{attempts: 3, max: 2 * time.Minute},
+		{attempts: 8, max: time.Hour},
+		{attempts: 100, max: time.Hour},
+	}
+
+	for _, test := range tests {
+		t.Run(strconv.Itoa(test.attempts), func(t *testing.T) {
+			for range 100 {
+				if got := w.backoff(test.attempts); got < test.max/2 || got > test.max {
+					t.Fatalf("got %s, want between %s and %s", got, test.max/2, test.max)
+				}
+			}
+		})
+	}
+}
+
+func TestDeliverRetries(t *testing.T) {
+	s := store.NewTestPostgres(t)
+	s.RegisterModels(model.OrganizationRecord{}, model.WebhookRecord{}, model.WebhookDeliveryRecord{})
+
+	var requests atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// fail the first attempt only
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	w := testService(t, s)
+	ctx := context.Background()
+
+	organization := &model.OrganizationRecord{Name: "acme"}
+	if err := s.Create(ctx, organization); err != nil {
+		t.Fatal(err)
+	}
+
+	hook := &model.WebhookRecord{OrganizationID: organization.ID, Name: "hook", PayloadURL: server.URL, Active: true, Format: FormatRaw}
+	if err := s.Create(ctx, hook); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Enqueue(ctx, []model.WebhookRecord{*hook}, &Event{Name: "ping", Kind: "ping"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		state    string
+		attempts int
+		code     int
+	}{
+		{name: "first attempt fails", state: model.WebhookDeliveryPending, attempts: 1, code: http.StatusServiceUnavailable},
+		{name: "retry succeeds", state: model.WebhookDeliverySucceeded, attempts: 2, code: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// make the delivery due, skipping the backoff
+			if err := s.Raw(ctx, &[]model.WebhookDeliveryRecord{}, "UPDATE webhook_delivery_record SET next_attempt_at = now() RETURNING *"); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := w.deliverPending(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			deliveries := []model.WebhookDeliveryRecord{}
+			if err := s.List(ctx, &deliveries); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(deliveries) != 1 {
+				t.Fatalf("got %d deliveries", len(deliveries))
+			}
+
+			delivery := deliveries[0]
+
+			if delivery.State != test.state || delivery.Attempts != test.attempts || delivery.ResponseCode != test.code {
+				t.Errorf("got state %s, %d attempts and status %d, want %s, %d and %d", delivery.State, delivery.Attempts, delivery.ResponseCode, test.state, test.attempts, test.code)
+			}
+
+			if delivery.State == model.WebhookDeliveryPending && time.Until(delivery.NextAttemptAt) < 15*time.Second {
+				t.Errorf("retry due in %s, before the minimum backoff", time.Until(delivery.NextAttemptAt))
+			}
+		})
+	}
+}
+
+// testService returns a service that may deliver to the loopback addresses of test servers.
+func testService(t *testing.T, s *store.Postgres) *Service {
+	w, err := New(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w.client = util.NewRestrictedClient(w.opts.Timeout, func(netip.Addr) bool { return true })
+
+	return w
+}
@@ -5,6 +5,7 @@ package store
 import (
 	"context"
 	"fmt"
+	"testing"
 	"time"
 
 	"github.com/testcontainers/testcontainers-go"
@@ -48,3 +49,26 @@ func NewMockPostgres(ctx context.Context) (*MockPostgres, error) {
 func (m *MockPostgres) Terminate(ctx context.Context) error {
 	return m.container.Terminate(ctx)
 }
+
+// NewTestPostgres connects a test to a postgres container that is terminated when the test ends,
+// skipping the test where containers can't be run.
+func NewTestPostgres(t *testing.T) *Postgres {
+	t.Helper()
+
+	testcontainers.SkipIfProviderIsNotHealthy(t)
+
+	ctx := context.Background()
+
+	mock, err := NewMockPostgres(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { mock.Terminate(ctx) })
+
+	p, err := NewPostgres(mock.ConnectionString)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
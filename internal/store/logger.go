@@ -0,0 +1,76 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const slowQueryThreshold = time.Second
+
+// slogLogger writes gorm's logs with slog, so queries are logged with the request they were made
+// for. Failed and slow queries are logged as errors and warnings, others at debug level.
+type slogLogger struct{}
+
+var _ logger.Interface = slogLogger{}
+var _ gorm.ParamsFilter = slogLogger{}
+
+// LogMode is ignored, levels are filtered by the slog handler.
+func (l slogLogger) LogMode(logger.LogLevel) logger.Interface {
+	return l
+}
+
+func (slogLogger) Info(ctx context.Context, format string, args ...interface{}) {
+	slog.InfoContext(ctx, fmt.Sprintf(format, args...))
+}
+
+func (slogLogger) Warn(ctx context.Context, format string, args ...interface{}) {
+	slog.WarnContext(ctx, fmt.Sprintf(format, args...))
+}
+
+func (slogLogger) Error(ctx context.Context, format string, args ...interface{}) {
+	slog.ErrorContext(ctx, fmt.Sprintf(format, args...))
+}
+
+func (slogLogger) Trace(ctx context.Context, begin time.Time, query func() (string, int64), err error) {
+	elapsed := time.Since(begin)
+
+	level := slog.LevelDebug
+	message := "query"
+
+	switch {
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound) && !errors.Is(err, context.Canceled):
+		level = slog.LevelError
+		message = "query failed"
+	case elapsed > slowQueryThreshold:
+		level = slog.LevelWarn
+		message = "slow query"
+	}
+
+	if !slog.Default().Enabled(ctx, level) {
+		return
+	}
+
+	sql, rows := query()
+
+	attrs := []slog.Attr{
+		slog.String("sql", sql),
+		slog.Int64("rows", rows),
+		slog.Float64("duration_ms", float64(elapsed.Microseconds())/1000),
+	}
+	if level == slog.LevelError {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+
+	slog.LogAttrs(ctx, level, message, attrs...)
+}
+
+// ParamsFilter leaves query parameters out of logged SQL, as they include state and secrets.
+func (slogLogger) ParamsFilter(ctx context.Context, sql string, params ...interface{}) (string, []interface{}) {
+	return sql, nil
+}
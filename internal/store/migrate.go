@@ -0,0 +1,51 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Migration changes existing rows to fit a new schema, such as by backfilling a new column.
+type Migration struct {
+	// Name identifies the migration once it has run, so it must never change
+	Name string
+	SQL  string
+}
+
+type migrationRecord struct {
+	Name      string `gorm:"primaryKey"`
+	AppliedAt time.Time
+}
+
+// Migrate runs the migrations that haven't run yet, in order, each in a transaction of its own.
+// Models must be registered first so that the columns the migrations fill in exist. A replica
+// starting at the same time as another waits for the other's migration, then skips it.
+func (p *Postgres) Migrate(ctx context.Context, migrations ...Migration) error {
+	if err := p.db.WithContext(ctx).AutoMigrate(&migrationRecord{}); err != nil {
+		return err
+	}
+
+	for _, migration := range migrations {
+		err := p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&migrationRecord{
+				Name:      migration.Name,
+				AppliedAt: time.Now(),
+			})
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+
+			return tx.Exec(migration.SQL).Error
+		})
+
+		if err != nil {
+			return fmt.Errorf("migration '%s' failed: %w", migration.Name, err)
+		}
+	}
+
+	return nil
+}
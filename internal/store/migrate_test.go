@@ -0,0 +1,63 @@
+package store
+
+import (
+	"context"
+	"testing"
+)
+
+type migrationTestRecord struct {
+	ID    int `gorm:"primaryKey"`
+	Value string
+}
+
+func TestMigrate(t *testing.T) {
+	p := NewTestPostgres(t)
+	ctx := context.Background()
+
+	p.RegisterModels(migrationTestRecord{})
+
+	if err := p.Create(ctx, &migrationTestRecord{ID: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		migrations []Migration
+		want       string
+	}{
+		{
+			name:       "runs a new migration",
+			migrations: []Migration{{Name: "a", SQL: "UPDATE migration_test_record SET value = value || 'a'"}},
+			want:       "a",
+		},
+		{
+			name: "skips migrations that have run",
+			migrations: []Migration{
+				{Name: "a", SQL: "UPDATE migration_test_record SET value = value || 'a'"},
+				{Name: "b", SQL: "UPDATE migration_test_record SET value = value || 'b'"},
+			},
+			want: "ab",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := p.Migrate(ctx, test.migrations...); err != nil {
+				t.Fatal(err)
+			}
+
+			record := migrationTestRecord{ID: 1}
+			if err := p.Read(ctx, &record); err != nil {
+				t.Fatal(err)
+			}
+
+			if record.Value != test.want {
+				t.Errorf("got '%s', want '%s'", record.Value, test.want)
+			}
+		})
+	}
+
+	if err := p.Migrate(ctx, Migration{Name: "broken", SQL: "UPDATE missing SET value = 1"}); err == nil {
+		t.Error("broken migration succeeded")
+	}
+}
@@ -16,14 +16,15 @@ type DBOption interface {
 // Where option
 type where struct {
 	query interface{}
+	args  []interface{}
 }
 
-func Where(query interface{}) *where {
-	return &where{query}
+func Where(query interface{}, args ...interface{}) *where {
+	return &where{query, args}
 }
 
 func (w *where) apply(db *gorm.DB, record interface{}) (*gorm.DB, error) {
-	return db.Where(w.query), nil
+	return db.Where(w.query, w.args...), nil
 }
 
 // OrderBy option
@@ -150,6 +151,19 @@ func (j *join) apply(db *gorm.DB, record interface{}) (*gorm.DB, error) {
 	return db.Joins(field, j.column, preloadOptions), nil
 }
 
+// lock option
+type lock struct{}
+
+// Lock locks the selected rows until the transaction ends, skipping rows already locked by
+// another transaction, so concurrent workers claim different rows.
+func Lock() *lock {
+	return &lock{}
+}
+
+func (l *lock) apply(db *gorm.DB, record interface{}) (*gorm.DB, error) {
+	return db.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}), nil
+}
+
 // End options
 
 func applyOptions(db *gorm.DB, record interface{}, opts ...DBOption) (*gorm.DB, error) {
@@ -1,39 +1,49 @@
 package store
 
 import (
-	"errors"
-	"log"
-	"os"
+	"context"
+	"database/sql"
 	"reflect"
 	"time"
 
+	"github.com/tinkerborg/open-pulumi-service/internal/util"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/schema"
+	"gorm.io/plugin/opentelemetry/tracing"
 )
 
+// createBatchSize keeps multi-row inserts well below postgres' limit of 65535 bind parameters
+const createBatchSize = 1000
+
 type Postgres struct {
 	db          *gorm.DB
 	primaryKeys map[interface{}][]string
+	timeout     time.Duration
+	// tables of the registered models
+	tables []string
+}
+
+type Options struct {
+	// Timeout is how long a query may take, unless the context sets another with WithTimeout.
+	// Zero means no limit.
+	Timeout time.Duration
 }
 
 type Model[T any] interface {
 	ID()
 }
 
-func NewPostgres(connectionString string) (*Postgres, error) {
+func NewPostgres(connectionString string, opts ...Options) (*Postgres, error) {
+	o, err := util.Merge(Options{}, opts)
+	if err != nil {
+		return nil, err
+	}
+
 	db, err := gorm.Open(postgres.Open(connectionString), &gorm.Config{
 		TranslateError: true,
-		Logger: logger.New(
-			log.New(os.Stdout, "\r\n", log.LstdFlags),
-			logger.Config{
-				SlowThreshold:             time.Second,
-				LogLevel:                  logger.Warn,
-				IgnoreRecordNotFoundError: true,
-				Colorful:                  false,
-			},
-		),
+		Logger:         slogLogger{},
 		NamingStrategy: schema.NamingStrategy{
 
 			SingularTable: true,
@@ -44,72 +54,109 @@ func NewPostgres(connectionString string) (*Postgres, error) {
 		return nil, err
 	}
 
+	// query variables are left out of spans as they include state and secrets
+	if err := db.Use(tracing.NewPlugin(tracing.WithoutMetrics(), tracing.WithoutQueryVariables())); err != nil {
+		return nil, err
+	}
+
 	return &Postgres{
 		db:          db,
 		primaryKeys: map[interface{}][]string{},
+		timeout:     o.Timeout,
 	}, nil
 }
 
-func (p *Postgres) Create(record interface{}) error {
-	err := p.db.Create(ensurePtr(record)).Error
-	if errors.Is(err, gorm.ErrDuplicatedKey) {
-		return ErrExist
+// SQL returns the underlying connection pool.
+func (p *Postgres) SQL() (*sql.DB, error) {
+	return p.db.DB()
+}
+
+// Ping checks that the database is reachable.
+func (p *Postgres) Ping(ctx context.Context) error {
+	db, err := p.db.DB()
+	if err != nil {
+		return err
 	}
 
-	return err
+	return db.PingContext(ctx)
+}
+
+func (p *Postgres) Create(ctx context.Context, record interface{}) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	return translateError(p.db.WithContext(ctx).Create(ensurePtr(record)).Error)
+}
+
+// CreateIgnoreExisting inserts a slice of records in batches, skipping any record whose key
+// already exists instead of failing.
+func (p *Postgres) CreateIgnoreExisting(ctx context.Context, records interface{}) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	return translateError(p.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(records, createBatchSize).Error)
 }
 
-func (p *Postgres) Read(record interface{}, opts ...DBOption) error {
+func (p *Postgres) Read(ctx context.Context, record interface{}, opts ...DBOption) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
 	if err := p.validatePrimaryKey(record); err != nil {
 		return err
 	}
 
-	db, err := applyOptions(p.db, record, opts...)
+	db, err := applyOptions(p.db.WithContext(ctx), record, opts...)
 	if err != nil {
 		return err
 	}
 
-	if err := db.First(ensurePtr(record), record).Error; errors.Is(err, gorm.ErrRecordNotFound) {
-		return ErrNotFound
-	}
-
-	return nil
+	return translateError(db.First(ensurePtr(record), record).Error)
 }
 
-func (p *Postgres) List(records interface{}, opts ...DBOption) error {
-	db, err := applyOptions(p.db, records, opts...)
+func (p *Postgres) List(ctx context.Context, records interface{}, opts ...DBOption) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	db, err := applyOptions(p.db.WithContext(ctx), records, opts...)
 	if err != nil {
 		return err
 	}
 
-	err = db.Find(ensurePtr(records)).Error
-	if errors.Is(err, gorm.ErrRecordNotFound) {
-		return ErrNotFound
-	}
+	return translateError(db.Find(ensurePtr(records)).Error)
+}
+
+func (p *Postgres) Update(ctx context.Context, record interface{}) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
 
-	return err
+	return translateError(p.db.WithContext(ctx).Save(ensurePtr(record)).Error)
 }
 
-func (p *Postgres) Update(record interface{}) error {
-	err := p.db.Save(ensurePtr(record)).Error
-	if errors.Is(err, gorm.ErrRecordNotFound) {
-		return ErrNotFound
-	}
+// Upsert creates a record, or overwrites all its columns if a record with the same key exists.
+func (p *Postgres) Upsert(ctx context.Context, record interface{}) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
 
-	return err
+	return translateError(p.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(ensurePtr(record)).Error)
 }
 
-func (p *Postgres) Delete(record interface{}) error {
-	err := p.db.Delete(ensurePtr(record)).Error
-	if errors.Is(err, gorm.ErrRecordNotFound) {
-		return ErrNotFound
+func (p *Postgres) Delete(ctx context.Context, record interface{}, opts ...DBOption) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	db, err := applyOptions(p.db.WithContext(ctx), record, opts...)
+	if err != nil {
+		return err
 	}
 
-	return err
+	return translateError(db.Delete(ensurePtr(record)).Error)
 }
 
-func (p *Postgres) Count(records interface{}, opts ...DBOption) (int64, error) {
-	db, err := applyOptions(p.db, records, opts...)
+func (p *Postgres) Count(ctx context.Context, records interface{}, opts ...DBOption) (int64, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	db, err := applyOptions(p.db.WithContext(ctx), records, opts...)
 	if err != nil {
 		return -1, err
 	}
@@ -118,15 +165,27 @@ func (p *Postgres) Count(records interface{}, opts ...DBOption) (int64, error) {
 
 	err = db.Model(ensurePtr(records)).Where(records).Count(&count).Error
 
-	return count, err
+	return count, translateError(err)
 }
 
-func (p *Postgres) Transaction(fc func(p *Postgres) error) error {
-	err := p.db.Transaction(func(tx *gorm.DB) error {
-		db := &Postgres{db: tx, primaryKeys: p.primaryKeys}
+// Raw runs a query the other methods can't express, such as an atomic read and write, scanning
+// the rows it returns into dest.
+func (p *Postgres) Raw(ctx context.Context, dest interface{}, sql string, values ...interface{}) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	return translateError(p.db.WithContext(ctx).Raw(sql, values...).Scan(dest).Error)
+}
+
+func (p *Postgres) Transaction(ctx context.Context, fc func(p *Postgres) error) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	err := p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		db := &Postgres{db: tx, primaryKeys: p.primaryKeys, timeout: p.timeout}
 		return fc(db)
 	})
-	return err
+	return translateError(err)
 }
 
 func ensurePtr(value interface{}) interface{} {
@@ -1,9 +1,11 @@
 package store
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"reflect"
+	"slices"
 
 	"gorm.io/gorm"
 )
@@ -23,6 +25,35 @@ func (p *Postgres) RegisterModels(models ...interface{}) error {
 
 		p.primaryKeys[getValueType(model).Type()] = primaryKeys
 		defaultOptions[getValueType(model).Type()] = parseDefaultOptions(p.db, model)
+
+		stmt := &gorm.Statement{DB: p.db}
+		if err := stmt.Parse(model); err != nil {
+			return err
+		}
+		if !slices.Contains(p.tables, stmt.Schema.Table) {
+			p.tables = append(p.tables, stmt.Schema.Table)
+		}
+	}
+
+	return nil
+}
+
+// Migrated checks that the tables of every registered model exist.
+func (p *Postgres) Migrated(ctx context.Context) error {
+	if len(p.tables) == 0 {
+		return nil
+	}
+
+	var count int
+	if err := p.db.WithContext(ctx).Raw(
+		"SELECT count(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name IN ?",
+		p.tables,
+	).Scan(&count).Error; err != nil {
+		return err
+	}
+
+	if count < len(p.tables) {
+		return fmt.Errorf("%d of %d tables are missing", len(p.tables)-count, len(p.tables))
 	}
 
 	return nil
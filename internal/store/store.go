@@ -1,9 +1,29 @@
 package store
 
-import "errors"
+import (
+	"context"
+	"errors"
+
+	"github.com/tinkerborg/open-pulumi-service/internal/errs"
+	"gorm.io/gorm"
+)
 
-// TODO - find an appropriate home for this
 var (
-	ErrNotFound = errors.New("does not exist")
-	ErrExist    = errors.New("already exists")
+	ErrNotFound = errs.ErrNotFound
+	ErrExist    = errs.ErrConflict
 )
+
+// translateError classifies database errors: missing and duplicate records, and queries that ran
+// out of time, which may succeed when retried.
+func translateError(err error) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return ErrNotFound
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		return ErrExist
+	case errors.Is(err, context.DeadlineExceeded):
+		return errs.With(err, errs.ErrUnavailable)
+	}
+
+	return err
+}
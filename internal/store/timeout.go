@@ -0,0 +1,39 @@
+package store
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+type timeoutKey struct{}
+
+// WithTimeout sets how long each query made with ctx may take, in place of the store's default.
+func WithTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, timeoutKey{}, timeout)
+}
+
+// Timeout is a route option setting how long each query made for a request may take, for routes
+// whose queries take longer or should give up sooner than the default.
+func Timeout(timeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(WithTimeout(r.Context(), timeout)))
+		})
+	}
+}
+
+// withTimeout bounds a query by the timeout set on ctx, or the store's default. Cancelling ctx,
+// such as when the client disconnects, still cancels the query.
+func (p *Postgres) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout, ok := ctx.Value(timeoutKey{}).(time.Duration)
+	if !ok {
+		timeout = p.timeout
+	}
+
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
@@ -0,0 +1,59 @@
+package util
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// sharedAddressSpace is carrier-grade NAT space, which clouds also use for internal services
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// NewRestrictedClient returns a client for URLs chosen by users. It only connects to addresses
+// that allowed accepts, checked after DNS resolution so that a name can't resolve to an internal
+// address. It doesn't follow redirects or use a proxy, either of which would get around the check.
+func NewRestrictedClient(timeout time.Duration, allowed func(netip.Addr) bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network string, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+
+			if !allowed(addrPort.Addr()) {
+				return fmt.Errorf("url resolves to the non-public address %s", addrPort.Addr())
+			}
+
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			ForceAttemptHTTP2:   true,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: timeout,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// PublicAddress reports whether an address is on the public internet, rather than loopback,
+// private, link-local (which includes cloud metadata endpoints) or otherwise internal.
+func PublicAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+
+	return addr.IsValid() &&
+		addr.IsGlobalUnicast() &&
+		!addr.IsPrivate() &&
+		!sharedAddressSpace.Contains(addr)
+}
@@ -0,0 +1,36 @@
+package util
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestPublicAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		public  bool
+	}{
+		{address: "93.184.216.34", public: true},
+		{address: "2606:2800:220:1:248:1893:25c8:1946", public: true},
+		{address: "127.0.0.1"},
+		{address: "::1"},
+		{address: "10.1.2.3"},
+		{address: "172.16.0.1"},
+		{address: "192.168.1.1"},
+		{address: "169.254.169.254"},
+		{address: "fe80::1"},
+		{address: "fd00::1"},
+		{address: "100.100.100.200"},
+		{address: "0.0.0.0"},
+		{address: "224.0.0.1"},
+		{address: "::ffff:127.0.0.1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.address, func(t *testing.T) {
+			if got := PublicAddress(netip.MustParseAddr(test.address)); got != test.public {
+				t.Errorf("got %t, want %t", got, test.public)
+			}
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	EncodingGzip = "gzip"
+	EncodingZstd = "zstd"
+
+	// minCompressSize is the smallest response worth compressing, smaller ones are sent as is
+	minCompressSize = 1024
+)
+
+var compressibleTypes = []string{
+	"application/json",
+	"application/x-ndjson",
+	"application/javascript",
+	"application/xml",
+	"image/svg+xml",
+	"text/",
+}
+
+var gzipWriters = sync.Pool{New: func() any {
+	return gzip.NewWriter(nil)
+}}
+
+var zstdWriters = sync.Pool{New: func() any {
+	// a single goroutine per response, concurrency comes from serving many at once
+	encoder, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	return encoder
+}}
+
+// Compress compresses responses with zstd or gzip, as negotiated from the Accept-Encoding header.
+// Only text and JSON responses of at least 1KB are compressed. Event streams and responses that
+// set their own Content-Encoding are sent as they are.
+func Compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+
+		if encoding == "" || r.Method == http.MethodHead || r.Header.Get("Range") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+
+		next.ServeHTTP(cw, r)
+
+		cw.Close()
+	})
+}
+
+// negotiateEncoding picks zstd or gzip from an Accept-Encoding header, preferring zstd when the
+// client accepts both equally. It returns "" when the client accepts neither.
+func negotiateEncoding(header string) string {
+	best, bestQuality := "", 0.0
+
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		if name != EncodingZstd && name != EncodingGzip {
+			continue
+		}
+
+		quality := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+
+		if quality > bestQuality || (quality == bestQuality && quality > 0 && name == EncodingZstd) {
+			best, bestQuality = name, quality
+		}
+	}
+
+	return best
+}
+
+// compressWriter holds back the start of a response until it knows whether to compress it, which
+// is once it has seen the headers and enough of the body, or the handler flushes or returns.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding   string
+	statusCode int
+	buf        []byte
+	decided    bool
+	encoder    io.WriteCloser
+}
+
+func (w *compressWriter) WriteHeader(statusCode int) {
+	if w.decided {
+		w.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+
+	// informational responses don't end the headers
+	if statusCode < http.StatusOK {
+		w.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+
+	w.statusCode = statusCode
+
+	// responses without a body have nothing to compress
+	if statusCode == http.StatusNoContent || statusCode == http.StatusNotModified {
+		w.decide(false)
+	}
+}
+
+func (w *compressWriter) Write(data []byte) (int, error) {
+	if !w.decided {
+		if !w.compressible() {
+			w.decide(false)
+		} else if len(w.buf)+len(data) < minCompressSize {
+			w.buf = append(w.buf, data...)
+			return len(data), nil
+		} else {
+			w.decide(true)
+		}
+	}
+
+	if w.encoder != nil {
+		return w.encoder.Write(data)
+	}
+
+	return w.ResponseWriter.Write(data)
+}
+
+// FlushError sends what has been written so far, compressing it if the response is compressible,
+// as a flushing handler is streaming and its response may grow past the threshold.
+func (w *compressWriter) FlushError() error {
+	if !w.decided {
+		w.decide(w.compressible())
+	}
+
+	if flusher, ok := w.encoder.(interface{ Flush() error }); ok {
+		if err := flusher.Flush(); err != nil {
+			return err
+		}
+	}
+
+	return http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *compressWriter) Flush() {
+	w.FlushError()
+}
+
+// Unwrap exposes the embedded ResponseWriter to http.ResponseController.
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Close sends a response too small to compress, or finishes the compressed stream.
+func (w *compressWriter) Close() error {
+	if !w.decided {
+		w.decide(false)
+	}
+
+	if w.encoder == nil {
+		return nil
+	}
+
+	err := w.encoder.Close()
+
+	switch encoder := w.encoder.(type) {
+	case *gzip.Writer:
+		gzipWriters.Put(encoder)
+	case *zstd.Encoder:
+		zstdWriters.Put(encoder)
+	}
+
+	return err
+}
+
+func (w *compressWriter) compressible() bool {
+	header := w.Header()
+
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+
+	contentType := header.Get("Content-Type")
+	if strings.HasPrefix(contentType, "text/event-stream") {
+		return false
+	}
+
+	for _, compressible := range compressibleTypes {
+		if strings.HasPrefix(contentType, compressible) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// decide sends the headers and whatever was held back, compressed or not.
+func (w *compressWriter) decide(compress bool) {
+	w.decided = true
+
+	header := w.Header()
+
+	if compress {
+		header.Set("Content-Encoding", w.encoding)
+		header.Add("Vary", "Accept-Encoding")
+		header.Del("Content-Length")
+
+		// the compressed body differs byte for byte, so it only matches its ETag weakly
+		if etag := header.Get("ETag"); strings.HasPrefix(etag, `"`) {
+			header.Set("ETag", "W/"+etag)
+		}
+
+		switch w.encoding {
+		case EncodingZstd:
+			encoder := zstdWriters.Get().(*zstd.Encoder)
+			encoder.Reset(w.ResponseWriter)
+			w.encoder = encoder
+		default:
+			encoder := gzipWriters.Get().(*gzip.Writer)
+			encoder.Reset(w.ResponseWriter)
+			w.encoder = encoder
+		}
+	} else if w.compressible() {
+		header.Add("Vary", "Accept-Encoding")
+	}
+
+	if w.statusCode != 0 {
+		w.ResponseWriter.WriteHeader(w.statusCode)
+	}
+
+	if len(w.buf) > 0 {
+		if w.encoder != nil {
+			w.encoder.Write(w.buf)
+		} else {
+			w.ResponseWriter.Write(w.buf)
+		}
+		w.buf = nil
+	}
+}
@@ -0,0 +1,167 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{header: "", want: ""},
+		{header: "br, deflate", want: ""},
+		{header: "gzip", want: EncodingGzip},
+		{header: "gzip, zstd", want: EncodingZstd},
+		{header: "GZIP", want: EncodingGzip},
+		{header: "zstd;q=0.5, gzip", want: EncodingGzip},
+		{header: "gzip;q=0.8, zstd;q=0.8", want: EncodingZstd},
+		{header: "gzip;q=0, zstd;q=0", want: ""},
+		{header: "zstd;q=0, gzip", want: EncodingGzip},
+		{header: "zstd;q=bad, gzip;q=0.1", want: EncodingGzip},
+	}
+
+	for _, test := range tests {
+		t.Run(test.header, func(t *testing.T) {
+			if got := negotiateEncoding(test.header); got != test.want {
+				t.Fatalf("want '%s', got '%s'", test.want, got)
+			}
+		})
+	}
+}
+
+func TestCompress(t *testing.T) {
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		contentType    string
+		status         int
+		writes         []int
+		wantEncoding   string
+	}{
+		{name: "held back under 1KB", acceptEncoding: "gzip", contentType: "application/json", writes: []int{512, 511}},
+		{name: "compressed at 1KB", acceptEncoding: "gzip", contentType: "application/json", writes: []int{512, 512}, wantEncoding: EncodingGzip},
+		{name: "zstd", acceptEncoding: "zstd, gzip", contentType: "text/plain", writes: []int{4096}, wantEncoding: EncodingZstd},
+		{name: "not accepted", contentType: "application/json", writes: []int{4096}},
+		{name: "not compressible", acceptEncoding: "gzip", contentType: "image/png", writes: []int{4096}},
+		{name: "not modified", acceptEncoding: "gzip", contentType: "application/json", status: http.StatusNotModified},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var body []byte
+
+			handler := Compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", test.contentType)
+				w.Header().Set("ETag", `"tag"`)
+				if test.status != 0 {
+					w.WriteHeader(test.status)
+				}
+				for i, size := range test.writes {
+					chunk := bytes.Repeat([]byte{'a' + byte(i)}, size)
+					body = append(body, chunk...)
+					w.Write(chunk)
+				}
+			}))
+
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.Header.Set("Accept-Encoding", test.acceptEncoding)
+
+			response := httptest.NewRecorder()
+			handler.ServeHTTP(response, request)
+
+			if test.status != 0 && response.Code != test.status {
+				t.Fatalf("want status %d, got %d", test.status, response.Code)
+			}
+
+			if encoding := response.Header().Get("Content-Encoding"); encoding != test.wantEncoding {
+				t.Fatalf("want encoding '%s', got '%s'", test.wantEncoding, encoding)
+			}
+
+			wantETag := `"tag"`
+			if test.wantEncoding != "" {
+				wantETag = `W/"tag"`
+			}
+			if etag := response.Header().Get("ETag"); etag != wantETag {
+				t.Fatalf("want ETag %s, got %s", wantETag, etag)
+			}
+
+			if got := decode(t, test.wantEncoding, response.Body); !bytes.Equal(got, body) {
+				t.Fatalf("body of %d bytes sent as %d", len(body), len(got))
+			}
+		})
+	}
+}
+
+// TestCompressEventStream checks that events reach the client as they are flushed, rather than
+// being held back or buffered by a compressor.
+func TestCompressEventStream(t *testing.T) {
+	release := make(chan struct{})
+
+	server := httptest.NewServer(Compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: first\n\n"))
+		w.(http.Flusher).Flush()
+		<-release
+	})))
+	defer server.Close()
+	defer close(release)
+
+	request, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	request.Header.Set("Accept-Encoding", "gzip")
+
+	response, err := http.DefaultTransport.RoundTrip(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	if encoding := response.Header.Get("Content-Encoding"); encoding != "" {
+		t.Fatalf("event stream compressed with %s", encoding)
+	}
+
+	line, err := bufio.NewReader(response.Body).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "data: first\n" {
+		t.Fatalf("want first event, got '%s'", line)
+	}
+}
+
+func decode(t *testing.T, encoding string, body io.Reader) []byte {
+	t.Helper()
+
+	var reader io.Reader = body
+
+	switch encoding {
+	case EncodingGzip:
+		gzipReader, err := gzip.NewReader(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = gzipReader
+	case EncodingZstd:
+		decoder, err := zstd.NewReader(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer decoder.Close()
+		reader = decoder
+	}
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
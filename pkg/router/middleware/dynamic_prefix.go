@@ -3,11 +3,11 @@ package middleware
 import (
 	"context"
 	"net/http"
+
+	"github.com/tinkerborg/open-pulumi-service/pkg/router"
 	"path"
 )
 
-type dynamicPrefixKey struct{}
-
 type DynamicPrefix[T any] struct {
 	prefix string
 	parser func(r *http.Request) (T, error)
@@ -29,11 +29,12 @@ func (d *DynamicPrefix[T]) Middleware(next http.Handler) http.Handler {
 
 		value, err := d.parser(r)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			router.WriteError(w, r, http.StatusBadRequest, err)
 			return
 		}
 
-		ctx := context.WithValue(r.Context(), dynamicPrefixKey{}, value)
+		// keyed by parser so that nested parsers keep their own values
+		ctx := context.WithValue(r.Context(), d, value)
 
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
@@ -41,7 +42,7 @@ func (d *DynamicPrefix[T]) Middleware(next http.Handler) http.Handler {
 }
 
 func (d *DynamicPrefix[T]) Value(r *http.Request) T {
-	value := r.Context().Value(dynamicPrefixKey{})
+	value := r.Context().Value(d)
 
 	if value == nil {
 		var zero T
@@ -2,8 +2,11 @@ package middleware
 
 import (
 	"compress/gzip"
+	"fmt"
 	"io"
 	"net/http"
+
+	"github.com/tinkerborg/open-pulumi-service/pkg/router"
 )
 
 // GzipDecodeMiddleware wraps an http.Handler to decompress gzipped request bodies.
@@ -12,7 +15,7 @@ func GzipDecode(next http.Handler) http.Handler {
 		if r.Header.Get("Content-Encoding") == "gzip" {
 			gzReader, err := gzip.NewReader(r.Body)
 			if err != nil {
-				http.Error(w, "Error creating gzip reader", http.StatusBadRequest)
+				router.WriteError(w, r, http.StatusBadRequest, fmt.Errorf("invalid gzip body: %w", err))
 				return
 			}
 			defer gzReader.Close()
@@ -1,37 +1,118 @@
 package middleware
 
 import (
-	"log"
+	"context"
+	"crypto/rand"
+	"log/slog"
 	"net/http"
+	"sync"
 	"time"
+
+	"github.com/tinkerborg/open-pulumi-service/pkg/router"
 )
 
-type statusRecorder struct {
-	http.ResponseWriter
-	status int
+// RequestIDHeader carries the ID a request is logged with. It is taken from the request when the
+// caller or a proxy set it, and sent back on the response.
+const RequestIDHeader = "X-Request-ID"
+
+const maxRequestIDLength = 128
+
+type logAttrsKey struct{}
+
+// logAttrs are the attributes of a request's log lines, added to as the request is routed.
+type logAttrs struct {
+	mutex sync.Mutex
+	attrs []slog.Attr
 }
 
-// WriteHeader captures the status code before calling the embedded ResponseWriter's WriteHeader.
-func (rec *statusRecorder) WriteHeader(code int) {
-	rec.status = code
-	rec.ResponseWriter.WriteHeader(code)
+// WithLogAttrs gives requests somewhere to collect log attributes from the start. It wraps the
+// server's handler, as Logging runs in each route's middleware, after route prefix options such as
+// path parsers that already add attributes.
+func WithLogAttrs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), logAttrsKey{}, &logAttrs{})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
 }
 
+// Logging assigns each request an ID and logs it once it completes, with its route, status and
+// duration and any attributes handlers added with AddLogAttrs.
 func Logging(next http.Handler) http.Handler {
-	// http.HandlerFunc is an adapter to allow the use of ordinary functions as HTTP handlers.
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		// Initialize the statusRecorder. The default status is 200 OK.
-		// If the handler doesn't explicitly call WriteHeader, the status will remain 200.
-		rec := &statusRecorder{w, http.StatusOK}
-
-		// Call the next handler in the chain, passing our wrapped ResponseWriter.
-		next.ServeHTTP(rec, r)
-
-		// After the handler has completed, the status code has been set (either explicitly
-		// or implicitly). We can now access it from our recorder.
-		duration := time.Since(start)
-		log.Printf("method=%s path=%s status=%d duration=%s",
-			r.Method, r.RequestURI, rec.status, duration)
+
+		requestID := r.Header.Get(RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = rand.Text()
+		}
+
+		w.Header().Set(RequestIDHeader, requestID)
+
+		attrs, ok := r.Context().Value(logAttrsKey{}).(*logAttrs)
+		if !ok {
+			attrs = &logAttrs{}
+			r = r.WithContext(context.WithValue(r.Context(), logAttrsKey{}, attrs))
+		}
+
+		attrs.mutex.Lock()
+		attrs.attrs = append([]slog.Attr{slog.String("request_id", requestID)}, attrs.attrs...)
+		attrs.mutex.Unlock()
+
+		recorder := router.NewStatusRecorder(w)
+
+		next.ServeHTTP(recorder, r)
+
+		status := recorder.StatusCode()
+
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+
+		slog.LogAttrs(r.Context(), level, "request",
+			slog.String("method", r.Method),
+			slog.String("route", router.RoutePattern(r)),
+			slog.String("path", r.RequestURI),
+			slog.Int("status", status),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+		)
 	})
 }
+
+// AddLogAttrs adds attributes to every line logged for the request ctx belongs to, including the
+// request log line itself.
+func AddLogAttrs(ctx context.Context, attrs ...slog.Attr) {
+	if logAttrs, ok := ctx.Value(logAttrsKey{}).(*logAttrs); ok {
+		logAttrs.mutex.Lock()
+		defer logAttrs.mutex.Unlock()
+
+		logAttrs.attrs = append(logAttrs.attrs, attrs...)
+	}
+}
+
+// LogAttrs returns the attributes added for the request ctx belongs to.
+func LogAttrs(ctx context.Context) []slog.Attr {
+	if logAttrs, ok := ctx.Value(logAttrsKey{}).(*logAttrs); ok {
+		logAttrs.mutex.Lock()
+		defer logAttrs.mutex.Unlock()
+
+		return append([]slog.Attr{}, logAttrs.attrs...)
+	}
+
+	return nil
+}
+
+// validRequestID accepts IDs of printable ASCII without spaces, so callers can't forge log output.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tinkerborg/open-pulumi-service/pkg/router"
+)
+
+func TestLogAttrsFromPrefixOptions(t *testing.T) {
+	tests := []struct {
+		name      string
+		wrap      bool
+		wantStack bool
+	}{
+		{name: "server handler collects attributes", wrap: true, wantStack: true},
+		{name: "prefix options run before Logging", wrap: false, wantStack: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var attrs []slog.Attr
+
+			addStack := func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					AddLogAttrs(r.Context(), slog.String("stack", r.PathValue("stack")))
+					next.ServeHTTP(w, r)
+				})
+			}
+
+			r := router.NewRouter()
+			r.Use(Logging)
+			r.WithPrefix("/stacks/{stack}", addStack).GET("/", func(w *router.ResponseWriter, r *http.Request) error {
+				attrs = LogAttrs(r.Context())
+				return nil
+			})
+
+			var handler http.Handler = r
+			if test.wrap {
+				handler = WithLogAttrs(r)
+			}
+
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/stacks/prod", nil))
+
+			got := map[string]string{}
+			for _, attr := range attrs {
+				got[attr.Key] = attr.Value.String()
+			}
+
+			if got["request_id"] == "" {
+				t.Errorf("no request ID in %v", attrs)
+			}
+
+			if (got["stack"] == "prod") != test.wantStack {
+				t.Errorf("got attributes %v, want stack %t", attrs, test.wantStack)
+			}
+		})
+	}
+}
+
+func TestValidRequestID(t *testing.T) {
+	tests := []struct {
+		id    string
+		valid bool
+	}{
+		{id: "abc-123", valid: true},
+		{id: ""},
+		{id: "with space"},
+		{id: "line\nbreak"},
+		{id: string(make([]byte, maxRequestIDLength+1))},
+	}
+
+	for _, test := range tests {
+		t.Run(test.id, func(t *testing.T) {
+			if got := validRequestID(test.id); got != test.valid {
+				t.Errorf("got %t, want %t", got, test.valid)
+			}
+		})
+	}
+}
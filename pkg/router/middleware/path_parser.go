@@ -3,9 +3,9 @@ package middleware
 import (
 	"context"
 	"net/http"
-)
 
-type pathParserKey struct{}
+	"github.com/tinkerborg/open-pulumi-service/pkg/router"
+)
 
 type PathParser[T any] struct {
 	parser func(r *http.Request) (T, error)
@@ -19,18 +19,19 @@ func (d *PathParser[T]) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		value, err := d.parser(r)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			router.WriteError(w, r, http.StatusBadRequest, err)
 			return
 		}
 
-		ctx := context.WithValue(r.Context(), pathParserKey{}, value)
+		// keyed by parser so that nested parsers keep their own values
+		ctx := context.WithValue(r.Context(), d, value)
 
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
 func (d *PathParser[T]) Value(r *http.Request) T {
-	value := r.Context().Value(pathParserKey{})
+	value := r.Context().Value(d)
 
 	if value == nil {
 		var zero T
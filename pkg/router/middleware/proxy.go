@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/tinkerborg/open-pulumi-service/pkg/router"
+)
+
+// TrustedProxies sets the RemoteAddr of requests from the given proxies to the client address
+// they forwarded, from the Forwarded header or else X-Forwarded-For. The forwarded addresses are
+// read from the nearest hop back, and the first that isn't itself a trusted proxy is the client,
+// so a client can't pose as another address by sending the headers itself. Requests from
+// anywhere else keep their RemoteAddr.
+func TrustedProxies(proxies []netip.Prefix) router.Middleware {
+	trusted := func(addr netip.Addr) bool {
+		for _, proxy := range proxies {
+			if proxy.Contains(addr.Unmap()) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			remote, err := netip.ParseAddrPort(r.RemoteAddr)
+			if err != nil || !trusted(remote.Addr()) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			client := remote.Addr()
+			forwarded := forwardedFor(r.Header)
+
+			for i := len(forwarded) - 1; i >= 0 && trusted(client); i-- {
+				addr, ok := parseForwardedAddr(forwarded[i])
+				if !ok {
+					// an address the proxy didn't write can't be trusted, nor anything before it
+					break
+				}
+				client = addr
+			}
+
+			if client != remote.Addr() {
+				r = r.Clone(r.Context())
+				r.RemoteAddr = netip.AddrPortFrom(client, 0).String()
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// forwardedFor returns the forwarded client addresses of a request, the client first and the
+// nearest proxy last.
+func forwardedFor(header http.Header) []string {
+	addresses := []string{}
+
+	if values := header.Values("Forwarded"); len(values) > 0 {
+		for _, element := range strings.Split(strings.Join(values, ","), ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+				if strings.EqualFold(key, "for") {
+					addresses = append(addresses, strings.Trim(value, `"`))
+				}
+			}
+		}
+
+		return addresses
+	}
+
+	for _, value := range strings.Split(strings.Join(header.Values("X-Forwarded-For"), ","), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			addresses = append(addresses, value)
+		}
+	}
+
+	return addresses
+}
+
+// parseForwardedAddr parses a forwarded address, which may have a port and IPv6 addresses may be
+// in brackets.
+func parseForwardedAddr(value string) (netip.Addr, bool) {
+	if addr, err := netip.ParseAddr(value); err == nil {
+		return addr.Unmap(), true
+	}
+
+	if addrPort, err := netip.ParseAddrPort(value); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+
+	if addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")); err == nil {
+		return addr.Unmap(), true
+	}
+
+	return netip.Addr{}, false
+}
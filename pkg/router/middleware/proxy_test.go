@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestTrustedProxies(t *testing.T) {
+	proxies := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("fd00::/8")}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     http.Header
+		want       string
+	}{
+		{name: "direct client", remoteAddr: "192.0.2.1:1000", want: "192.0.2.1:1000"},
+		{name: "direct client forwarding", remoteAddr: "192.0.2.1:1000", header: http.Header{"X-Forwarded-For": {"198.51.100.7"}}, want: "192.0.2.1:1000"},
+		{name: "through a proxy", remoteAddr: "10.0.0.1:1000", header: http.Header{"X-Forwarded-For": {"198.51.100.7"}}, want: "198.51.100.7:0"},
+		{name: "through a proxy, client forwarding", remoteAddr: "10.0.0.1:1000", header: http.Header{"X-Forwarded-For": {"203.0.113.9, 198.51.100.7"}}, want: "198.51.100.7:0"},
+		{name: "through two proxies", remoteAddr: "10.0.0.1:1000", header: http.Header{"X-Forwarded-For": {"198.51.100.7, 10.0.0.2"}}, want: "198.51.100.7:0"},
+		{name: "repeated header", remoteAddr: "10.0.0.1:1000", header: http.Header{"X-Forwarded-For": {"198.51.100.7", "10.0.0.2"}}, want: "198.51.100.7:0"},
+		{name: "invalid address", remoteAddr: "10.0.0.1:1000", header: http.Header{"X-Forwarded-For": {"198.51.100.7, nonsense"}}, want: "10.0.0.1:1000"},
+		{name: "proxy without header", remoteAddr: "10.0.0.1:1000", want: "10.0.0.1:1000"},
+		{name: "forwarded", remoteAddr: "10.0.0.1:1000", header: http.Header{"Forwarded": {`for=198.51.100.7;proto=https, for="[fd00::2]:4711"`}}, want: "198.51.100.7:0"},
+		{name: "forwarded ipv6", remoteAddr: "[fd00::1]:1000", header: http.Header{"Forwarded": {`for="[2001:db8::7]:4711"`}}, want: "[2001:db8::7]:0"},
+		{name: "forwarded over x-forwarded-for", remoteAddr: "10.0.0.1:1000", header: http.Header{"Forwarded": {"for=198.51.100.7"}, "X-Forwarded-For": {"203.0.113.9"}}, want: "198.51.100.7:0"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got string
+
+			handler := TrustedProxies(proxies)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.RemoteAddr
+			}))
+
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.RemoteAddr = test.remoteAddr
+			for key, values := range test.header {
+				request.Header[key] = values
+			}
+
+			handler.ServeHTTP(httptest.NewRecorder(), request)
+
+			if got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tinkerborg/open-pulumi-service/pkg/router"
+)
+
+// Limit is how many requests a caller may make in each window of time. Zero requests is unlimited.
+type Limit struct {
+	Requests int
+	Window   time.Duration
+}
+
+// Limiter counts requests in fixed windows of time.
+type Limiter interface {
+	// Allow counts a request against key's limit. When the limit is exhausted it returns how long
+	// until the next window starts, otherwise zero.
+	Allow(ctx context.Context, key string, limit Limit) (time.Duration, error)
+}
+
+// budgetKey holds a route's budget for the RateLimit whose Budget option set it, so that other
+// limits on the same route don't count it.
+type budgetKey struct{ limit *RateLimit }
+
+type budget struct {
+	name  string
+	limit Limit
+}
+
+// RateLimit limits how many requests each caller makes, identified by a key such as the
+// caller's token or address. Routes that are expensive to serve can take from a budget of their
+// own as well, with a Budget route option.
+type RateLimit struct {
+	limiter Limiter
+	key     func(r *http.Request) string
+	limit   Limit
+}
+
+func NewRateLimit(limiter Limiter, key func(r *http.Request) string, limit Limit) *RateLimit {
+	return &RateLimit{limiter, key, limit}
+}
+
+// Middleware responds with 429 and a Retry-After header once the caller has spent its requests
+// for the window, or those of the route's budget. Requests are let through if the limiter fails,
+// so that an outage of its store doesn't take down the API.
+func (l *RateLimit) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := l.key(r)
+
+		budgets := []budget{{"api", l.limit}}
+		if b, ok := r.Context().Value(budgetKey{l}).(budget); ok {
+			budgets = append(budgets, b)
+		}
+
+		for _, b := range budgets {
+			if !l.allow(w, r, key, b) {
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Spend takes a request from a budget from within the handler, for routes that only spend it on
+// some requests, such as reads the client already has cached. Like Middleware, it responds with
+// 429 and returns false once the budget is spent, and must run after authentication.
+func (l *RateLimit) Spend(w http.ResponseWriter, r *http.Request, name string, limit Limit) bool {
+	return l.allow(w, r, l.key(r), budget{name, limit})
+}
+
+func (l *RateLimit) allow(w http.ResponseWriter, r *http.Request, key string, b budget) bool {
+	if b.limit.Requests <= 0 {
+		return true
+	}
+
+	retryAfter, err := l.limiter.Allow(r.Context(), b.name+":"+key, b.limit)
+	if err != nil {
+		slog.WarnContext(r.Context(), "rate limiter failed", "budget", b.name, "error", err)
+		return true
+	}
+
+	if retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		router.WriteError(w, r, http.StatusTooManyRequests, fmt.Errorf("%s rate limit of %d requests per %s exceeded", b.name, b.limit.Requests, b.limit.Window))
+		return false
+	}
+
+	return true
+}
+
+// Budget is a route option giving the route a limit of its own, on top of the limit of all
+// requests. Routes sharing a name share the budget.
+func (l *RateLimit) Budget(name string, limit Limit) router.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), budgetKey{l}, budget{name, limit})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// MemoryLimiter counts requests in memory, so each replica counts only the requests it serves.
+type MemoryLimiter struct {
+	mutex   sync.Mutex
+	windows map[string]*window
+	swept   time.Time
+}
+
+type window struct {
+	start time.Time
+	end   time.Time
+	count int
+}
+
+var _ Limiter = &MemoryLimiter{}
+
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{windows: map[string]*window{}, swept: time.Now()}
+}
+
+func (l *MemoryLimiter) Allow(ctx context.Context, key string, limit Limit) (time.Duration, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	l.sweep(now, limit.Window)
+
+	start := now.Truncate(limit.Window)
+
+	w, ok := l.windows[key]
+	if !ok || w.start.Before(start) {
+		w = &window{start: start, end: start.Add(limit.Window)}
+		l.windows[key] = w
+	}
+
+	if w.count >= limit.Requests {
+		return w.end.Sub(now), nil
+	}
+
+	w.count++
+
+	return 0, nil
+}
+
+// sweep forgets windows that have ended, at most once per window, so callers that stop making
+// requests don't take up memory.
+func (l *MemoryLimiter) sweep(now time.Time, interval time.Duration) {
+	if now.Sub(l.swept) < interval {
+		return
+	}
+
+	for key, w := range l.windows {
+		if !now.Before(w.end) {
+			delete(l.windows, key)
+		}
+	}
+
+	l.swept = now
+}
@@ -0,0 +1,160 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tinkerborg/open-pulumi-service/pkg/router"
+)
+
+func TestMemoryLimiter(t *testing.T) {
+	limit := Limit{Requests: 2, Window: time.Hour}
+
+	tests := []struct {
+		name    string
+		keys    []string
+		limited []bool
+	}{
+		{name: "within limit", keys: []string{"a", "a"}, limited: []bool{false, false}},
+		{name: "over limit", keys: []string{"a", "a", "a"}, limited: []bool{false, false, true}},
+		{name: "keys counted apart", keys: []string{"a", "a", "b", "a"}, limited: []bool{false, false, false, true}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := NewMemoryLimiter()
+
+			for i, key := range test.keys {
+				retryAfter, err := l.Allow(context.Background(), key, limit)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if limited := retryAfter > 0; limited != test.limited[i] {
+					t.Fatalf("request %d: want limited %v, got retry after %s", i, test.limited[i], retryAfter)
+				}
+				if retryAfter > limit.Window {
+					t.Fatalf("request %d: retry after %s is past the window", i, retryAfter)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryLimiterNextWindow(t *testing.T) {
+	l := NewMemoryLimiter()
+	limit := Limit{Requests: 1, Window: 50 * time.Millisecond}
+
+	l.Allow(context.Background(), "a", limit)
+
+	retryAfter, _ := l.Allow(context.Background(), "a", limit)
+	if retryAfter <= 0 {
+		t.Fatal("second request in the window was allowed")
+	}
+
+	time.Sleep(retryAfter)
+
+	if retryAfter, _ := l.Allow(context.Background(), "a", limit); retryAfter != 0 {
+		t.Fatalf("request in the next window was limited for %s", retryAfter)
+	}
+}
+
+func TestRateLimitBudgets(t *testing.T) {
+	tests := []struct {
+		name     string
+		budget   bool
+		requests int
+		status   int
+	}{
+		{name: "overall limit", requests: 3, status: http.StatusTooManyRequests},
+		{name: "within overall limit", requests: 2, status: http.StatusOK},
+		{name: "route budget", budget: true, requests: 2, status: http.StatusTooManyRequests},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			limiter := NewMemoryLimiter()
+			caller := func(r *http.Request) string { return "caller" }
+			address := func(r *http.Request) string { return "address" }
+
+			limit := NewRateLimit(limiter, caller, Limit{Requests: 2, Window: time.Hour})
+			// a second limit on the same route doesn't count the first one's budget
+			other := NewRateLimit(limiter, address, Limit{Requests: 10, Window: time.Hour})
+
+			var options []router.Middleware
+			if test.budget {
+				options = append(options, limit.Budget("expensive", Limit{Requests: 1, Window: time.Hour}))
+			}
+
+			r := router.NewRouter()
+			r.Use(other.Middleware, limit.Middleware)
+			r.GET("/", func(w *router.ResponseWriter, r *http.Request) error {
+				return nil
+			}, options...)
+
+			var response *httptest.ResponseRecorder
+			for range test.requests {
+				response = httptest.NewRecorder()
+				r.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/", nil))
+			}
+
+			if response.Code != test.status {
+				t.Fatalf("want status %d, got %d", test.status, response.Code)
+			}
+			if _, ok := limiter.windows["expensive:address"]; ok {
+				t.Fatal("budget counted by the limit that didn't set it")
+			}
+			if limited := response.Header().Get("Retry-After") != ""; limited != (test.status == http.StatusTooManyRequests) {
+				t.Fatalf("want Retry-After only when limited, got '%s'", response.Header().Get("Retry-After"))
+			}
+		})
+	}
+}
+
+func TestRateLimitSpend(t *testing.T) {
+	tests := []struct {
+		name   string
+		cached []bool
+		status int
+	}{
+		{name: "within budget", cached: []bool{false}, status: http.StatusOK},
+		{name: "over budget", cached: []bool{false, false}, status: http.StatusTooManyRequests},
+		{name: "cached requests don't spend", cached: []bool{true, true, false}, status: http.StatusOK},
+		{name: "cached request after budget", cached: []bool{false, true}, status: http.StatusNotModified},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			limit := NewRateLimit(NewMemoryLimiter(), func(r *http.Request) string { return "caller" }, Limit{})
+			budget := Limit{Requests: 1, Window: time.Hour}
+
+			r := router.NewRouter()
+			r.GET("/", func(w *router.ResponseWriter, r *http.Request) error {
+				if w.NotModified("tag") {
+					return nil
+				}
+				if !limit.Spend(w, r, "export", budget) {
+					return nil
+				}
+				return w.JSON("checkpoint")
+			})
+
+			var response *httptest.ResponseRecorder
+			for _, cached := range test.cached {
+				request := httptest.NewRequest(http.MethodGet, "/", nil)
+				if cached {
+					request.Header.Set("If-None-Match", `"tag"`)
+				}
+
+				response = httptest.NewRecorder()
+				r.ServeHTTP(response, request)
+			}
+
+			if response.Code != test.status {
+				t.Fatalf("want status %d, got %d", test.status, response.Code)
+			}
+		})
+	}
+}
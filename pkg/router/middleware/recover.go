@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/tinkerborg/open-pulumi-service/pkg/router"
+)
+
+// Recover turns a panic in a handler into a 500 response, logging the panic and its stack, rather
+// than letting net/http drop the connection.
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := router.NewStatusRecorder(w)
+
+		defer func() {
+			value := recover()
+			if value == nil {
+				return
+			}
+
+			// handlers abort streaming responses this way on purpose
+			if value == http.ErrAbortHandler {
+				panic(value)
+			}
+
+			err := fmt.Errorf("panic: %v\n%s", value, debug.Stack())
+
+			if recorder.WroteHeader() {
+				// too late for an error response, abort so the client sees the response is incomplete
+				slog.ErrorContext(r.Context(), "server error", "status", recorder.StatusCode(), "error", err)
+				panic(http.ErrAbortHandler)
+			}
+
+			router.WriteError(recorder, r, http.StatusInternalServerError, err)
+		}()
+
+		next.ServeHTTP(recorder, r)
+	})
+}
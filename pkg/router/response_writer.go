@@ -1,53 +1,147 @@
 package router
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"strings"
 
-	"github.com/tinkerborg/open-pulumi-service/internal/store"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+	"github.com/tinkerborg/open-pulumi-service/internal/errs"
 )
 
 type ResponseWriter struct {
 	http.ResponseWriter
-	statusCode int
+	request     *http.Request
+	statusCode  int
+	wroteHeader bool
 }
 
 func (w ResponseWriter) JSON(response any) error {
-	w.Header().Set("Content-Type", "application/json")
+	w.writeHeader("application/json")
 
 	return json.NewEncoder(w).Encode(response)
 }
 
 func (w *ResponseWriter) WriteHeader(statusCode int) {
 	w.statusCode = statusCode
+	w.wroteHeader = true
 	w.ResponseWriter.WriteHeader(w.statusCode)
 }
 
+// Unwrap exposes the embedded ResponseWriter to http.ResponseController.
+func (w *ResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// WithStatus sets the status code sent by the JSON, Error or Errorf call that follows.
 func (w ResponseWriter) WithStatus(statusCode int) ResponseWriter {
-	w.WriteHeader(statusCode)
+	w.statusCode = statusCode
 	return w
 }
 
-func (w ResponseWriter) Error(err error) error {
-	if w.statusCode == 0 {
-		if errors.Is(err, store.ErrNotFound) {
-			return w.WithStatus(http.StatusNotFound).Errorf("not found")
-		} else {
-			w.WriteHeader(http.StatusInternalServerError)
+// NotModified sets the response's ETag to tag and reports whether the request's If-None-Match
+// already names it, in which case it has responded 304 Not Modified and the handler is done.
+// Tags are compared weakly, so a compressed response's tag still matches.
+func (w *ResponseWriter) NotModified(tag string) bool {
+	etag := `"` + tag + `"`
+	w.Header().Set("ETag", etag)
+
+	if w.request == nil {
+		return false
+	}
+
+	for _, match := range strings.Split(w.request.Header.Get("If-None-Match"), ",") {
+		match = strings.TrimPrefix(strings.TrimSpace(match), "W/")
+		if match == etag || match == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return true
 		}
 	}
 
-	// TODO - hide 500 error causes
-	return json.NewEncoder(w).Encode(HTTPError{Code: w.statusCode, Message: err.Error()})
+	return false
+}
+
+func (w ResponseWriter) Error(err error) error {
+	if w.wroteHeader {
+		return json.NewEncoder(w).Encode(ErrorResponse(w.request, w.statusCode, err))
+	}
+
+	statusCode := w.statusCode
+	if statusCode == 0 {
+		statusCode = ErrorStatus(err)
+	}
+
+	return WriteError(w.ResponseWriter, w.request, statusCode, err)
 }
 
 func (w ResponseWriter) Errorf(format string, a ...any) error {
 	return w.Error(fmt.Errorf(format, a...))
 }
 
-type HTTPError struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
+// writeHeader sends the status code set with WithStatus, if any, along with the content type.
+func (w ResponseWriter) writeHeader(contentType string) {
+	if w.wroteHeader {
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+
+	if w.statusCode != 0 {
+		w.ResponseWriter.WriteHeader(w.statusCode)
+	}
+}
+
+// ErrorStatus returns the status code reporting err, by the errs kind it is classified as. Requests
+// cut short by their context are reported as closed by the client or timed out, and errors of no
+// kind are internal server errors.
+func ErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return StatusClientClosedRequest
+	case errors.Is(err, errs.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errs.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, errs.ErrInvalidArgument):
+		return http.StatusBadRequest
+	case errors.Is(err, errs.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, errs.ErrPreconditionFailed):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, errs.ErrUnavailable), errors.Is(err, context.DeadlineExceeded):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// WriteError responds with err in the shape of Pulumi's apitype.ErrorResponse. It is how every
+// error response is written, by handlers and middleware alike.
+func WriteError(w http.ResponseWriter, r *http.Request, statusCode int, err error) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	return json.NewEncoder(w).Encode(ErrorResponse(r, statusCode, err))
+}
+
+// ErrorResponse returns the body reporting err with statusCode. The causes of server errors can
+// reveal internals such as queries, so they are logged and replaced with the status text.
+func ErrorResponse(r *http.Request, statusCode int, err error) apitype.ErrorResponse {
+	message := err.Error()
+
+	if statusCode >= http.StatusInternalServerError {
+		ctx := context.Background()
+		if r != nil {
+			ctx = r.Context()
+		}
+
+		slog.ErrorContext(ctx, "server error", "status", statusCode, "error", err)
+		message = http.StatusText(statusCode)
+	}
+
+	return apitype.ErrorResponse{Code: statusCode, Message: message}
 }
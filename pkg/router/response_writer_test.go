@@ -0,0 +1,34 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/tinkerborg/open-pulumi-service/internal/errs"
+)
+
+func TestErrorStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+	}{
+		{name: "not found", err: errs.New(errs.ErrNotFound, "no stack"), status: http.StatusNotFound},
+		{name: "invalid argument", err: errs.New(errs.ErrInvalidArgument, "bad name"), status: http.StatusBadRequest},
+		{name: "unavailable", err: errs.New(errs.ErrUnavailable, "draining"), status: http.StatusServiceUnavailable},
+		{name: "client went away", err: fmt.Errorf("query: %w", context.Canceled), status: StatusClientClosedRequest},
+		{name: "deadline exceeded", err: fmt.Errorf("query: %w", context.DeadlineExceeded), status: http.StatusServiceUnavailable},
+		{name: "unclassified", err: errors.New("boom"), status: http.StatusInternalServerError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if status := ErrorStatus(test.err); status != test.status {
+				t.Fatalf("want %d, got %d", test.status, status)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+type routeKey struct{}
+
+// route collects the patterns matched by each router a request passes through.
+type route struct {
+	segments []string
+	matched  bool
+}
+
+func (r *route) add(pattern string) {
+	method, path, found := strings.Cut(pattern, " ")
+	if !found {
+		path = method
+	} else {
+		// only routes registered with a method are handlers, the rest are mounted routers
+		r.matched = true
+	}
+
+	path = strings.TrimSuffix(strings.TrimSuffix(path, "{$}"), "/")
+	r.segments = append(r.segments, path)
+}
+
+// RoutePattern returns the full pattern of the route handling a request across mounted routers,
+// such as "/api/stacks/{owner}/{project}/{stack}/export", or "" if no route matched.
+func RoutePattern(r *http.Request) string {
+	route, ok := r.Context().Value(routeKey{}).(*route)
+	if !ok || !route.matched {
+		return ""
+	}
+
+	pattern := strings.Join(route.segments, "")
+	if pattern == "" {
+		return "/"
+	}
+
+	return pattern
+}
+
+// withRoute starts collecting patterns on the outermost router.
+func withRoute(r *http.Request) (*http.Request, *route) {
+	if route, ok := r.Context().Value(routeKey{}).(*route); ok {
+		return r, route
+	}
+
+	route := &route{}
+	return r.WithContext(context.WithValue(r.Context(), routeKey{}, route)), route
+}
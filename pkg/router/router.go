@@ -1,6 +1,8 @@
 package router
 
 import (
+	"errors"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"path"
@@ -59,6 +61,8 @@ func (r *Router) Do(do func(r *Router)) {
 }
 
 func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	req, route := withRoute(req)
+
 	modReq := *req
 	modReq.URL = new(url.URL)
 	*modReq.URL = *req.URL
@@ -69,10 +73,11 @@ func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 	muxHandler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		_, pattern := r.mux.Handler(req)
+		route.add(pattern)
 
 		// if no route matched, apply middleware to handle 404
 		if pattern == "" {
-			applyMiddleware(r.mux, r.middlewares).ServeHTTP(w, req)
+			applyMiddleware(r.mux, r.middlewares).ServeHTTP(&muxErrorWriter{ResponseWriter: w, request: req}, req)
 			return
 		}
 
@@ -109,7 +114,11 @@ func (r *Router) addRoute(method, routePath string, routeHandler RouterHandler,
 	}
 
 	handlerFunc := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		routeHandler(&ResponseWriter{ResponseWriter: w}, r)
+		recorder := NewStatusRecorder(w)
+
+		if err := routeHandler(&ResponseWriter{ResponseWriter: recorder, request: r}, r); err != nil {
+			handleError(recorder, r, err)
+		}
 	})
 
 	handler := applyMiddleware(handlerFunc, append(pointers(options), r.middlewares...))
@@ -117,6 +126,19 @@ func (r *Router) addRoute(method, routePath string, routeHandler RouterHandler,
 	r.mux.Handle(method+" "+routePath, handler)
 }
 
+// handleError reports an error returned by a handler. Errors from handlers that have already
+// responded, such as failed writes to a closed connection, can only be logged.
+func handleError(w *StatusRecorder, r *http.Request, err error) {
+	if !w.WroteHeader() {
+		(&ResponseWriter{ResponseWriter: w, request: r}).Error(err)
+		return
+	}
+
+	if r.Context().Err() == nil {
+		slog.WarnContext(r.Context(), "error after response was sent", "status", w.StatusCode(), "error", err)
+	}
+}
+
 func applyMiddleware[T ~func(http.Handler) http.Handler](handler http.Handler, middlewares []*T) http.Handler {
 	for i := len(middlewares) - 1; i >= 0; i-- {
 		middleware := *middlewares[i]
@@ -133,3 +155,33 @@ func pointers[T any](values []T) []*T {
 	}
 	return pointers
 }
+
+// muxErrorWriter replaces the plain text 404 and 405 responses of http.ServeMux with JSON errors.
+type muxErrorWriter struct {
+	http.ResponseWriter
+	request *http.Request
+	discard bool
+}
+
+func (w *muxErrorWriter) WriteHeader(statusCode int) {
+	if statusCode < http.StatusBadRequest || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		w.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+
+	w.discard = true
+	WriteError(w.ResponseWriter, w.request, statusCode, errors.New(strings.ToLower(http.StatusText(statusCode))))
+}
+
+func (w *muxErrorWriter) Write(data []byte) (int, error) {
+	if w.discard {
+		return len(data), nil
+	}
+
+	return w.ResponseWriter.Write(data)
+}
+
+// Unwrap exposes the embedded ResponseWriter to http.ResponseController.
+func (w *muxErrorWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
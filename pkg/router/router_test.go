@@ -0,0 +1,51 @@
+package router
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tinkerborg/open-pulumi-service/internal/errs"
+)
+
+func TestHandlerErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		written     string
+		err         error
+		status      int
+		contentType string
+		body        string
+	}{
+		{name: "before the response", err: errs.New(errs.ErrNotFound, "no update"), status: http.StatusNotFound, contentType: "application/json"},
+		{name: "after the response started", written: "line\n", err: errors.New("read failed"), status: http.StatusOK, contentType: "text/plain", body: "line\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := NewRouter()
+			r.GET("/", func(w *ResponseWriter, r *http.Request) error {
+				// streaming handlers set their content type before knowing whether they will fail
+				w.Header().Set("Content-Type", "text/plain")
+				if test.written != "" {
+					w.Write([]byte(test.written))
+				}
+				return test.err
+			})
+
+			response := httptest.NewRecorder()
+			r.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if response.Code != test.status {
+				t.Fatalf("want status %d, got %d", test.status, response.Code)
+			}
+			if contentType := response.Header().Get("Content-Type"); contentType != test.contentType {
+				t.Fatalf("want content type %s, got %s", test.contentType, contentType)
+			}
+			if test.body != "" && response.Body.String() != test.body {
+				t.Fatalf("want body %q, got %q", test.body, response.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package router
+
+import "net/http"
+
+// StatusClientClosedRequest is nginx's status for requests whose client went away before the
+// response was ready. Nobody receives it, but it keeps such requests out of the server errors.
+const StatusClientClosedRequest = 499
+
+// StatusRecorder remembers the status code written to a response, for middleware that reports on
+// responses after the handler returns.
+type StatusRecorder struct {
+	http.ResponseWriter
+	statusCode  int
+	wroteHeader bool
+}
+
+func NewStatusRecorder(w http.ResponseWriter) *StatusRecorder {
+	return &StatusRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+}
+
+// StatusCode returns the status code sent, which is 200 if the handler only wrote a body.
+func (w *StatusRecorder) StatusCode() int {
+	return w.statusCode
+}
+
+// WroteHeader reports whether the handler has started responding.
+func (w *StatusRecorder) WroteHeader() bool {
+	return w.wroteHeader
+}
+
+func (w *StatusRecorder) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		w.statusCode = statusCode
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *StatusRecorder) Write(data []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(data)
+}
+
+// Unwrap exposes the embedded ResponseWriter to http.ResponseController.
+func (w *StatusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}